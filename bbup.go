@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+// backwardBucketParser is a bucket hash parser that extends matches
+// backwards into the pending literals.
+type backwardBucketParser struct {
+	bucketDictionary
+	statsAccumulator
+
+	BBUPConfig
+}
+
+// BBUPConfig provides the configuration parameters for the backward bucket
+// hash parser. Every hash slot keeps the last BucketSize positions and all of
+// them are checked for the longest match, which is then extended backwards.
+type BBUPConfig struct {
+	ShrinkSize int
+	BufferSize int
+	WindowSize int
+	BlockSize  int
+
+	InputLen   int
+	HashBits   int
+	BucketSize int
+}
+
+// Clone creates a copy of the configuration.
+func (cfg *BBUPConfig) Clone() ParserConfig {
+	x := *cfg
+	return &x
+}
+
+// UnmarshalJSON parses the JSON value and sets the fields of BBUPConfig.
+func (cfg *BBUPConfig) UnmarshalJSON(p []byte) error {
+	*cfg = BBUPConfig{}
+	return unmarshalJSON(cfg, "BBUP", p)
+}
+
+// MarshalJSON creates the JSON string for the configuration. Note that it adds
+// a property Type with value "BBUP" to the structure.
+func (cfg *BBUPConfig) MarshalJSON() (p []byte, err error) {
+	return marshalJSON(cfg, "BBUP")
+}
+
+// BufConfig returns the [BufConfig] value containing the buffer parameters.
+func (cfg *BBUPConfig) BufConfig() BufConfig {
+	bc := bufferConfig(cfg)
+	return bc
+}
+
+// SetBufConfig sets the buffer configuration parameters of the parser
+// configuration.
+func (cfg *BBUPConfig) SetBufConfig(bc BufConfig) {
+	setBufferConfig(cfg, bc)
+}
+
+// SetDefaults sets values that are zero to their defaults values.
+func (cfg *BBUPConfig) SetDefaults() {
+	bc := bufferConfig(cfg)
+	bc.SetDefaults()
+	setBufferConfig(cfg, bc)
+	b, _ := bucketCfg(cfg)
+	b.SetDefaults()
+	setBucketCfg(cfg, b)
+}
+
+// Verify checks the config for correctness.
+func (cfg *BBUPConfig) Verify() error {
+	var err error
+	bc := bufferConfig(cfg)
+	if err = bc.Verify(); err != nil {
+		return err
+	}
+	b, _ := bucketCfg(cfg)
+	err = b.Verify()
+	return err
+}
+
+// NewParser creates a new backward bucket hash parser.
+func (cfg BBUPConfig) NewParser() (s Parser, err error) {
+	bbup := new(backwardBucketParser)
+	if err = bbup.init(cfg); err != nil {
+		return nil, err
+	}
+	return bbup, nil
+}
+
+func (s *backwardBucketParser) ParserConfig() ParserConfig {
+	return &s.BBUPConfig
+}
+
+// init initializes the parser. It returns an error if there is an issue with
+// the configuration parameters.
+func (s *backwardBucketParser) init(cfg BBUPConfig) error {
+	cfg.SetDefaults()
+	var err error
+	if err = cfg.Verify(); err != nil {
+		return err
+	}
+
+	b, _ := bucketCfg(&cfg)
+	bc := bufferConfig(&cfg)
+	if err = s.bucketDictionary.init(b, bc); err != nil {
+		return err
+	}
+
+	s.BBUPConfig = cfg
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse,
+// which is the smaller of InputLen and 3.
+func (s *backwardBucketParser) MatchLen() int {
+	return min(s.inputLen, 3)
+}
+
+// Parse converts the next block to sequences. The contents of the blk
+// variable will be overwritten. The method returns the number of bytes
+// sequenced and any error encountered. It return ErrEmptyBuffer if there is no
+// further data available.
+//
+// If blk is nil the search structures will be filled. This mode can be used to
+// ignore segments of data.
+func (s *backwardBucketParser) Parse(blk *Block, flags int) (n int, err error) {
+	n = len(s.Data) - s.W
+	if n > s.BlockSize {
+		n = s.BlockSize
+	}
+
+	if blk == nil {
+		if n == 0 {
+			return 0, ErrEmptyBuffer
+		}
+		t := s.W + n
+		s.processSegment(s.W-s.inputLen+1, t)
+		s.W = t
+		return n, nil
+	}
+
+	blk.Reset()
+
+	if n == 0 {
+		return 0, ErrEmptyBuffer
+	}
+
+	s.processSegment(s.W-s.inputLen+1, s.W)
+	p := s.Data[:s.W+n]
+
+	inputEnd := len(p) - s.inputLen + 1
+	i := s.W
+	litIndex := i
+
+	minMatchLen := s.MatchLen()
+
+	// Ensure that we can use _getLE64 all the time.
+	_p := s.Data[:inputEnd+7]
+
+	for ; i < inputEnd; i++ {
+		x := _getLE64(_p[i:]) & s.mask
+		h := hashValue(x, s.shift)
+		v := uint32(x)
+		o, k := 0, 0
+		for _, e := range s.bucket(h) {
+			if v != e.val {
+				if e.val == 0 && e.pos == 0 {
+					break
+				}
+				continue
+			}
+			j := int(e.pos)
+			oe := i - j
+			if !(0 < oe && oe <= s.WindowSize) {
+				continue
+			}
+			// Check a single byte first, whether the match can
+			// be longer than the one already found.
+			if k > 0 && p[j+k-1] != p[i+k-1] {
+				continue
+			}
+			ke := lcp(p[j:], p[i:])
+			if ke < k || (ke == k && oe >= o) {
+				continue
+			}
+			o, k = oe, ke
+		}
+		s.add(h, uint32(i), v)
+		if k < minMatchLen {
+			continue
+		}
+		// The positions before i are already in the buckets.
+		next := i + 1
+		if back := i - litIndex; back > 0 {
+			j := i - o
+			if back > j {
+				back = j
+			}
+			m := lcs(p[j-back:j], p[:i])
+			i -= m
+			k += m
+		}
+		q := p[litIndex:i]
+		blk.Sequences = append(blk.Sequences,
+			Seq{
+				LitLen:   uint32(len(q)),
+				MatchLen: uint32(k),
+				Offset:   uint32(o),
+			})
+		blk.Literals = append(blk.Literals, q...)
+		litIndex = i + k
+		b := min(litIndex, inputEnd)
+		for j := next; j < b; j++ {
+			x := _getLE64(_p[j:]) & s.mask
+			h := hashValue(x, s.shift)
+			s.add(h, uint32(j), uint32(x))
+		}
+		i = litIndex - 1
+	}
+
+	if flags&NoTrailingLiterals != 0 && len(blk.Sequences) > 0 {
+		i = litIndex
+	} else {
+		blk.Literals = append(blk.Literals, p[litIndex:]...)
+		i = len(p)
+	}
+	n = i - s.W
+	s.W = i
+	s.statsAccumulator.addBlock(n, blk)
+	return n, nil
+}
@@ -5,6 +5,7 @@
 package lz
 
 import (
+	"fmt"
 	"math/bits"
 )
 
@@ -20,6 +21,10 @@ type BDHPConfig struct {
 	HashBits1 int
 	InputLen2 int
 	HashBits2 int
+
+	// BackwardMatchMax limits the number of bytes a match is extended
+	// backward into the preceding literals. Zero means no limit.
+	BackwardMatchMax int
 }
 
 // UnmarshalJSON parses the JSON value and sets the fields of BDHPConfig.
@@ -62,6 +67,10 @@ func (cfg *BDHPConfig) Verify() error {
 	if err = d.Verify(); err != nil {
 		return err
 	}
+	if cfg.BackwardMatchMax < 0 {
+		return fmt.Errorf("lz: BackwardMatchMax=%d must not be negative",
+			cfg.BackwardMatchMax)
+	}
 	return nil
 }
 
@@ -88,6 +97,7 @@ func (cfg BDHPConfig) NewParser() (s Parser, err error) {
 // bdhp uses two hashes and tries to extend matches backward.
 type bdhp struct {
 	doubleHashDictionary
+	statsAccumulator
 
 	BDHPConfig
 }
@@ -116,6 +126,29 @@ func (s *bdhp) init(cfg BDHPConfig) error {
 	return nil
 }
 
+// MatchLen returns the minimum length of the matches generated by Parse. It
+// is the smaller of InputLen1 and 3.
+func (s *bdhp) MatchLen() int {
+	return min(s.h1.inputLen, 3)
+}
+
+// backwardLen returns the number of bytes the match at position i with
+// source j can be extended backward into the literals starting at litIndex.
+// The result is limited by BackwardMatchMax if it is positive.
+func (s *bdhp) backwardLen(p []byte, i, j, litIndex int) int {
+	back := i - litIndex
+	if back > j {
+		back = j
+	}
+	if 0 < s.BackwardMatchMax && s.BackwardMatchMax < back {
+		back = s.BackwardMatchMax
+	}
+	if back <= 0 {
+		return 0
+	}
+	return lcs(p[j-back:j], p[:i])
+}
+
 // Parse computes the LZ77 sequence for the next block. It returns the number
 // of bytes actually sequenced. ErrEmptyBuffer will be returned if there is no
 // data to sequence.
@@ -135,8 +168,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 		return n, nil
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -152,10 +184,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 	i := s.W
 	litIndex := i
 
-	minMatchLen := 3
-	if s.h1.inputLen < minMatchLen {
-		minMatchLen = s.h1.inputLen
-	}
+	minMatchLen := s.MatchLen()
 
 	// Ensure that we can use _getLE64 all the time.
 	_p := s.Data[:e1+7]
@@ -163,14 +192,14 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 	for ; i < e2; i++ {
 		y := _getLE64(_p[i:])
 		x := y & s.h2.mask
-		h := hashValue(x, s.h2.shift)
+		h := s.hashValue2(x)
 		entry := s.h2.table[h]
 		v2 := uint32(x)
 		pos := uint32(i)
 		s.h2.table[h] = hashEntry{pos: pos, value: v2}
 
 		x = y & s.h1.mask
-		h = hashValue(x, s.h1.shift)
+		h = s.hashValue1(x)
 		entry1 := s.h1.table[h]
 		v1 := uint32(x)
 		s.h1.table[h] = hashEntry{pos: pos, value: v1}
@@ -216,11 +245,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 			}
 		match:
 		}
-		if back := i - litIndex; back > 0 {
-			if back > j {
-				back = j
-			}
-			m := lcs(p[j-back:j], p[:i])
+		if m := s.backwardLen(p, i, j, litIndex); m > 0 {
 			i -= m
 			k += m
 		}
@@ -243,7 +268,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 			pos := uint32(j)
 
 			x = y & s.h1.mask
-			h = hashValue(x, s.h1.shift)
+			h = s.hashValue1(x)
 			s.h1.table[h] = hashEntry{pos: pos, value: uint32(x)}
 		}
 		if j < litIndex {
@@ -253,7 +278,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 			}
 			for ; j < b; j++ {
 				x := _getLE64(_p[j:]) & s.h1.mask
-				h := hashValue(x, s.h1.shift)
+				h := s.hashValue1(x)
 				s.h1.table[h] = hashEntry{
 					pos:   uint32(j),
 					value: uint32(x),
@@ -265,7 +290,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 	for ; i < e1; i++ {
 		y := _getLE64(_p[i:])
 		x := y & s.h1.mask
-		h := hashValue(x, s.h1.shift)
+		h := s.hashValue1(x)
 		entry := s.h1.table[h]
 		v1 := uint32(x)
 		s.h1.table[h] = hashEntry{
@@ -312,11 +337,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 			}
 		match1:
 		}
-		if back := i - litIndex; back > 0 {
-			if back > j {
-				back = j
-			}
-			m := lcs(p[j-back:j], p[:i])
+		if m := s.backwardLen(p, i, j, litIndex); m > 0 {
 			i -= m
 			k += m
 		}
@@ -335,7 +356,7 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 		}
 		for ; j < b; j++ {
 			x := _getLE64(_p[j:]) & s.h1.mask
-			h := hashValue(x, s.h1.shift)
+			h := s.hashValue1(x)
 			s.h1.table[h] = hashEntry{
 				pos:   uint32(j),
 				value: uint32(x),
@@ -352,5 +373,6 @@ func (s *bdhp) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = int(i) - s.W
 	s.W = int(i)
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
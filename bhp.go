@@ -5,6 +5,7 @@
 package lz
 
 import (
+	"fmt"
 	"math/bits"
 )
 
@@ -84,6 +85,12 @@ func (cfg BHPConfig) NewParser() (s Parser, err error) {
 // hash table. It extends found matches by looking backward in the input stream.
 type backwardHashParser struct {
 	hashDictionary
+	seqHistograms
+	statsAccumulator
+	hm hashMetrics
+
+	// maxMatchLen limits the length of matches if it is positive.
+	maxMatchLen int
 
 	BHPConfig
 }
@@ -112,6 +119,53 @@ func (s *backwardHashParser) ParserConfig() ParserConfig {
 	return &s.BHPConfig
 }
 
+// Reset puts the parser into its initial state with the data slice as buffer
+// content. The histograms are cleared.
+func (s *backwardHashParser) Reset(data []byte) error {
+	var err error
+	if err = s.hashDictionary.Reset(data); err != nil {
+		return err
+	}
+	s.seqHistograms.reset()
+	return nil
+}
+
+// Metrics returns the performance data of the parser. In addition to the
+// keys of all parsers it provides hash_hits and hash_misses.
+func (s *backwardHashParser) Metrics() map[string]int64 {
+	m := s.statsAccumulator.Metrics()
+	s.hm.addMetrics(m)
+	return m
+}
+
+// ResetMetrics clears the counters reported by Metrics.
+func (s *backwardHashParser) ResetMetrics() {
+	s.statsAccumulator.ResetMetrics()
+	s.hm = hashMetrics{}
+}
+
+// SetMaxMatchLen limits the length of the matches generated by Parse to n
+// bytes. The value zero removes the limit. The limit must not be smaller than
+// InputLen.
+func (s *backwardHashParser) SetMaxMatchLen(n int) error {
+	if n < 0 {
+		return fmt.Errorf("lz: maxMatchLen %d must not be negative", n)
+	}
+	if n > 0 && n < s.InputLen {
+		return fmt.Errorf("lz: maxMatchLen %d < InputLen %d",
+			n, s.InputLen)
+	}
+	s.maxMatchLen = n
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse. It
+// is the smaller of InputLen and 3; the backward extension only makes
+// matches longer.
+func (s *backwardHashParser) MatchLen() int {
+	return min(s.inputLen, 3)
+}
+
 // Parse converts the next block of k bytes to a sequences. The block will be
 // overwritten. The method returns the number of bytes sequenced and any error
 // encountered. It return ErrEmptyBuffer if there is no further data available.
@@ -134,8 +188,7 @@ func (s *backwardHashParser) Parse(blk *Block, flags int) (n int, err error) {
 		return n, nil
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -147,11 +200,9 @@ func (s *backwardHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	inputEnd := len(p) - s.inputLen + 1
 	i := s.W
 	litIndex := i
+	var hits, misses int64
 
-	minMatchLen := 3
-	if s.inputLen < minMatchLen {
-		minMatchLen = s.inputLen
-	}
+	minMatchLen := s.MatchLen()
 
 	// Ensure that we can use _getLE64 all the time.
 	_p := s.Data[:inputEnd+7]
@@ -167,8 +218,10 @@ func (s *backwardHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			value: v,
 		}
 		if v != entry.value {
+			misses++
 			continue
 		}
+		hits++
 		// potential match
 		j := int(entry.pos)
 		o := i - j
@@ -213,6 +266,9 @@ func (s *backwardHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			i -= m
 			k += m
 		}
+		if s.maxMatchLen > 0 && k > s.maxMatchLen {
+			k = s.maxMatchLen
+		}
 		q := p[litIndex:i]
 		blk.Sequences = append(blk.Sequences,
 			Seq{
@@ -245,5 +301,9 @@ func (s *backwardHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = i - s.W
 	s.W = i
+	s.seqHistograms.add(blk.Sequences)
+	s.hm.hits += hits
+	s.hm.misses += misses
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The binary format of a block consists of the magic bytes, the version byte,
+// the number of sequences and the number of literals. Then the sequences
+// follow with the fields LitLen, MatchLen, Offset and Aux. All integers are
+// stored as unsigned varints. The literals complete the block.
+var blockMagic = [3]byte{'L', 'Z', 'B'}
+
+// blockVersion is the version of the binary block format.
+const blockVersion = 1
+
+// ErrBlockFormat indicates that binary block data cannot be decoded.
+var ErrBlockFormat = errors.New("lz: invalid binary block format")
+
+// WriteBinary writes the block in a binary format to w. It returns the number
+// of bytes written. The block can be read back using [ReadBlock].
+func (b *Block) WriteBinary(w io.Writer) (n int64, err error) {
+	p := make([]byte, 0, 4+2*binary.MaxVarintLen64+
+		len(b.Sequences)*4*binary.MaxVarintLen32)
+	p = append(p, blockMagic[:]...)
+	p = append(p, blockVersion)
+	p = binary.AppendUvarint(p, uint64(len(b.Sequences)))
+	p = binary.AppendUvarint(p, uint64(len(b.Literals)))
+	for _, s := range b.Sequences {
+		p = binary.AppendUvarint(p, uint64(s.LitLen))
+		p = binary.AppendUvarint(p, uint64(s.MatchLen))
+		p = binary.AppendUvarint(p, uint64(s.Offset))
+		p = binary.AppendUvarint(p, uint64(s.Aux))
+	}
+	k, err := w.Write(p)
+	n = int64(k)
+	if err != nil {
+		return n, err
+	}
+	k, err = w.Write(b.Literals)
+	n += int64(k)
+	return n, err
+}
+
+// byteReader reads single bytes from a reader without reading ahead.
+type byteReader struct {
+	r io.Reader
+	p [1]byte
+}
+
+// ReadByte reads a single byte.
+func (br *byteReader) ReadByte() (c byte, err error) {
+	if _, err = io.ReadFull(br.r, br.p[:]); err != nil {
+		return 0, err
+	}
+	return br.p[0], nil
+}
+
+// readUvarint reads an unsigned varint and checks that it doesn't exceed
+// MaxUint32.
+func readUvarint(r io.ByteReader) (x uint32, err error) {
+	u, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if u > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: value %d out of range",
+			ErrBlockFormat, u)
+	}
+	return uint32(u), nil
+}
+
+// ReadBlock reads a block written by [Block.WriteBinary]. It doesn't read
+// beyond the end of the block. If r has no more data io.EOF is returned.
+func ReadBlock(r io.Reader) (blk *Block, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:3], blockMagic[:]) {
+		return nil, fmt.Errorf("%w: wrong magic bytes", ErrBlockFormat)
+	}
+	if hdr[3] != blockVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d",
+			ErrBlockFormat, hdr[3])
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+	n, err := readUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	// We don't trust the sizes for the initial allocation.
+	blk = &Block{Sequences: make([]Seq, 0, min(int(n), 1024))}
+	var f [4]uint32
+	for i := uint32(0); i < n; i++ {
+		for j := range f {
+			if f[j], err = readUvarint(br); err != nil {
+				return nil, err
+			}
+		}
+		blk.Sequences = append(blk.Sequences, Seq{
+			LitLen:   f[0],
+			MatchLen: f[1],
+			Offset:   f[2],
+			Aux:      f[3],
+		})
+	}
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r, int64(m)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	blk.Literals = buf.Bytes()
+	return blk, nil
+}
+
+// streamBufferSize is the size of the buffers used by EncodeStream and
+// DecodeStream.
+const streamBufferSize = 32 * kiB
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying writer and counts the bytes written.
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader and counts the bytes read.
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingByteReader counts the bytes consumed from a buffered reader.
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read reads from the buffered reader and counts the bytes read.
+func (cr *countingByteReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ReadByte reads a single byte and counts it.
+func (cr *countingByteReader) ReadByte() (c byte, err error) {
+	c, err = cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
+
+// EncodeStream parses all data read from src with the parser and writes the
+// blocks in the binary format of [Block.WriteBinary] to dst. The end of the
+// stream is marked by an empty block. The parser is not reset, so data
+// buffered in the parser is encoded before the data from src. The function
+// returns the number of bytes read from src and written to dst.
+func EncodeStream(dst io.Writer, src io.Reader, p Parser) (totalIn,
+	totalOut int64, err error) {
+	cr := &countingReader{r: src}
+	cw := &countingWriter{w: dst}
+	bw := bufio.NewWriterSize(cw, streamBufferSize)
+	wp := Wrap(bufio.NewReaderSize(cr, streamBufferSize), p)
+	var blk Block
+	for {
+		if _, err = wp.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return cr.n, cw.n, err
+		}
+		if _, err = blk.WriteBinary(bw); err != nil {
+			return cr.n, cw.n, err
+		}
+	}
+	blk.Reset()
+	if _, err = blk.WriteBinary(bw); err != nil {
+		return cr.n, cw.n, err
+	}
+	err = bw.Flush()
+	return cr.n, cw.n, err
+}
+
+// DecodeStream reads the blocks written by [EncodeStream] from src, decodes
+// them and writes the decoded data to dst. The window size must not be
+// smaller than the window size of the parser that created the blocks. The
+// function returns the number of bytes of the stream consumed from src and
+// the number of bytes written to dst. Since src is read through a buffer,
+// more data may have been read from it. If the empty block marking the end of
+// the stream is missing, io.ErrUnexpectedEOF is returned.
+func DecodeStream(dst io.Writer, src io.Reader, windowSize int) (totalIn,
+	totalOut int64, err error) {
+	cw := &countingWriter{w: dst}
+	d, err := NewDecoder(cw, DecoderConfig{WindowSize: windowSize})
+	if err != nil {
+		return 0, 0, err
+	}
+	cr := &countingByteReader{r: bufio.NewReaderSize(src, streamBufferSize)}
+	for {
+		blk, err := ReadBlock(cr)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return cr.n, cw.n, err
+		}
+		if len(blk.Sequences) == 0 && len(blk.Literals) == 0 {
+			break
+		}
+		if _, _, _, err = d.WriteBlock(*blk); err != nil {
+			return cr.n, cw.n, err
+		}
+	}
+	err = d.Flush()
+	return cr.n, cw.n, err
+}
+
+// CompressTo parses the data read from src with the parser selected by cfg
+// and writes the blocks in the binary format of [Block.WriteBinary] to dst.
+// The end of the stream is marked by an empty block. The function returns the
+// number of bytes written to dst. See [EncodeStream].
+func CompressTo(dst io.Writer, src io.Reader, cfg Config) (written int64,
+	err error) {
+	p, err := cfg.NewParser(BufConfig{})
+	if err != nil {
+		return 0, err
+	}
+	_, written, err = EncodeStream(dst, src, p)
+	return written, err
+}
+
+// DecompressFrom reads the blocks written by [CompressTo] from src, decodes
+// them and writes the decoded data to dst. The window size must not be
+// smaller than the window size of the parser that created the blocks. The
+// function returns the number of bytes written to dst. If the empty block
+// marking the end of the stream is missing, io.ErrUnexpectedEOF is returned.
+func DecompressFrom(dst io.Writer, src io.Reader, windowSize int) (
+	written int64, err error) {
+	_, written, err = DecodeStream(dst, src, windowSize)
+	return written, err
+}
@@ -114,7 +114,7 @@ func (b *bitset) insert(i ...int) *bitset {
 	return b
 }
 
-/*
+// delete removes i from the set. The words of the set are not normalized.
 func (b *bitset) delete(i int) *bitset {
 	k := i>>6 - b.off
 	if !(0 <= k && k < len(b.a)) {
@@ -122,12 +122,8 @@ func (b *bitset) delete(i int) *bitset {
 		return b
 	}
 	b.a[k] &^= 1 << uint(i&63)
-	if k == 0 || k+1 == len(b.a) {
-		b.normalize()
-	}
 	return b
 }
-*/
 
 func (b *bitset) clear() {
 	b.a = b.a[:0]
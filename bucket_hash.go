@@ -177,6 +177,16 @@ func (bh *bucketHash) shiftOffsets(delta uint32) {
 	}
 }
 
+// clearFrom clears all entries with positions larger or equal w. The ring
+// indexes of the buckets are not changed.
+func (bh *bucketHash) clearFrom(w int) {
+	for i, e := range bh.buckets {
+		if int64(e.pos) >= int64(w) {
+			bh.buckets[i] = bucketEntry{}
+		}
+	}
+}
+
 type bucketDictionary struct {
 	ParserBuffer
 	bucketHash
@@ -212,6 +222,23 @@ func (f *bucketDictionary) Shrink() int {
 	return delta
 }
 
+// Rollback moves the window head back to the checkpoint and clears the bucket
+// entries for the positions at or after it. See [hashDictionary.Rollback].
+func (f *bucketDictionary) Rollback(cp ParserCheckpoint) error {
+	var err error
+	if err = f.ParserBuffer.Rollback(cp); err != nil {
+		return err
+	}
+	f.bucketHash.clearFrom(f.W)
+	return nil
+}
+
+// Skip adds the next n bytes of the buffer to the search structures without
+// generating sequences. See [hashDictionary.Skip].
+func (f *bucketDictionary) Skip(n int) (int, error) {
+	return f.skip(n, f.inputLen, f.processSegment)
+}
+
 func (f *bucketDictionary) processSegment(a, b int) {
 	if a < 0 {
 		a = 0
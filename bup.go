@@ -8,6 +8,7 @@ package lz
 // table.
 type bucketParser struct {
 	bucketDictionary
+	statsAccumulator
 
 	BUPConfig
 }
@@ -108,6 +109,12 @@ func (s *bucketParser) init(cfg BUPConfig) error {
 	return nil
 }
 
+// MatchLen returns the minimum length of the matches generated by Parse. It
+// is the smaller of InputLen and 3.
+func (s *bucketParser) MatchLen() int {
+	return min(s.inputLen, 3)
+}
+
 // Parse converts the next block to sequences. The contents of the blk
 // variable will be overwritten. The method returns the number of bytes
 // sequenced and any error encountered. It return ErrEmptyBuffer if there is no
@@ -132,8 +139,7 @@ func (s *bucketParser) Parse(blk *Block, flags int) (n int, err error) {
 
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -146,10 +152,7 @@ func (s *bucketParser) Parse(blk *Block, flags int) (n int, err error) {
 	i := s.W
 	litIndex := i
 
-	minMatchLen := 3
-	if s.inputLen < minMatchLen {
-		minMatchLen = s.inputLen
-	}
+	minMatchLen := s.MatchLen()
 
 	// Ensure that we can use _getLE64 all the time.
 	_p := s.Data[:inputEnd+7]
@@ -218,5 +221,6 @@ func (s *bucketParser) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = i - s.W
 	s.W = i
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// chainParser connects parsers. The blocks of a parser are decoded and the
+// decoded data is written into the next parser. The last parser provides the
+// blocks of the chain.
+type chainParser struct {
+	parsers []Parser
+	// decoders[i] decodes the blocks of parsers[i] for parsers[i+1]
+	decoders []DecoderBuffer
+	// pending[i] is the number of bytes buffered in parsers[i] that
+	// have not been parsed yet
+	pending []int
+	blk     Block
+}
+
+// ChainParsers connects the parsers into a single parser. The data written to
+// the chain is parsed by the first parser. The blocks of every parser are
+// decoded and the decoded data is the input of the next parser. Parse returns
+// the blocks of the last parser. A fast parser at the beginning of the chain
+// can be followed by a slower parser that provides better compression. The
+// parsers must not be used independently of the chain.
+func ChainParsers(parsers ...Parser) (Parser, error) {
+	if len(parsers) == 0 {
+		return nil, errors.New("lz: ChainParsers requires a parser")
+	}
+	c := &chainParser{
+		parsers:  make([]Parser, len(parsers)),
+		decoders: make([]DecoderBuffer, len(parsers)-1),
+		pending:  make([]int, len(parsers)),
+	}
+	for i, p := range parsers {
+		if p == nil {
+			return nil, fmt.Errorf("lz: parser %d of chain is nil", i)
+		}
+		c.parsers[i] = p
+		if i == len(parsers)-1 {
+			break
+		}
+		// The decoder must hold the window and a full block.
+		bc := p.BufferConfig()
+		cfg := DecoderConfig{
+			WindowSize: bc.WindowSize,
+			BufferSize: bc.WindowSize + bc.BlockSize,
+		}
+		if err := c.decoders[i].Init(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Reset(nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// fill writes the decoded blocks of parsers[i-1] into parsers[i] until it
+// buffers a full block of unparsed data, its buffer is full or all parsers
+// before it have no data left.
+func (c *chainParser) fill(i int) error {
+	if i == 0 {
+		return nil
+	}
+	p := c.parsers[i]
+	blockSize := p.BufferConfig().BlockSize
+	d := &c.decoders[i-1]
+	for c.pending[i] < blockSize {
+		if d.R < len(d.Data) {
+			k, err := p.Write(d.Data[d.R:])
+			d.R += k
+			c.pending[i] += k
+			if err != nil {
+				if err != ErrFullBuffer {
+					return err
+				}
+				if p.Shrink() == 0 {
+					return nil
+				}
+			}
+			continue
+		}
+		if err := c.fill(i - 1); err != nil {
+			return err
+		}
+		n, err := c.parsers[i-1].Parse(&c.blk, 0)
+		if err != nil {
+			if err == ErrEmptyBuffer {
+				return nil
+			}
+			return err
+		}
+		c.pending[i-1] -= n
+		if _, _, _, err = d.WriteBlock(c.blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse returns the next block of the last parser of the chain. It returns
+// [ErrEmptyBuffer] if all data written to the chain has been parsed.
+func (c *chainParser) Parse(blk *Block, flags int) (n int, err error) {
+	last := len(c.parsers) - 1
+	if err = c.fill(last); err != nil {
+		return 0, err
+	}
+	n, err = c.parsers[last].Parse(blk, flags)
+	c.pending[last] -= n
+	return n, err
+}
+
+// Reset resets all parsers of the chain and writes data into the first
+// parser.
+func (c *chainParser) Reset(data []byte) error {
+	for i, p := range c.parsers {
+		var err error
+		if i == 0 {
+			err = p.Reset(data)
+		} else {
+			err = p.Reset(nil)
+		}
+		if err != nil {
+			return err
+		}
+		c.pending[i] = 0
+	}
+	for i := range c.decoders {
+		c.decoders[i].Reset()
+	}
+	c.pending[0] = len(data)
+	return nil
+}
+
+// Shrink shrinks the buffer of the first parser, which receives the data
+// written to the chain.
+func (c *chainParser) Shrink() int {
+	return c.parsers[0].Shrink()
+}
+
+// ParserConfig returns the configuration of the last parser.
+func (c *chainParser) ParserConfig() ParserConfig {
+	return c.parsers[len(c.parsers)-1].ParserConfig()
+}
+
+// BufferConfig returns the buffer configuration of the first parser, which
+// receives the data written to the chain. The window and block sizes are
+// taken from the last parser, which creates the blocks of the chain.
+func (c *chainParser) BufferConfig() BufConfig {
+	bc := c.parsers[0].BufferConfig()
+	last := c.parsers[len(c.parsers)-1].BufferConfig()
+	bc.WindowSize = last.WindowSize
+	bc.BlockSize = last.BlockSize
+	return bc
+}
+
+// Write writes data into the first parser.
+func (c *chainParser) Write(p []byte) (n int, err error) {
+	n, err = c.parsers[0].Write(p)
+	c.pending[0] += n
+	return n, err
+}
+
+// ReadFrom reads data into the first parser.
+func (c *chainParser) ReadFrom(r io.Reader) (n int64, err error) {
+	n, err = c.parsers[0].ReadFrom(r)
+	c.pending[0] += int(n)
+	return n, err
+}
+
+// ReadAt reads data from the buffer of the first parser. See
+// [ParserBuffer.ReadAt].
+func (c *chainParser) ReadAt(p []byte, off int64) (n int, err error) {
+	return c.parsers[0].ReadAt(p, off)
+}
+
+// ByteAt returns the byte at offset off from the buffer of the first parser.
+func (c *chainParser) ByteAt(off int64) (b byte, err error) {
+	return c.parsers[0].ByteAt(off)
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// parseWrapped parses all data from r with p and returns the blocks.
+func parseWrapped(tb testing.TB, p Parser, r io.Reader) (blocks []Block) {
+	tb.Helper()
+	s := Wrap(r, p)
+	for {
+		var blk Block
+		if _, err := s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				return blocks
+			}
+			tb.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+}
+
+// decodeBlocks decodes the blocks.
+func decodeBlocks(tb testing.TB, blocks []Block, windowSize int) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: windowSize})
+	if err != nil {
+		tb.Fatalf("NewDecoder error %s", err)
+	}
+	for _, blk := range blocks {
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			tb.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		tb.Fatalf("d.Flush error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestChainParsers(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+	fast := &HPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+		BlockSize: 16 * kiB}
+	optimal := &OSAPConfig{WindowSize: 64 * kiB, BufferSize: 128 * kiB,
+		BlockSize: 32 * kiB}
+
+	var cost [2]int64
+	for i, parsers := range [][]Parser{
+		{newTestParser(t, optimal)},
+		{newTestParser(t, fast), newTestParser(t, optimal)},
+	} {
+		c, err := ChainParsers(parsers...)
+		if err != nil {
+			t.Fatalf("ChainParsers error %s", err)
+		}
+		blocks := parseWrapped(t, c, bytes.NewReader(data))
+		ws := c.BufferConfig().WindowSize
+		if g := decodeBlocks(t, blocks, ws); !bytes.Equal(g, data) {
+			t.Fatalf("%d parsers: decoded data differs from input",
+				len(parsers))
+		}
+		for j := range blocks {
+			cost[i] += blocks[j].Cost(XZCost)
+		}
+	}
+	t.Logf("cost optimal %d; chain %d", cost[0], cost[1])
+
+	if _, err = ChainParsers(); err == nil {
+		t.Fatalf("ChainParsers() returned no error")
+	}
+}
+
+func BenchmarkChainParsers(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	fast := &HPConfig{WindowSize: 64 * kiB}
+	optimal := &OSAPConfig{WindowSize: 64 * kiB, BufferSize: 256 * kiB}
+	benchmarks := []struct {
+		name string
+		cfgs []ParserConfig
+	}{
+		{"fast", []ParserConfig{fast}},
+		{"optimal", []ParserConfig{optimal}},
+		{"chain", []ParserConfig{fast, optimal}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			parsers := make([]Parser, len(bm.cfgs))
+			for i, cfg := range bm.cfgs {
+				parsers[i] = newTestParser(b, cfg)
+			}
+			c, err := ChainParsers(parsers...)
+			if err != nil {
+				b.Fatalf("ChainParsers error %s", err)
+			}
+			b.SetBytes(int64(len(data)))
+			var cost int64
+			for i := 0; i < b.N; i++ {
+				if err = c.Reset(nil); err != nil {
+					b.Fatalf("c.Reset error %s", err)
+				}
+				blocks := parseWrapped(b, c, bytes.NewReader(data))
+				b.StopTimer()
+				cost = 0
+				for j := range blocks {
+					cost += blocks[j].Cost(XZCost)
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(8*len(data))/float64(cost),
+				"ratio")
+		})
+	}
+}
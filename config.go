@@ -0,0 +1,571 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Config provides a simple way to create a parser. The user specifies the
+// compression effort and optionally a memory budget. The parser type and its
+// parameters are derived from those values.
+//
+// The effort levels 1 to 11 select parsers of increasing compression ratio
+// and decreasing speed. Effort 11 uses the optimizing suffix array parser
+// with a 32 MiB window and a minimum match length of 2; it is significantly
+// slower than effort 10. The MemoryBudget limits the memory required by the
+// parser. If it is zero no limit applies.
+//
+// MinThroughputMBps is only used by [Config.AutoTune]. It gives the minimum
+// parsing throughput in megabytes per second that the selected effort level
+// must achieve. The default is 50 MB/s.
+//
+// Effort 0 selects a parser that doesn't compress at all and returns all data
+// as literals. Since a zero Effort is replaced by the default, EffortSet must
+// be true to select it.
+//
+// If ProfilerLabels is set, the parsers created by the configuration execute
+// Parse, and therefore [Flush], with the pprof labels parser and block_size.
+// The parser label is the name of the parser configuration type without the
+// Config suffix, for instance HP for [HPConfig]. The labels allow the
+// filtering of CPU profiles with the -tagfocus option of go tool pprof.
+// Parsers created without ProfilerLabels are not wrapped and have no
+// overhead. The wrapper provides only the methods of the [Parser] interface.
+// Optional methods like CopyState, ReadStats or SetMaxMatchLen must be called
+// on the parser returned by its method Unwrap() Parser.
+type Config struct {
+	Effort            int
+	EffortSet         bool
+	MemoryBudget      int
+	MinThroughputMBps int
+	ProfilerLabels    bool
+}
+
+// SetDefaults sets the Effort to 5 if it is zero and EffortSet is false.
+func (cfg *Config) SetDefaults() {
+	if cfg.Effort == 0 && !cfg.EffortSet {
+		cfg.Effort = 5
+	}
+}
+
+// Verify checks the configuration for errors.
+func (cfg *Config) Verify() error {
+	if cfg.Effort == 0 && cfg.EffortSet {
+		// no compression
+	} else if !(1 <= cfg.Effort && cfg.Effort <= maxEffort) {
+		return fmt.Errorf("lz: Effort=%d must be in range [1..%d]",
+			cfg.Effort, maxEffort)
+	}
+	if cfg.MemoryBudget < 0 {
+		return fmt.Errorf("lz: MemoryBudget=%d must not be negative",
+			cfg.MemoryBudget)
+	}
+	if cfg.MinThroughputMBps < 0 {
+		return fmt.Errorf("lz: MinThroughputMBps=%d must not be negative",
+			cfg.MinThroughputMBps)
+	}
+	return nil
+}
+
+// maxEffort is the highest supported effort level.
+const maxEffort = 11
+
+// effortConfig returns the parser configuration for the effort level without
+// buffer parameters.
+func effortConfig(effort int) ParserConfig {
+	switch effort {
+	case 0:
+		return &NPConfig{}
+	case 1:
+		return &HPConfig{InputLen: 5, HashBits: 16}
+	case 2:
+		return &HPConfig{InputLen: 4, HashBits: 17}
+	case 3:
+		return &HPConfig{InputLen: 3, HashBits: 18}
+	case 4:
+		return &BHPConfig{InputLen: 4, HashBits: 18}
+	case 5:
+		return &DHPConfig{InputLen1: 3, HashBits1: 18,
+			InputLen2: 6, HashBits2: 20}
+	case 6:
+		return &BDHPConfig{InputLen1: 3, HashBits1: 18,
+			InputLen2: 6, HashBits2: 20}
+	case 7:
+		return &BUPConfig{InputLen: 3, HashBits: 17, BucketSize: 16}
+	case 8:
+		return &BUPConfig{InputLen: 3, HashBits: 16, BucketSize: 64}
+	case 9:
+		return &GSAPConfig{}
+	case 10:
+		return &OSAPConfig{}
+	case 11:
+		return &OSAPConfig{MinMatchLen: 2, CacheEdges: true}
+	default:
+		panic(fmt.Errorf("lz: unsupported effort %d", effort))
+	}
+}
+
+// memComponent describes the memory required by a component of a parser.
+type memComponent struct {
+	name string
+	size int64
+}
+
+// memComponents estimates the memory in bytes required by the components of
+// a parser created from the parser configuration. The configuration must
+// have its defaults set.
+func memComponents(pc ParserConfig) []memComponent {
+	bc := pc.BufConfig()
+	n := int64(bc.BufferSize)
+	c := []memComponent{{"buffer", n + 7}}
+	switch pc := pc.(type) {
+	case *NPConfig:
+		c = append(c,
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(nullParser{}))})
+	case *HPConfig:
+		c = append(c,
+			memComponent{"hash table", 8 << pc.HashBits},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(hashParser{}))})
+	case *BHPConfig:
+		c = append(c,
+			memComponent{"hash table", 8 << pc.HashBits},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(backwardHashParser{}))})
+	case *DHPConfig:
+		c = append(c,
+			memComponent{"hash table 1", 8 << pc.HashBits1},
+			memComponent{"hash table 2", 8 << pc.HashBits2},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(doubleHashParser{}))})
+	case *BDHPConfig:
+		c = append(c,
+			memComponent{"hash table 1", 8 << pc.HashBits1},
+			memComponent{"hash table 2", 8 << pc.HashBits2},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(bdhp{}))})
+	case *THPConfig:
+		c = append(c,
+			memComponent{"hash table 1", 8 << pc.HashBits1},
+			memComponent{"hash table 2", 8 << pc.HashBits2},
+			memComponent{"hash table 3", 8 << pc.HashBits3},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(tripleHashParser{}))})
+	case *BUPConfig:
+		c = append(c,
+			memComponent{"bucket table",
+				int64(8*pc.BucketSize+1) << pc.HashBits},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(bucketParser{}))})
+	case *BBUPConfig:
+		c = append(c,
+			memComponent{"bucket table",
+				int64(8*pc.BucketSize+1) << pc.HashBits},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(backwardBucketParser{}))})
+	case *GSAPConfig:
+		c = append(c,
+			memComponent{"suffix array", 4 * n},
+			memComponent{"inverse suffix array", 4 * n},
+			memComponent{"bitset", n / 8},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(gsap{}))})
+	case *OSAPConfig:
+		c = append(c,
+			memComponent{"suffix array", 4 * n},
+			memComponent{"LCP array", 4 * n},
+			memComponent{"edges", 4 * 8 * n},
+			memComponent{"edge slices", 24 * n},
+			memComponent{"parser struct",
+				int64(unsafe.Sizeof(optSuffixArrayParser{}))})
+	}
+	return c
+}
+
+// memSize estimates the memory in bytes required by a parser created from the
+// parser configuration. The configuration must have its defaults set.
+func memSize(pc ParserConfig) int64 {
+	var n int64
+	for _, c := range memComponents(pc) {
+		n += c.size
+	}
+	return n
+}
+
+// humanSize formats the size n in bytes using the units B, KiB, MiB and GiB.
+func humanSize(n int64) string {
+	switch {
+	case n < kiB:
+		return fmt.Sprintf("%d B", n)
+	case n < miB:
+		return fmt.Sprintf("%.1f KiB", float64(n)/kiB)
+	case n < 1<<30:
+		return fmt.Sprintf("%.1f MiB", float64(n)/miB)
+	default:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	}
+}
+
+// MemoryReport describes the memory required by the parser selected by the
+// configuration. The report lists each component with its size in bytes
+// and in human-readable form, the total and the use of the memory budget.
+func (cfg Config) MemoryReport() (string, error) {
+	pc, err := cfg.ParserConfig(BufConfig{})
+	if err != nil {
+		return "", err
+	}
+	cfg.SetDefaults()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "parser: %T (Effort=%d)\n", pc, cfg.Effort)
+	const format = "%-20s %12d %10s\n"
+	var total int64
+	for _, c := range memComponents(pc) {
+		fmt.Fprintf(&sb, format, c.name, c.size, humanSize(c.size))
+		total += c.size
+	}
+	fmt.Fprintf(&sb, format, "total", total, humanSize(total))
+	if cfg.MemoryBudget == 0 {
+		fmt.Fprintf(&sb, "memory budget: unlimited\n")
+	} else {
+		fmt.Fprintf(&sb, "memory budget: %d bytes (%s), %.1f%% used\n",
+			cfg.MemoryBudget, humanSize(int64(cfg.MemoryBudget)),
+			100*float64(total)/float64(cfg.MemoryBudget))
+	}
+	return sb.String(), nil
+}
+
+// parserName returns the name of the parser created by the configuration.
+func parserName(pc ParserConfig) string {
+	switch pc.(type) {
+	case *NPConfig:
+		return "NullParser"
+	case *HPConfig:
+		return "HashParser"
+	case *BHPConfig:
+		return "BackwardHashParser"
+	case *DHPConfig:
+		return "DoubleHashParser"
+	case *BDHPConfig:
+		return "BackwardDoubleHashParser"
+	case *THPConfig:
+		return "TripleHashParser"
+	case *BUPConfig:
+		return "BucketParser"
+	case *BBUPConfig:
+		return "BackwardBucketParser"
+	case *GSAPConfig:
+		return "GreedySuffixArrayParser"
+	case *OSAPConfig:
+		return "OptimizingSuffixArrayParser"
+	default:
+		return fmt.Sprintf("%T", pc)
+	}
+}
+
+// windowSizeString formats the window size n using the units KB and MB if n
+// is a multiple of them.
+func windowSizeString(n int) string {
+	switch {
+	case n > 0 && n%miB == 0:
+		return fmt.Sprintf("%dMB", n/miB)
+	case n > 0 && n%kiB == 0:
+		return fmt.Sprintf("%dKB", n/kiB)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+// describeParser returns the description of the parser configuration. All
+// non-zero parameters except the buffer parameters are listed in the order
+// of the struct fields, followed by the window size.
+func describeParser(pc ParserConfig) string {
+	var params []string
+	v := reflect.Indirect(reflect.ValueOf(pc))
+	vt := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch vt.Field(i).Name {
+		case "ShrinkSize", "BufferSize", "WindowSize", "BlockSize":
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Int, reflect.Bool, reflect.String,
+			reflect.Float64:
+		default:
+			continue
+		}
+		if f.IsZero() {
+			continue
+		}
+		params = append(params,
+			fmt.Sprintf("%s=%v", vt.Field(i).Name, f.Interface()))
+	}
+	params = append(params, "WindowSize="+
+		windowSizeString(pc.BufConfig().WindowSize))
+	return fmt.Sprintf("%s(%s)", parserName(pc),
+		strings.Join(params, ", "))
+}
+
+// DescribeParser returns a short description of the parser selected by the
+// configuration, for instance "HashParser(InputLen=4, HashBits=17,
+// WindowSize=8MB)". The description consists of the parser name and the
+// non-zero parameters in parentheses, separated by commas. The window size is
+// always the last parameter. An error is returned if the configuration
+// cannot be resolved.
+func (cfg Config) DescribeParser() (string, error) {
+	pc, err := cfg.ParserConfig(BufConfig{})
+	if err != nil {
+		return "", err
+	}
+	return describeParser(pc), nil
+}
+
+// DescribeAll returns the descriptions of the parsers for all effort levels
+// from 1 to 11 using the memory budget of the configuration. The map is keyed
+// by the effort level. Effort levels that cannot be resolved under the memory
+// budget are missing from the map.
+func (cfg Config) DescribeAll() map[string]string {
+	m := make(map[string]string, maxEffort)
+	for effort := 1; effort <= maxEffort; effort++ {
+		c := cfg
+		c.Effort = effort
+		s, err := c.DescribeParser()
+		if err != nil {
+			continue
+		}
+		m[strconv.Itoa(effort)] = s
+	}
+	return m
+}
+
+// ParserConfig returns the parser configuration selected by the effort level.
+// The zero values of the buffer configuration bc are derived from the memory
+// budget or are set to their defaults. The window size will be reduced until
+// the parser fits into the memory budget.
+func (cfg Config) ParserConfig(bc BufConfig) (pc ParserConfig, err error) {
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err != nil {
+		return nil, err
+	}
+	pc = effortConfig(cfg.Effort)
+	auto := bc.WindowSize == 0 && bc.BufferSize == 0
+	if cfg.Effort == maxEffort && auto {
+		// The highest effort level uses a larger window than the
+		// default.
+		bc.WindowSize = 32 * miB
+	}
+	for {
+		c := pc.Clone()
+		c.SetBufConfig(bc)
+		c.SetDefaults()
+		if err = c.Verify(); err != nil {
+			return nil, err
+		}
+		m := memSize(c)
+		if cfg.MemoryBudget == 0 || m <= int64(cfg.MemoryBudget) {
+			return c, nil
+		}
+		if !auto {
+			return nil, fmt.Errorf(
+				"lz: parser for Effort=%d requires %d bytes;"+
+					" more than MemoryBudget=%d",
+				cfg.Effort, m, cfg.MemoryBudget)
+		}
+		// Reduce the window size and try again.
+		w := c.BufConfig().WindowSize / 2
+		if w < 4*kiB {
+			return nil, fmt.Errorf(
+				"lz: MemoryBudget=%d too small for Effort=%d",
+				cfg.MemoryBudget, cfg.Effort)
+		}
+		bc.WindowSize = w
+	}
+}
+
+// NewParser creates the parser selected by the effort level. The buffer
+// configuration bc can be used to override the window and buffer sizes. Zero
+// values are computed from the memory budget or set to defaults.
+func (cfg Config) NewParser(bc BufConfig) (p Parser, err error) {
+	pc, err := cfg.ParserConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = pc.NewParser(); err != nil {
+		return nil, err
+	}
+	return cfg.labelParser(p, pc), nil
+}
+
+// SuggestWindowSize returns a heuristic window size for an input of inputLen
+// bytes. The window size grows with the effort level, but it is not larger
+// than the input. It will never be smaller than the default block size of
+// 128 KiB. The result can be used as WindowSize of the buffer configuration
+// passed to [Config.ParserConfig].
+func (cfg Config) SuggestWindowSize(inputLen int64) int {
+	cfg.SetDefaults()
+	var w int64
+	switch {
+	case cfg.Effort <= 2:
+		w = 128 * kiB
+	case cfg.Effort <= 4:
+		w = 512 * kiB
+	case cfg.Effort <= 7:
+		w = 8 * miB
+	default:
+		w = 32 * miB
+	}
+	if inputLen < w {
+		w = inputLen
+	}
+	const blockSize = 128 * kiB
+	if w < blockSize {
+		w = blockSize
+	}
+	return int(w)
+}
+
+// sampleCost parses the sample and returns the cost of all blocks in bits as
+// estimated by [XZCost].
+func sampleCost(p Parser, sample []byte) (cost uint64, err error) {
+	s := Wrap(bytes.NewReader(sample), p)
+	var blk Block
+	for {
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				return cost, nil
+			}
+			return cost, err
+		}
+		for _, q := range blk.Sequences {
+			cost += XZCost(q.MatchLen, q.Offset)
+		}
+		cost += XZCost(uint32(len(blk.Literals)), 0)
+	}
+}
+
+// AutoTune selects the effort level for data similar to sample. It parses up
+// to 1 MiB of the sample with the effort levels 3, 6 and 9 and selects the
+// highest level that achieves MinThroughputMBps and reduces the estimated
+// compressed size of the sample by at least 1% compared to the lower level.
+// So incompressible data will get a lower effort level than compressible
+// data. If effort level 3 is too slow, level 1 is selected.
+//
+// The throughput is measured on the current machine, so the result may
+// differ between machines and runs.
+func (cfg *Config) AutoTune(sample []byte) error {
+	c := *cfg
+	c.SetDefaults()
+	var err error
+	if err = c.Verify(); err != nil {
+		return err
+	}
+	minThroughput := float64(c.MinThroughputMBps)
+	if minThroughput == 0 {
+		minThroughput = 50
+	}
+	if len(sample) > miB {
+		sample = sample[:miB]
+	}
+
+	effort := 0
+	var minCost uint64
+	for _, e := range []int{3, 6, 9} {
+		c.Effort = e
+		p, err := c.NewParser(BufConfig{})
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		cost, err := sampleCost(p, sample)
+		if err != nil {
+			return err
+		}
+		d := time.Since(start).Seconds()
+		if d > 0 && float64(len(sample))/d/1e6 < minThroughput {
+			if effort == 0 {
+				effort = 1
+			}
+			break
+		}
+		if effort == 0 || cost*100 <= minCost*99 {
+			effort, minCost = e, cost
+		}
+	}
+	cfg.Effort = effort
+	return nil
+}
+
+// dataStats analyzes the sample and returns the Shannon entropy of the bytes
+// in bits per byte and the ratio of positions, where the next four bytes have
+// already been seen in the sample.
+func dataStats(sample []byte) (entropy, repeats float64) {
+	if len(sample) == 0 {
+		return 0, 0
+	}
+	var freq [256]int
+	for _, c := range sample {
+		freq[c]++
+	}
+	n := float64(len(sample))
+	for _, f := range freq {
+		if f == 0 {
+			continue
+		}
+		p := float64(f) / n
+		entropy -= p * math.Log2(p)
+	}
+
+	if len(sample) < 8 {
+		return entropy, 0
+	}
+	seen := make(map[uint32]struct{}, len(sample))
+	r := 0
+	for i := 0; i+4 <= len(sample); i++ {
+		x := _getLE32(sample[i:])
+		if _, ok := seen[x]; ok {
+			r++
+			continue
+		}
+		seen[x] = struct{}{}
+	}
+	repeats = float64(r) / float64(len(sample)-3)
+	return entropy, repeats
+}
+
+// BestForData selects the effort level from the characteristics of the first
+// 4 KiB of data. Nearly random data gets effort 1, highly repetitive data
+// effort 9 and everything else effort 5. The memory budget is given in MiB.
+// In contrast to [Config.AutoTune] no parsing is done, so the function is
+// fast and deterministic.
+func BestForData(data []byte, memBudgetMB int) (Config, error) {
+	cfg := Config{MemoryBudget: memBudgetMB * miB}
+	if len(data) > 4*kiB {
+		data = data[:4*kiB]
+	}
+	entropy, repeats := dataStats(data)
+	switch {
+	case len(data) == 0:
+		cfg.Effort = 5
+	case entropy > 7.5:
+		cfg.Effort = 1
+	case entropy < 2 || repeats > 0.75:
+		cfg.Effort = 9
+	default:
+		cfg.Effort = 5
+	}
+	if err := cfg.Verify(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
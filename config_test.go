@@ -0,0 +1,459 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func parseAll(tb testing.TB, p Parser, data []byte) []Block {
+	s := Wrap(bytes.NewReader(data), p)
+	var blocks []Block
+	for {
+		var blk Block
+		if _, err := s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			tb.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks
+}
+
+func TestConfigNewParser(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	bc := BufConfig{WindowSize: 32 * kiB}
+	for effort := 1; effort <= maxEffort; effort++ {
+		cfg := Config{Effort: effort}
+		p, err := cfg.NewParser(bc)
+		if err != nil {
+			t.Fatalf("%+v.NewParser(%+v) error %s", cfg, bc, err)
+		}
+		if ws := p.BufferConfig().WindowSize; ws != bc.WindowSize {
+			t.Fatalf("effort %d: WindowSize %d; want %d",
+				effort, ws, bc.WindowSize)
+		}
+		pc := effortConfig(effort)
+		pc.SetBufConfig(bc)
+		q := newTestParser(t, pc)
+		if diff := cmp.Diff(parseAll(t, p, data),
+			parseAll(t, q, data)); diff != "" {
+			t.Fatalf("effort %d: blocks differ:\n%s", effort, diff)
+		}
+	}
+
+	cfg := Config{Effort: 12}
+	if _, err = cfg.NewParser(BufConfig{}); err == nil {
+		t.Fatalf("%+v.NewParser() returned no error", cfg)
+	}
+}
+
+func TestConfigMemoryBudget(t *testing.T) {
+	for effort := 1; effort <= maxEffort; effort++ {
+		cfg := Config{Effort: effort, MemoryBudget: 48 * miB}
+		pc, err := cfg.ParserConfig(BufConfig{})
+		if err != nil {
+			t.Fatalf("%+v.ParserConfig() error %s", cfg, err)
+		}
+		if m := memSize(pc); m > int64(cfg.MemoryBudget) {
+			t.Fatalf("effort %d: memSize %d larger than"+
+				" MemoryBudget %d", effort, m,
+				cfg.MemoryBudget)
+		}
+	}
+	cfg := Config{Effort: 5, MemoryBudget: 1024}
+	if _, err := cfg.ParserConfig(BufConfig{}); err == nil {
+		t.Fatalf("%+v.ParserConfig() returned no error", cfg)
+	}
+}
+
+func TestConfigAutoTune(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	text, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	text = text[:256*kiB]
+	random := make([]byte, len(text))
+	r := rand.New(rand.NewSource(1))
+	r.Read(random)
+
+	// A low throughput threshold makes the test independent from the
+	// speed of the machine.
+	cfgRandom := Config{MinThroughputMBps: 1}
+	if err = cfgRandom.AutoTune(random); err != nil {
+		t.Fatalf("AutoTune(random) error %s", err)
+	}
+	cfgText := Config{MinThroughputMBps: 1}
+	if err = cfgText.AutoTune(text); err != nil {
+		t.Fatalf("AutoTune(text) error %s", err)
+	}
+	t.Logf("random: Effort=%d; text: Effort=%d", cfgRandom.Effort,
+		cfgText.Effort)
+	if cfgRandom.Effort != 3 {
+		t.Fatalf("AutoTune(random) selected Effort=%d; want %d",
+			cfgRandom.Effort, 3)
+	}
+	if cfgText.Effort <= cfgRandom.Effort {
+		t.Fatalf("AutoTune(text) selected Effort=%d; want > %d",
+			cfgText.Effort, cfgRandom.Effort)
+	}
+	if _, err = cfgText.NewParser(BufConfig{}); err != nil {
+		t.Fatalf("cfgText.NewParser error %s", err)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// normalizeReport replaces the size of the parser struct and the byte count
+// of the total in the memory report by stars, because both change whenever a
+// field is added to the parser. It checks that the struct size is plausible
+// and that the total is the sum of the components.
+func normalizeReport(tb testing.TB, report string) string {
+	tb.Helper()
+	const maxStructSize = 4 * kiB
+	lines := strings.SplitAfter(report, "\n")
+	var sum int64
+	for i, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 3 || len(line) < 20 {
+			continue
+		}
+		n, err := strconv.ParseInt(f[len(f)-3], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimRight(line[:20], " ")
+		switch name {
+		case "parser struct":
+			if !(0 < n && n <= maxStructSize) {
+				tb.Fatalf("parser struct size %d out of range"+
+					" [1..%d]", n, maxStructSize)
+			}
+		case "total":
+			if n != sum {
+				tb.Fatalf("total %d; want sum %d of the"+
+					" components", n, sum)
+			}
+			lines[i] = fmt.Sprintf("%-20s %12s %10s\n", name, "*",
+				strings.Join(f[len(f)-2:], " "))
+			continue
+		}
+		sum += n
+		if name == "parser struct" {
+			lines[i] = fmt.Sprintf("%-20s %12s %10s\n", name, "*",
+				"*")
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestConfigMemoryReport(t *testing.T) {
+	if bits.UintSize != 64 {
+		t.Skip("golden file requires 64-bit architecture")
+	}
+	const golden = "testdata/memory_report.golden"
+	cfg := Config{Effort: 5, MemoryBudget: 32 * miB}
+	report, err := cfg.MemoryReport()
+	if err != nil {
+		t.Fatalf("cfg.MemoryReport() error %s", err)
+	}
+	// The golden file doesn't contain the values depending on the size
+	// of the parser struct.
+	report = normalizeReport(t, report)
+	if *updateGolden {
+		if err = os.WriteFile(golden, []byte(report), 0o644); err != nil {
+			t.Fatalf("os.WriteFile(%q) error %s", golden, err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", golden, err)
+	}
+	if diff := cmp.Diff(string(want), report); diff != "" {
+		t.Fatalf("report differs from %s (-want +got):\n%s",
+			golden, diff)
+	}
+}
+
+// heapAlloc returns the bytes allocated on the heap after a garbage
+// collection.
+func heapAlloc() int64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
+
+func TestConfigMemoryReportTotal(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	bc := BufConfig{WindowSize: miB, BufferSize: 2 * miB}
+	// The suffix array parsers allocate their memory while parsing and
+	// are not covered.
+	for effort := 1; effort <= 8; effort++ {
+		cfg := Config{Effort: effort}
+		pc, err := cfg.ParserConfig(bc)
+		if err != nil {
+			t.Fatalf("cfg.ParserConfig error %s", err)
+		}
+		want := memSize(pc)
+
+		before := heapAlloc()
+		p, err := pc.NewParser()
+		if err != nil {
+			t.Fatalf("pc.NewParser error %s", err)
+		}
+		if _, err = p.Write(data[:bc.BufferSize]); err != nil {
+			t.Fatalf("p.Write error %s", err)
+		}
+		got := heapAlloc() - before
+		runtime.KeepAlive(p)
+
+		if d := got - want; d < -want/20 || d > want/20 {
+			t.Errorf("effort %d: allocated %d bytes; estimated %d",
+				effort, got, want)
+		}
+	}
+}
+
+func TestBestForData(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	random := make([]byte, 8*kiB)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name     string
+		data     []byte
+		min, max int
+	}{
+		{"zeros", make([]byte, 8*kiB), 8, 10},
+		{"random", random, 1, 3},
+		{"enwik7", data[100*kiB : 200*kiB], 4, 7},
+		{"repeated", bytes.Repeat(random[:100], 80), 8, 10},
+	}
+	for _, tc := range tests {
+		cfg, err := BestForData(tc.data, 64)
+		if err != nil {
+			t.Fatalf("%s: BestForData error %s", tc.name, err)
+		}
+		e, r := dataStats(tc.data[:4*kiB])
+		t.Logf("%s: entropy %.2f repeats %.2f effort %d", tc.name,
+			e, r, cfg.Effort)
+		if !(tc.min <= cfg.Effort && cfg.Effort <= tc.max) {
+			t.Errorf("%s: effort %d; want in range [%d..%d]",
+				tc.name, cfg.Effort, tc.min, tc.max)
+		}
+		if cfg.MemoryBudget != 64*miB {
+			t.Errorf("%s: MemoryBudget %d; want %d", tc.name,
+				cfg.MemoryBudget, 64*miB)
+		}
+	}
+	if _, err = BestForData(data, -1); err == nil {
+		t.Fatalf("BestForData with negative budget returned no error")
+	}
+}
+
+func TestConfigNoCompression(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:300*kiB]
+
+	var cfg Config
+	cfg.SetDefaults()
+	if cfg.Effort != 5 {
+		t.Fatalf("default Effort is %d; want %d", cfg.Effort, 5)
+	}
+
+	cfg = Config{Effort: 0, EffortSet: true}
+	bc := BufConfig{WindowSize: 64 * kiB, BlockSize: 16 * kiB}
+	p, err := cfg.NewParser(bc)
+	if err != nil {
+		t.Fatalf("cfg.NewParser error %s", err)
+	}
+	if _, ok := p.ParserConfig().(*NPConfig); !ok {
+		t.Fatalf("Effort 0 selects %T; want %T", p.ParserConfig(),
+			&NPConfig{})
+	}
+	blocks := parseAll(t, p, data)
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: bc.WindowSize})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	for _, blk := range blocks {
+		if len(blk.Sequences) != 0 {
+			t.Fatalf("block has %d sequences; want none",
+				len(blk.Sequences))
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+}
+
+func TestConfigSuggestWindowSize(t *testing.T) {
+	inputLens := []int64{0, 1000, 100 * kiB, 300 * kiB, miB, 10 * miB,
+		100 * miB, 10 << 30}
+	prev := make([]int, len(inputLens))
+	for effort := 1; effort <= maxEffort; effort++ {
+		cfg := Config{Effort: effort}
+		w0 := 0
+		for i, n := range inputLens {
+			w := cfg.SuggestWindowSize(n)
+			if w < 128*kiB {
+				t.Fatalf("Effort=%d: SuggestWindowSize(%d)=%d"+
+					" smaller than block size", effort, n, w)
+			}
+			if n >= 128*kiB && int64(w) > n {
+				t.Fatalf("Effort=%d: SuggestWindowSize(%d)=%d"+
+					" larger than input", effort, n, w)
+			}
+			if w < w0 {
+				t.Fatalf("Effort=%d: SuggestWindowSize(%d)=%d"+
+					" decreased from %d", effort, n, w, w0)
+			}
+			if w < prev[i] {
+				t.Fatalf("Effort=%d: SuggestWindowSize(%d)=%d"+
+					" smaller than %d for Effort=%d",
+					effort, n, w, prev[i], effort-1)
+			}
+			w0, prev[i] = w, w
+		}
+	}
+	if w := (Config{Effort: 3}).SuggestWindowSize(10 * miB); w != 512*kiB {
+		t.Fatalf("Effort=3: SuggestWindowSize(10 MiB)=%d; want %d",
+			w, 512*kiB)
+	}
+	if w := (Config{}).SuggestWindowSize(miB); w != miB {
+		t.Fatalf("SuggestWindowSize(1 MiB)=%d; want %d", w, miB)
+	}
+}
+
+func TestConfigDescribeParser(t *testing.T) {
+	re := regexp.MustCompile(
+		`^[A-Za-z]+\((?:[A-Za-z0-9]+=[^,()]+, )*WindowSize=\d+(?:KB|MB)?\)$`)
+	all := Config{}.DescribeAll()
+	if len(all) != maxEffort {
+		t.Fatalf("DescribeAll returned %d descriptions; want %d",
+			len(all), maxEffort)
+	}
+	for effort := 1; effort <= maxEffort; effort++ {
+		s, err := Config{Effort: effort}.DescribeParser()
+		if err != nil {
+			t.Fatalf("Effort=%d: DescribeParser error %s", effort, err)
+		}
+		t.Logf("Effort=%d: %s", effort, s)
+		if !re.MatchString(s) {
+			t.Fatalf("Effort=%d: description %q has wrong format",
+				effort, s)
+		}
+		if a := all[strconv.Itoa(effort)]; a != s {
+			t.Fatalf("DescribeAll()[%d] = %q; want %q", effort, a, s)
+		}
+	}
+
+	s, err := Config{Effort: 3}.DescribeParser()
+	if err != nil {
+		t.Fatalf("DescribeParser error %s", err)
+	}
+	const want = "HashParser(InputLen=3, HashBits=18, WindowSize=8MB)"
+	if s != want {
+		t.Fatalf("DescribeParser() = %q; want %q", s, want)
+	}
+	s, err = Config{Effort: 3, MemoryBudget: 4 * miB}.DescribeParser()
+	if err != nil {
+		t.Fatalf("DescribeParser error %s", err)
+	}
+	if s == want || !re.MatchString(s) {
+		t.Fatalf("DescribeParser() with memory budget = %q", s)
+	}
+	t.Logf("Effort=3, MemoryBudget=4MB: %s", s)
+
+	if _, err = (Config{Effort: 12}).DescribeParser(); err == nil {
+		t.Fatalf("DescribeParser accepted Effort=12")
+	}
+}
+
+func FuzzEffort11(f *testing.F) {
+	f.Add([]byte("abbababb"))
+	f.Add([]byte("=====foofoobarfoobar bartender===="))
+	f.Fuzz(func(t *testing.T, p []byte) {
+		pc := effortConfig(11)
+		pc.SetBufConfig(BufConfig{
+			BufferSize: 1024,
+			WindowSize: 1024,
+			BlockSize:  512,
+		})
+		testParser(t, pc, p)
+	})
+}
+
+func BenchmarkConfigEffort(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	costs := make(map[int]uint64)
+	for _, effort := range []int{10, 11} {
+		cfg := Config{Effort: effort}
+		b.Run(fmt.Sprintf("Effort-%d", effort), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var cost uint64
+			for i := 0; i < b.N; i++ {
+				p, err := cfg.NewParser(BufConfig{})
+				if err != nil {
+					b.Fatalf("cfg.NewParser error %s", err)
+				}
+				if cost, err = sampleCost(p, data); err != nil {
+					b.Fatalf("sampleCost error %s", err)
+				}
+			}
+			costs[effort] = cost
+			b.ReportMetric(float64(len(data))/float64(cost/8),
+				"ratio")
+		})
+	}
+	if c10, c11 := costs[10], costs[11]; c10 > 0 && c11 >= c10 {
+		b.Fatalf("Effort 11 cost %d bits; want less than %d of"+
+			" Effort 10", c11, c10)
+	}
+}
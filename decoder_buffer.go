@@ -7,6 +7,7 @@ package lz
 import (
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 )
 
@@ -18,6 +19,18 @@ type DecoderConfig struct {
 	WindowSize int
 	// Maximum size of the buffer in bytes.
 	BufferSize int
+	// AdaptiveGrowth lets the buffer start small and double its capacity
+	// on demand, never exceeding BufferSize. Without it the capacity
+	// grows as append decides and may overshoot BufferSize.
+	AdaptiveGrowth bool
+	// Strict lets WriteBlock check the complete block before it is
+	// written. Empty sequences, sequences without match that are not the
+	// last sequence of the block and matches shorter than MinMatchLen
+	// are reported as errors.
+	Strict bool
+	// MinMatchLen is the minimum match length checked in strict mode.
+	// Zero disables the check.
+	MinMatchLen int
 }
 
 // SetDefaults sets the zero values in DecConfig to default values. Note that
@@ -44,9 +57,64 @@ func (cfg *DecoderConfig) Verify() error {
 			"lz.DecConfig: WindowSize=%d out of range [%d..BufferSize=%d)",
 			cfg.WindowSize, 0, cfg.BufferSize)
 	}
+	if cfg.MinMatchLen < 0 {
+		return fmt.Errorf(
+			"lz.DecConfig: MinMatchLen=%d must not be negative",
+			cfg.MinMatchLen)
+	}
 	return nil
 }
 
+// DecoderOption modifies a decoder configuration. Options are used by
+// [NewDecoderConfig].
+type DecoderOption func(*DecoderConfig)
+
+// WithWindowSize sets the window size of the decoder configuration.
+func WithWindowSize(n int) DecoderOption {
+	return func(cfg *DecoderConfig) { cfg.WindowSize = n }
+}
+
+// WithBufferSize sets the buffer size of the decoder configuration.
+func WithBufferSize(n int) DecoderOption {
+	return func(cfg *DecoderConfig) { cfg.BufferSize = n }
+}
+
+// WithAdaptiveGrowth sets the AdaptiveGrowth flag of the decoder
+// configuration.
+func WithAdaptiveGrowth(on bool) DecoderOption {
+	return func(cfg *DecoderConfig) { cfg.AdaptiveGrowth = on }
+}
+
+// WithStrict sets the Strict flag and the minimum match length checked in
+// strict mode.
+func WithStrict(on bool, minMatchLen int) DecoderOption {
+	return func(cfg *DecoderConfig) {
+		cfg.Strict = on
+		cfg.MinMatchLen = minMatchLen
+	}
+}
+
+// WithDecoderDefaults sets the fields of the decoder configuration that are
+// still zero to the defaults as [DecoderConfig.SetDefaults] does.
+func WithDecoderDefaults() DecoderOption {
+	return func(cfg *DecoderConfig) { cfg.SetDefaults() }
+}
+
+// NewDecoderConfig creates a decoder configuration by applying the options in
+// the given order; later options override earlier ones. Fields that are not
+// set by the options get their default values. The configuration is verified
+// and the first problem is returned as error.
+func NewDecoderConfig(opts ...DecoderOption) (cfg DecoderConfig, err error) {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err != nil {
+		return DecoderConfig{}, err
+	}
+	return cfg, nil
+}
+
 // DecoderBuffer provides a simple buffer for the decoding of LZ77 sequences.
 type DecoderBuffer struct {
 	// Data is the actual buffer. The end of the slice is also the head of
@@ -62,6 +130,11 @@ type DecoderBuffer struct {
 	// DecConfig provides the configuration parameters WindowSize and
 	// BufferSize.
 	DecoderConfig
+
+	// crc caches the window checksum; it is only valid if crcValid is
+	// set.
+	crc      uint32
+	crcValid bool
 }
 
 // Init initializes the [DecoderBuffer] value.
@@ -92,6 +165,19 @@ func (b *DecoderBuffer) Reset() {
 	}
 }
 
+// PreloadDictionary resets the buffer and loads the tail of data, at most
+// WindowSize bytes, as dictionary into the window. The dictionary is not
+// returned by Read or WriteTo, but the following sequences can reference it.
+func (b *DecoderBuffer) PreloadDictionary(data []byte) error {
+	b.Reset()
+	data = data[doz(len(data), b.WindowSize):]
+	if _, err := b.Write(data); err != nil {
+		return err
+	}
+	b.R = len(b.Data)
+	return nil
+}
+
 // ByteAtEnd returns byte at end of the buffer
 func (b *DecoderBuffer) ByteAtEnd(off int) byte {
 	i := len(b.Data) - off
@@ -101,6 +187,20 @@ func (b *DecoderBuffer) ByteAtEnd(off int) byte {
 	return b.Data[i]
 }
 
+// WindowChecksum returns the CRC-32 checksum (IEEE polynomial) of the current
+// dictionary window. The checksum can be used to verify that encoder and
+// decoder share the same window. The computation is O(WindowSize) and should
+// not be called on the hot path. The value is cached until the next write.
+func (b *DecoderBuffer) WindowChecksum() uint32 {
+	if b.crcValid {
+		return b.crc
+	}
+	i := doz(len(b.Data), b.WindowSize)
+	b.crc = crc32.ChecksumIEEE(b.Data[i:])
+	b.crcValid = true
+	return b.crc
+}
+
 // Read reads decoded data from the buffer.
 func (b *DecoderBuffer) Read(p []byte) (n int, err error) {
 	n = copy(p, b.Data[b.R:])
@@ -108,6 +208,24 @@ func (b *DecoderBuffer) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// ReadAt reads data from the buffer at the total offset off, using the same
+// coordinates as the field Off. It doesn't change the read position R. If off
+// is outside of the buffer [ErrOutOfBuffer] is returned. If there is not
+// enough data to fill p, [ErrEndOfBuffer] is returned. The method doesn't
+// modify the buffer and can be called concurrently as long as the buffer is
+// not written.
+func (b *DecoderBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	i := off - (b.Off - int64(len(b.Data)))
+	if !(0 <= i && i <= int64(len(b.Data))) {
+		return 0, ErrOutOfBuffer
+	}
+	n = copy(p, b.Data[i:])
+	if n < len(p) {
+		err = ErrEndOfBuffer
+	}
+	return n, err
+}
+
 // WriteTo writes the decoded data to the writer.
 func (b *DecoderBuffer) WriteTo(w io.Writer) (n int64, err error) {
 	k, err := w.Write(b.Data[b.R:])
@@ -141,6 +259,25 @@ func (b *DecoderBuffer) shrink(g int) int {
 	return delta
 }
 
+// minAdaptiveCap is the initial capacity of a buffer using AdaptiveGrowth.
+const minAdaptiveCap = 4 * kiB
+
+// grow ensures that the Data slice has a capacity of at least t bytes if
+// AdaptiveGrowth is set. The capacity is doubled but limited by BufferSize.
+// The callers must ensure that t doesn't exceed BufferSize.
+func (b *DecoderBuffer) grow(t int) {
+	if !b.AdaptiveGrowth || t <= cap(b.Data) {
+		return
+	}
+	c := max(2*cap(b.Data), t, minAdaptiveCap)
+	if c > b.BufferSize {
+		c = b.BufferSize
+	}
+	data := make([]byte, len(b.Data), c)
+	copy(data, b.Data)
+	b.Data = data
+}
+
 // WriteByte writes a single byte into the buffer.
 func (b *DecoderBuffer) WriteByte(c byte) error {
 	g := len(b.Data) + 1
@@ -149,8 +286,10 @@ func (b *DecoderBuffer) WriteByte(c byte) error {
 			return ErrFullBuffer
 		}
 	}
+	b.grow(g)
 	b.Data = append(b.Data, c)
 	b.Off++
+	b.crcValid = false
 	return nil
 }
 
@@ -164,8 +303,10 @@ func (b *DecoderBuffer) Write(p []byte) (n int, err error) {
 			return 0, ErrFullBuffer
 		}
 	}
+	b.grow(g)
 	b.Data = append(b.Data, p...)
 	b.Off += int64(n)
+	b.crcValid = false
 	return n, nil
 }
 
@@ -187,10 +328,13 @@ func (b *DecoderBuffer) WriteMatch(m, o uint32) (n int, err error) {
 		if _m > int64(b.WindowSize) {
 			return 0, errMatchLen
 		}
-		if a += b.shrink(int(_m) + len(b.Data)); _m > int64(a) {
+		// shrink may increase BufferSize; so we compute a again.
+		b.shrink(int(_m) + len(b.Data))
+		if a = b.BufferSize - len(b.Data); _m > int64(a) {
 			return 0, ErrFullBuffer
 		}
 	}
+	b.grow(len(b.Data) + int(_m))
 	n = int(_m)
 	off := int(o)
 	for n > off {
@@ -205,31 +349,226 @@ func (b *DecoderBuffer) WriteMatch(m, o uint32) (n int, err error) {
 	j := len(b.Data) - off
 	b.Data = append(b.Data, b.Data[j:j+n]...)
 	b.Off += _m
+	b.crcValid = false
 	return int(_m), nil
 }
 
+// PeekMatch returns the bytes that WriteMatch(m, o) would write first without
+// modifying the buffer. The returned slice is part of the Data slice and
+// contains min(m, o) bytes; if the match is longer than the offset, it
+// repeats the returned bytes. The slice is only valid until the next write.
+// Invalid offsets are reported with the same errors as
+// [DecoderBuffer.WriteMatch].
+func (b *DecoderBuffer) PeekMatch(m, o uint32) ([]byte, error) {
+	if o == 0 && m > 0 {
+		return nil, errOffset
+	}
+	winLen := len(b.Data)
+	if winLen > b.WindowSize {
+		winLen = b.WindowSize
+	}
+	if int64(o) > int64(winLen) {
+		return nil, errOffset
+	}
+	j := len(b.Data) - int(o)
+	n := o
+	if m < n {
+		n = m
+	}
+	return b.Data[j : j+int(n) : j+int(n)], nil
+}
+
+// WriteMatchTo writes the data in the buffer that hasn't been read yet and
+// the match with length m and offset o to w. The match is expanded in pieces
+// that fit into the free space of the buffer and every piece is written to w
+// directly, so the match may be longer than the free space of the buffer or
+// even the window. The bytes of the match become part of the window as with
+// [DecoderBuffer.WriteMatch] and are marked as read. The method returns the
+// number of bytes written to w. Errors of w are returned unchanged; the bytes
+// that couldn't be written remain in the buffer and can be read with Read or
+// WriteTo.
+func (b *DecoderBuffer) WriteMatchTo(m, o uint32, w io.Writer) (n int, err error) {
+	if o == 0 && m > 0 {
+		return 0, errOffset
+	}
+	winLen := len(b.Data)
+	if winLen > b.WindowSize {
+		winLen = b.WindowSize
+	}
+	if int64(o) > int64(winLen) {
+		return 0, errOffset
+	}
+	k, err := b.WriteTo(w)
+	n = int(k)
+	if err != nil {
+		return n, err
+	}
+	for m > 0 {
+		a := b.BufferSize - len(b.Data)
+		if a <= 0 {
+			// All data has been read, so shrink keeps only the
+			// window.
+			b.shrink(len(b.Data) + 1)
+			if a = b.BufferSize - len(b.Data); a <= 0 {
+				return n, ErrFullBuffer
+			}
+		}
+		piece := m
+		if int64(piece) > int64(a) {
+			piece = uint32(a)
+		}
+		if _, err = b.WriteMatch(piece, o); err != nil {
+			return n, err
+		}
+		m -= piece
+		k, err = b.WriteTo(w)
+		n += int(k)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteRepeat writes n copies of byte c into the buffer. If c is not the last
+// byte in the buffer, a single literal is written followed by a match with
+// offset 1. The repetition is written completely or not at all. The count n
+// must not exceed the window size.
+func (b *DecoderBuffer) WriteRepeat(c byte, n int) error {
+	if !(0 <= n && n <= b.WindowSize) {
+		return errMatchLen
+	}
+	if n == 0 {
+		return nil
+	}
+	if a := b.BufferSize - len(b.Data); n > a {
+		b.shrink(n + len(b.Data))
+		if a = b.BufferSize - len(b.Data); n > a {
+			return ErrFullBuffer
+		}
+	}
+	b.grow(len(b.Data) + n)
+	m := n
+	if k := len(b.Data); k == 0 || b.Data[k-1] != c {
+		b.Data = append(b.Data, c)
+		b.Off++
+		b.crcValid = false
+		m--
+	}
+	_, err := b.WriteMatch(uint32(m), 1)
+	return err
+}
+
 var (
 	errLitLen   = errors.New("lz: LitLen out of range")
 	errMatchLen = errors.New("lz: MatchLen out of range")
 	errOffset   = errors.New("lz: Offset out of range")
+
+	errEmptySeq   = errors.New("lz: sequence without literals and match")
+	errLiteralSeq = errors.New("lz: sequence without match inside block")
 )
 
+// checkStrict verifies the block completely for the strict mode before any
+// byte is written. The window is simulated starting with the data in the
+// buffer.
+func (b *DecoderBuffer) checkStrict(blk *Block) error {
+	w := int64(len(b.Data))
+	var lits int64
+	for i, s := range blk.Sequences {
+		if s.LitLen == 0 && s.MatchLen == 0 {
+			return fmt.Errorf("%w: sequence %d", errEmptySeq, i)
+		}
+		lits += int64(s.LitLen)
+		if lits > int64(len(blk.Literals)) {
+			return fmt.Errorf(
+				"%w: sequence %d exceeds the %d literals",
+				errLitLen, i, len(blk.Literals))
+		}
+		w += int64(s.LitLen)
+		if s.MatchLen == 0 {
+			if i < len(blk.Sequences)-1 {
+				return fmt.Errorf("%w: sequence %d",
+					errLiteralSeq, i)
+			}
+			continue
+		}
+		if s.Offset == 0 {
+			return fmt.Errorf("%w: sequence %d has offset 0",
+				errOffset, i)
+		}
+		if int64(s.MatchLen) < int64(b.MinMatchLen) {
+			return fmt.Errorf(
+				"%w: sequence %d has match length %d;"+
+					" less than MinMatchLen=%d",
+				errMatchLen, i, s.MatchLen, b.MinMatchLen)
+		}
+		winLen := w
+		if winLen > int64(b.WindowSize) {
+			winLen = int64(b.WindowSize)
+		}
+		if int64(s.Offset) > winLen {
+			return fmt.Errorf(
+				"%w: sequence %d has offset %d outside of window"+
+					" with size %d",
+				errOffset, i, s.Offset, winLen)
+		}
+		w += int64(s.MatchLen)
+	}
+	return nil
+}
+
 // WriteBlock writes sequences from the block into the buffer. A single sequence
 // will be written in an atomic manner, because the block value will not be
 // modified. If there is not enough space in the buffer [ErrFullBuffer] will be
 // returned.
 //
-// We are not limiting the growth of the array to BufferSize. We may consume
-// more memory but we are faster.
+// Without AdaptiveGrowth we are not limiting the growth of the array to
+// BufferSize. We may consume more memory but we are faster.
 //
 // The return values n, k and l provide the number of bytes written into the
 // buffer, the number of sequences as well as the number of literals.
 func (b *DecoderBuffer) WriteBlock(blk Block) (n, k, l int, err error) {
+	k, l, n, err = b.WriteBlockProgress(&blk, 0, 0)
+	return n, k, l, err
+}
+
+// WriteBlockProgress writes the block into the buffer starting at the
+// sequence with index seqOff and the literal at offset litOff. It writes as
+// many sequences as fit into the buffer and returns the offsets for the next
+// call and the number n of bytes written. If not the whole block could be
+// written [ErrFullBuffer] is returned; the call must then be repeated with
+// the new offsets after data has been read from the buffer. The block has
+// been completely written if newSeqOff equals len(blk.Sequences) and
+// newLitOff equals len(blk.Literals). In strict mode the whole block is
+// checked when writing starts at offsets 0; nothing is written if the check
+// fails.
+func (b *DecoderBuffer) WriteBlockProgress(blk *Block, seqOff, litOff int,
+) (newSeqOff, newLitOff, n int, err error) {
+	if !(0 <= seqOff && seqOff <= len(blk.Sequences)) {
+		return seqOff, litOff, 0, fmt.Errorf(
+			"lz: seqOff=%d out of range [0..%d]",
+			seqOff, len(blk.Sequences))
+	}
+	if !(0 <= litOff && litOff <= len(blk.Literals)) {
+		return seqOff, litOff, 0, fmt.Errorf(
+			"lz: litOff=%d out of range [0..%d]",
+			litOff, len(blk.Literals))
+	}
+	if b.Strict && seqOff == 0 && litOff == 0 {
+		if err = b.checkStrict(blk); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	sequences := blk.Sequences[seqOff:]
+	literals := blk.Literals[litOff:]
 	ld := len(b.Data)
-	ll := len(blk.Literals)
-	var s Seq
-	for k, s = range blk.Sequences {
-		if int64(s.LitLen) > int64(len(blk.Literals)) {
+	ll := len(literals)
+	var (
+		k int
+		s Seq
+	)
+	for k, s = range sequences {
+		if int64(s.LitLen) > int64(len(literals)) {
 			err = errLitLen
 			goto end
 		}
@@ -251,13 +590,17 @@ func (b *DecoderBuffer) WriteBlock(blk Block) (n, k, l int, err error) {
 				err = errMatchLen
 				goto end
 			}
-			if a += b.shrink(int(g) + len(b.Data)); g > int64(a) {
+			ld -= b.shrink(int(g) + len(b.Data))
+			// shrink may increase BufferSize; so we compute a
+			// again.
+			if a = b.BufferSize - len(b.Data); g > int64(a) {
 				err = ErrFullBuffer
 				goto end
 			}
 		}
-		b.Data = append(b.Data, blk.Literals[:s.LitLen]...)
-		blk.Literals = blk.Literals[s.LitLen:]
+		b.grow(len(b.Data) + int(g))
+		b.Data = append(b.Data, literals[:s.LitLen]...)
+		literals = literals[s.LitLen:]
 		n := int(s.MatchLen)
 		off := int(s.Offset)
 		for n > off {
@@ -272,39 +615,144 @@ func (b *DecoderBuffer) WriteBlock(blk Block) (n, k, l int, err error) {
 		j := len(b.Data) - off
 		b.Data = append(b.Data, b.Data[j:j+n]...)
 	}
-	k = len(blk.Sequences)
+	k = len(sequences)
 	{ // block required to allow goto over it.
-		g := len(b.Data) + len(blk.Literals)
+		g := len(b.Data) + len(literals)
 		if g > b.BufferSize {
-			if g -= b.shrink(g); g > b.BufferSize {
+			delta := b.shrink(g)
+			ld -= delta
+			if g -= delta; g > b.BufferSize {
 				err = ErrFullBuffer
 				goto end
 			}
 		}
+		b.grow(g)
 	}
-	b.Data = append(b.Data, blk.Literals...)
-	blk.Literals = blk.Literals[:0]
+	b.Data = append(b.Data, literals...)
+	literals = literals[:0]
 end:
 	n = len(b.Data) - ld
 	b.Off += int64(n)
-	l = ll - len(blk.Literals)
-	return n, k, l, err
+	b.crcValid = false
+	return seqOff + k, litOff + ll - len(literals), n, err
+}
+
+// Replay writes the block into the decoder buffer. If the free capacity of
+// the data slice can take the whole block without growing or shrinking the
+// buffer, the block is checked once and then written without the checks
+// WriteBlock performs for every sequence. This path is taken for instance
+// when the same block is decoded repeatedly into a preallocated buffer.
+// Otherwise, and in strict mode, the method falls back to
+// [DecoderBuffer.WriteBlock]. It returns the number of bytes written.
+func (b *Block) Replay(dec *DecoderBuffer) (n int64, err error) {
+	n, ok, err := b.replayFast(dec)
+	if ok || err != nil {
+		return n, err
+	}
+	k, _, _, err := dec.WriteBlock(*b)
+	return int64(k), err
 }
 
-// Decoder decodes LZ77 sequences and writes them into the writer.
+// replayFast writes the block into the capacity of the data slice of dec. It
+// returns ok=false without writing anything if the fast path cannot be used.
+// If the block is invalid nothing will be written and an error is returned.
+func (b *Block) replayFast(dec *DecoderBuffer) (n int64, ok bool, err error) {
+	if dec.Strict {
+		return 0, false, nil
+	}
+	g := int64(len(dec.Data)) + b.Len()
+	if g > int64(cap(dec.Data)) || g > int64(dec.BufferSize) {
+		return 0, false, nil
+	}
+	w := int64(len(dec.Data))
+	var lits int64
+	for i, s := range b.Sequences {
+		lits += int64(s.LitLen)
+		if lits > int64(len(b.Literals)) {
+			return 0, false, fmt.Errorf(
+				"%w: sequence %d exceeds the %d literals",
+				errLitLen, i, len(b.Literals))
+		}
+		w += int64(s.LitLen)
+		winLen := w
+		if winLen > int64(dec.WindowSize) {
+			winLen = int64(dec.WindowSize)
+		}
+		// Like WriteBlock we check the offset of literal-only
+		// sequences too.
+		if (s.Offset == 0 && s.MatchLen > 0) ||
+			int64(s.Offset) > winLen {
+			return 0, false, fmt.Errorf(
+				"%w: sequence %d has offset %d outside of window"+
+					" with size %d",
+				errOffset, i, s.Offset, winLen)
+		}
+		w += int64(s.MatchLen)
+	}
+
+	data := dec.Data
+	literals := b.Literals
+	for _, s := range b.Sequences {
+		data = append(data, literals[:s.LitLen]...)
+		literals = literals[s.LitLen:]
+		m := int(s.MatchLen)
+		if m == 0 {
+			continue
+		}
+		off := int(s.Offset)
+		for m > off {
+			data = append(data, data[len(data)-off:]...)
+			m -= off
+			if m <= off {
+				break
+			}
+			off <<= 1
+		}
+		j := len(data) - off
+		data = append(data, data[j:j+m]...)
+	}
+	data = append(data, literals...)
+	n = int64(len(data) - len(dec.Data))
+	dec.Data = data
+	dec.Off += n
+	dec.crcValid = false
+	return n, true, nil
+}
+
+// Decoder decodes LZ77 sequences. It operates in one of two modes.
+//
+// In write-through mode the decoder has a writer and writes the decoded data
+// to it, whenever the buffer is full or Flush is called.
+//
+// In buffered mode, which is used if the writer is nil, the decoded data is
+// kept in the buffer and must be consumed using Read. The write methods
+// return [ErrFullBuffer] if the buffer cannot take more data until it has
+// been read. Flush marks the end of the decoded data; after all data has
+// been read, Read returns io.EOF.
+//
+// SetWriter switches between the two modes.
 type Decoder struct {
 	buf DecoderBuffer
 	w   io.Writer
+
+	// flushed is set by Flush and cleared by the write methods.
+	flushed bool
 }
 
 // NewDecoder creates a new decoder. The first issue with the configuration
-// will be reported.
+// will be reported. If w is nil the decoder operates in buffered mode.
 func NewDecoder(w io.Writer, cfg DecoderConfig) (*Decoder, error) {
 	d := new(Decoder)
 	err := d.Init(w, cfg)
 	return d, err
 }
 
+// NewBufferedDecoder creates a decoder in buffered mode. The decoded data
+// must be read using the Read method.
+func NewBufferedDecoder(cfg DecoderConfig) (*Decoder, error) {
+	return NewDecoder(nil, cfg)
+}
+
 // Init initializes the decoder. The first issue of the configuration value will
 // be reported as error.
 func (d *Decoder) Init(w io.Writer, cfg DecoderConfig) error {
@@ -313,6 +761,7 @@ func (d *Decoder) Init(w io.Writer, cfg DecoderConfig) error {
 		return err
 	}
 	d.w = w
+	d.flushed = false
 	return nil
 }
 
@@ -320,10 +769,72 @@ func (d *Decoder) Init(w io.Writer, cfg DecoderConfig) error {
 func (d *Decoder) Reset(w io.Writer) {
 	d.buf.Reset()
 	d.w = w
+	d.flushed = false
+}
+
+// SetWriter directs the decoded data to w. Data that hasn't been read from
+// the buffer yet is written to w immediately. A nil writer switches the
+// decoder into buffered mode.
+func (d *Decoder) SetWriter(w io.Writer) error {
+	d.w = w
+	if w == nil {
+		return nil
+	}
+	_, err := d.buf.WriteTo(w)
+	return err
+}
+
+// Read reads decoded data from the buffer. It returns io.EOF if all data has
+// been read and Flush has been called. In write-through mode the data is
+// usually written to the writer before it can be read.
+func (d *Decoder) Read(p []byte) (n int, err error) {
+	n, _ = d.buf.Read(p)
+	if n == 0 && len(p) > 0 && d.flushed {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo writes all decoded data that hasn't been read yet to w. It is
+// useful in buffered mode. A writer that doesn't accept any data without
+// reporting an error causes io.ErrShortWrite.
+func (d *Decoder) WriteTo(w io.Writer) (n int64, err error) {
+	for d.buf.R < len(d.buf.Data) {
+		k, err := d.buf.WriteTo(w)
+		n += k
+		if err != nil {
+			return n, err
+		}
+		if k == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// drain makes space in the buffer by writing its data to the writer. In
+// buffered mode it returns [ErrFullBuffer].
+func (d *Decoder) drain() error {
+	if d.w == nil {
+		return ErrFullBuffer
+	}
+	_, err := d.buf.WriteTo(d.w)
+	return err
 }
 
-// Flush writes all remaining data in the buffer to the underlying writer.
+// WindowChecksum returns the CRC-32 checksum of the current dictionary window.
+// See [DecoderBuffer.WindowChecksum].
+func (d *Decoder) WindowChecksum() uint32 {
+	return d.buf.WindowChecksum()
+}
+
+// Flush writes all remaining data in the buffer to the underlying writer. In
+// buffered mode it marks the end of the decoded data.
 func (d *Decoder) Flush() error {
+	d.flushed = true
+	if d.w == nil {
+		return nil
+	}
 	_, err := d.buf.WriteTo(d.w)
 	return err
 }
@@ -331,38 +842,47 @@ func (d *Decoder) Flush() error {
 // WriteByte writes a single byte into the decoder.
 func (d *Decoder) WriteByte(c byte) error {
 	var err error
+	d.flushed = false
 	for {
 		err = d.buf.WriteByte(c)
 		if err != ErrFullBuffer {
 			return err
 		}
-		_, err = d.buf.WriteTo(d.w)
-		if err != nil {
+		if err = d.drain(); err != nil {
 			return err
 		}
 	}
 }
 
-// Write writes the slice into the buffer.
+// Write writes the slice into the buffer. Slices larger than the space
+// available in the buffer are written in pieces.
 func (d *Decoder) Write(p []byte) (n int, err error) {
-	for {
-		k, err := d.buf.Write(p)
+	d.flushed = false
+	for len(p) > 0 {
+		// After the buffer has been drained, it can take at least
+		// BufferSize-WindowSize bytes.
+		q := p[:min(len(p), d.buf.BufferSize-d.buf.WindowSize)]
+		k, err := d.buf.Write(q)
 		n += k
+		p = p[k:]
+		if err == nil {
+			continue
+		}
 		if err != ErrFullBuffer {
 			return n, err
 		}
-		_, err = d.buf.WriteTo(d.w)
-		if err != nil {
+		if err = d.drain(); err != nil {
 			return n, err
 		}
-		p = p[k:]
 	}
+	return n, nil
 }
 
 // WriteBlock writes the block into the decoder. It returns the number n of
 // bytes, the number k of parsers and the number l of literal bytes written
 // to the decoder.
 func (d *Decoder) WriteBlock(blk Block) (n, k, l int, err error) {
+	d.flushed = false
 	for {
 		nn, kk, ll, err := d.buf.WriteBlock(blk)
 		n += nn
@@ -371,11 +891,38 @@ func (d *Decoder) WriteBlock(blk Block) (n, k, l int, err error) {
 		if err != ErrFullBuffer {
 			return n, k, l, err
 		}
-		_, err = d.buf.WriteTo(d.w)
-		if err != nil {
+		if err = d.drain(); err != nil {
 			return n, k, l, err
 		}
 		blk.Sequences = blk.Sequences[kk:]
 		blk.Literals = blk.Literals[ll:]
 	}
 }
+
+// Decompress decodes the block into a new byte slice. The block must not
+// reference data before its start. If windowSize is zero the length of the
+// block is used as window size.
+func (b *Block) Decompress(windowSize int) ([]byte, error) {
+	n := b.Len()
+	if n == 0 {
+		return []byte{}, nil
+	}
+	if n > maxInt {
+		return nil, fmt.Errorf("lz: block length %d too large", n)
+	}
+	if windowSize == 0 {
+		windowSize = int(n)
+	}
+	var buf DecoderBuffer
+	err := buf.Init(DecoderConfig{
+		WindowSize: windowSize,
+		BufferSize: max(2*windowSize, int(n)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err = buf.WriteBlock(*b); err != nil {
+		return nil, err
+	}
+	return buf.Data[buf.R:], nil
+}
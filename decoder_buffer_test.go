@@ -0,0 +1,1090 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecoderBufferWindowChecksum(t *testing.T) {
+	const windowSize = 16
+	var b DecoderBuffer
+	if err := b.Init(DecoderConfig{WindowSize: windowSize}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if got, want := b.WindowChecksum(), crc32.ChecksumIEEE(nil); got != want {
+		t.Fatalf("b.WindowChecksum() = %#08x; want %#08x", got, want)
+	}
+
+	const str = "abcdefghijklmnopqrstuvwxyz"
+	for i := 0; i < len(str); i++ {
+		if err := b.WriteByte(str[i]); err != nil {
+			t.Fatalf("b.WriteByte error %s", err)
+		}
+		j := doz(i+1, windowSize)
+		h := crc32.NewIEEE()
+		for k := j; k <= i; k++ {
+			h.Write([]byte{str[k]})
+		}
+		want := h.Sum32()
+		if got := b.WindowChecksum(); got != want {
+			t.Fatalf("b.WindowChecksum() = %#08x; want %#08x",
+				got, want)
+		}
+		// cached value must not change
+		if got := b.WindowChecksum(); got != want {
+			t.Fatalf("cached b.WindowChecksum() = %#08x; want %#08x",
+				got, want)
+		}
+	}
+
+	if _, err := b.WriteMatch(4, 4); err != nil {
+		t.Fatalf("b.WriteMatch error %s", err)
+	}
+	p := b.Data[len(b.Data)-windowSize:]
+	if got, want := b.WindowChecksum(), crc32.ChecksumIEEE(p); got != want {
+		t.Fatalf("b.WindowChecksum() = %#08x; want %#08x", got, want)
+	}
+}
+
+func TestDecoderWriteByte(t *testing.T) {
+	const str = "The quick brown fox jumps over the lazy dog."
+	var sb strings.Builder
+	d, err := NewDecoder(&sb, DecoderConfig{WindowSize: 8, BufferSize: 16})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	for i := 0; i < len(str); i++ {
+		if err = d.WriteByte(str[i]); err != nil {
+			t.Fatalf("d.WriteByte error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if g := sb.String(); g != str {
+		t.Fatalf("got %q; want %q", g, str)
+	}
+}
+
+func TestDecoderBufferWriteMatch(t *testing.T) {
+	var b DecoderBuffer
+	if err := b.Init(DecoderConfig{WindowSize: 16, BufferSize: 32}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if _, err := b.WriteMatch(3, 1); err != errOffset {
+		t.Fatalf("b.WriteMatch(3, 1) on empty buffer returned error %v;"+
+			" want %v", err, errOffset)
+	}
+	if _, err := b.Write([]byte("ab")); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	if _, err := b.WriteMatch(5, 2); err != nil {
+		t.Fatalf("b.WriteMatch(5, 2) error %s", err)
+	}
+	if _, err := b.WriteMatch(1, 0); err != errOffset {
+		t.Fatalf("b.WriteMatch(1, 0) returned error %v; want %v",
+			err, errOffset)
+	}
+	if _, err := b.WriteMatch(30, 1); err != errMatchLen {
+		t.Fatalf("b.WriteMatch(30, 1) returned error %v; want %v",
+			err, errMatchLen)
+	}
+	if g, w := string(b.Data), "abababa"; g != w {
+		t.Fatalf("b.Data is %q; want %q", g, w)
+	}
+	if b.Off != 7 {
+		t.Fatalf("b.Off is %d; want %d", b.Off, 7)
+	}
+}
+
+func TestDecoderWriteBlock(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	const windowSize = 64 * kiB
+	s := Wrap(bytes.NewReader(data),
+		newTestParser(t, &HPConfig{WindowSize: windowSize}))
+	h := sha256.New()
+	d, err := NewDecoder(h, DecoderConfig{WindowSize: windowSize,
+		BufferSize: windowSize + 1000})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	var blk Block
+	var total int64
+	for {
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		n, k, l, err := d.WriteBlock(blk)
+		if err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+		if int64(n) != blk.Len() {
+			t.Fatalf("d.WriteBlock returned n=%d; want %d", n,
+				blk.Len())
+		}
+		if k != len(blk.Sequences) {
+			t.Fatalf("d.WriteBlock returned k=%d; want %d", k,
+				len(blk.Sequences))
+		}
+		if l != len(blk.Literals) {
+			t.Fatalf("d.WriteBlock returned l=%d; want %d", l,
+				len(blk.Literals))
+		}
+		total += int64(n)
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if total != int64(len(data)) {
+		t.Fatalf("decoded %d bytes; want %d", total, len(data))
+	}
+	sum := sha256.Sum256(data)
+	if g := h.Sum(nil); !bytes.Equal(g, sum[:]) {
+		t.Fatalf("decoded hash %x; want %x", g, sum)
+	}
+
+	blk = Block{
+		Sequences: []Seq{{LitLen: 3, MatchLen: 2, Offset: 4}},
+		Literals:  []byte("abc"),
+	}
+	d.Reset(io.Discard)
+	if _, _, _, err = d.WriteBlock(blk); err != errOffset {
+		t.Fatalf("d.WriteBlock(%+v) returned error %v; want %v",
+			blk, err, errOffset)
+	}
+}
+
+func TestBlockDecompress(t *testing.T) {
+	blk := testBlock(t)
+	g, err := blk.Decompress(0)
+	if err != nil {
+		t.Fatalf("blk.Decompress(0) error %s", err)
+	}
+
+	var b DecoderBuffer
+	if err = b.Init(DecoderConfig{WindowSize: 1 << 20}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if _, _, _, err = b.WriteBlock(*blk); err != nil {
+		t.Fatalf("b.WriteBlock error %s", err)
+	}
+	want := make([]byte, blk.Len())
+	if _, err = b.Read(want); err != nil {
+		t.Fatalf("b.Read error %s", err)
+	}
+	if !bytes.Equal(g, want) {
+		t.Fatalf("blk.Decompress(0) differs from decoder buffer output")
+	}
+
+	blk = &Block{
+		Sequences: []Seq{{LitLen: 1, MatchLen: 3, Offset: 2}},
+		Literals:  []byte("a"),
+	}
+	if _, err = blk.Decompress(0); err == nil {
+		t.Fatalf("blk.Decompress(0) with offset beyond block start" +
+			" returned no error")
+	}
+}
+
+func FuzzBlockDecompress(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, p []byte) {
+		const windowSize = 1024
+		if len(p) > windowSize {
+			// Blocks would reference the data of previous blocks.
+			t.Skip()
+		}
+		s := newTestParser(t, &HPConfig{
+			WindowSize: windowSize,
+			BlockSize:  len(p) + 1,
+		})
+		var q []byte
+		for _, blk := range parseAll(t, s, p) {
+			g, err := blk.Decompress(windowSize)
+			if err != nil {
+				t.Fatalf("blk.Decompress error %s", err)
+			}
+			q = append(q, g...)
+		}
+		if !bytes.Equal(q, p) {
+			t.Fatalf("decompressed %q; want %q", q, p)
+		}
+	})
+}
+
+func TestBufferedDecoder(t *testing.T) {
+	const str = "The quick brown fox jumps over the lazy dog."
+	d, err := NewBufferedDecoder(DecoderConfig{WindowSize: 8,
+		BufferSize: 16})
+	if err != nil {
+		t.Fatalf("NewBufferedDecoder error %s", err)
+	}
+	n, err := d.Write([]byte(str))
+	if err != ErrFullBuffer {
+		t.Fatalf("d.Write returned error %v; want %v", err,
+			ErrFullBuffer)
+	}
+	p := make([]byte, 4)
+	k, err := d.Read(p)
+	if err != nil {
+		t.Fatalf("d.Read error %s", err)
+	}
+	if g := string(p[:k]); g != str[:k] {
+		t.Fatalf("d.Read returned %q; want %q", g, str[:k])
+	}
+
+	// SetWriter must write the unread data.
+	var sb strings.Builder
+	if err = d.SetWriter(&sb); err != nil {
+		t.Fatalf("d.SetWriter error %s", err)
+	}
+	if g, w := sb.String(), str[k:n]; g != w {
+		t.Fatalf("SetWriter wrote %q; want %q", g, w)
+	}
+	if _, err = d.Write([]byte(str[n:])); err != nil {
+		t.Fatalf("d.Write error %s", err)
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if g, w := sb.String(), str[k:]; g != w {
+		t.Fatalf("got %q; want %q", g, w)
+	}
+
+	// Switch back to buffered mode.
+	if err = d.SetWriter(nil); err != nil {
+		t.Fatalf("d.SetWriter(nil) error %s", err)
+	}
+	if err = d.WriteByte('!'); err != nil {
+		t.Fatalf("d.WriteByte error %s", err)
+	}
+	if k, err = d.Read(p); err != nil || string(p[:k]) != "!" {
+		t.Fatalf("d.Read returned %q, %v; want %q, nil", p[:k], err,
+			"!")
+	}
+	if _, err = d.Read(p); err != nil {
+		t.Fatalf("d.Read before Flush returned error %v; want nil",
+			err)
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if _, err = d.Read(p); err != io.EOF {
+		t.Fatalf("d.Read after Flush returned error %v; want %v", err,
+			io.EOF)
+	}
+}
+
+func TestDecoderBufferPeekMatch(t *testing.T) {
+	var b DecoderBuffer
+	if err := b.Init(DecoderConfig{WindowSize: 16, BufferSize: 64}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if _, err := b.PeekMatch(3, 1); err != errOffset {
+		t.Fatalf("b.PeekMatch(3, 1) on empty buffer returned error %v;"+
+			" want %v", err, errOffset)
+	}
+	if _, err := b.Write([]byte("abcdefghijklmnopqrst")); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	tests := []struct{ m, o uint32 }{
+		{0, 0}, {1, 1}, {5, 2}, {3, 7}, {7, 7}, {12, 16}, {15, 4},
+	}
+	for _, tc := range tests {
+		data, r, off := string(b.Data), b.R, b.Off
+		p, err := b.PeekMatch(tc.m, tc.o)
+		if err != nil {
+			t.Fatalf("b.PeekMatch(%d, %d) error %s", tc.m, tc.o, err)
+		}
+		if string(b.Data) != data || b.R != r || b.Off != off {
+			t.Fatalf("b.PeekMatch(%d, %d) modified the buffer",
+				tc.m, tc.o)
+		}
+		if n := min(int(tc.m), int(tc.o)); len(p) != n {
+			t.Fatalf("b.PeekMatch(%d, %d) returned %d bytes; want %d",
+				tc.m, tc.o, len(p), n)
+		}
+		want := string(p)
+		k := len(b.Data)
+		if _, err = b.WriteMatch(tc.m, tc.o); err != nil {
+			t.Fatalf("b.WriteMatch(%d, %d) error %s", tc.m, tc.o, err)
+		}
+		if g := string(b.Data[k : k+len(want)]); g != want {
+			t.Fatalf("b.WriteMatch(%d, %d) wrote %q; PeekMatch"+
+				" returned %q", tc.m, tc.o, g, want)
+		}
+		b.Read(make([]byte, 64))
+	}
+	if _, err := b.PeekMatch(1, 0); err != errOffset {
+		t.Fatalf("b.PeekMatch(1, 0) returned error %v; want %v",
+			err, errOffset)
+	}
+	if _, err := b.PeekMatch(1, 17); err != errOffset {
+		t.Fatalf("b.PeekMatch(1, 17) returned error %v; want %v",
+			err, errOffset)
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		b.PeekMatch(4, 3)
+	}); n != 0 {
+		t.Fatalf("b.PeekMatch allocates %.1f times; want 0", n)
+	}
+}
+
+// failWriter accepts n bytes and returns err afterwards.
+type failWriter struct {
+	buf bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (n int, err error) {
+	if len(p) > w.n {
+		p, err = p[:w.n], w.err
+	}
+	n, _ = w.buf.Write(p)
+	w.n -= n
+	return n, err
+}
+
+func TestDecoderBufferWriteMatchTo(t *testing.T) {
+	var b DecoderBuffer
+	if err := b.Init(DecoderConfig{WindowSize: 16, BufferSize: 24}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	const prefix = "abcdefghijklmnopqrst"
+	if _, err := b.Write([]byte(prefix)); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	// The match is longer than the buffer.
+	want := []byte(prefix)
+	for i := 0; i < 100; i++ {
+		want = append(want, want[len(want)-5])
+	}
+	var buf bytes.Buffer
+	n, err := b.WriteMatchTo(100, 5, &buf)
+	if err != nil {
+		t.Fatalf("b.WriteMatchTo(100, 5) error %s", err)
+	}
+	if n != len(want) || !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("b.WriteMatchTo(100, 5) wrote %d bytes %q; want %q",
+			n, buf.Bytes(), want)
+	}
+	if b.R != len(b.Data) {
+		t.Fatalf("b.R=%d; want %d", b.R, len(b.Data))
+	}
+	if b.Off != int64(len(want)) {
+		t.Fatalf("b.Off=%d; want %d", b.Off, len(want))
+	}
+	if _, err = b.WriteMatchTo(1, 17, &buf); err != errOffset {
+		t.Fatalf("b.WriteMatchTo(1, 17) error %v; want %v", err,
+			errOffset)
+	}
+
+	errWrite := errors.New("write failed")
+	w := &failWriter{n: 10, err: errWrite}
+	if n, err = b.WriteMatchTo(30, 3, w); err != errWrite {
+		t.Fatalf("b.WriteMatchTo error %v; want %v", err, errWrite)
+	}
+	if n != 10 {
+		t.Fatalf("b.WriteMatchTo returned n=%d; want %d", n, 10)
+	}
+	// The remaining bytes of the written piece can still be read.
+	g := append(w.buf.Bytes(), b.Data[b.R:]...)
+	for i, c := range g {
+		if j := len(want) - 3 + i%3; c != want[j] {
+			t.Fatalf("byte %d of match is %q; want %q", i, c, want[j])
+		}
+	}
+}
+
+func FuzzDecoderBufferWriteMatchTo(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="), uint16(20),
+		uint8(3), uint8(8))
+	f.Add([]byte("a"), uint16(1000), uint8(1), uint8(1))
+	f.Fuzz(func(t *testing.T, p []byte, m uint16, o uint8, bs uint8) {
+		const windowSize = 32
+		cfg := DecoderConfig{
+			WindowSize: windowSize,
+			BufferSize: windowSize + 1 + int(bs),
+		}
+		var b, c DecoderBuffer
+		if err := b.Init(cfg); err != nil {
+			t.Fatalf("b.Init error %s", err)
+		}
+		cfg.BufferSize = len(p) + int(m) + windowSize + 1
+		if err := c.Init(cfg); err != nil {
+			t.Fatalf("c.Init error %s", err)
+		}
+		var buf bytes.Buffer
+		for q := p; len(q) > 0; {
+			k := min(len(q), 1+int(bs))
+			if _, err := b.Write(q[:k]); err != nil {
+				t.Fatalf("b.Write error %s", err)
+			}
+			if _, err := b.WriteTo(&buf); err != nil {
+				t.Fatalf("b.WriteTo error %s", err)
+			}
+			q = q[k:]
+		}
+		if _, err := c.Write(p); err != nil {
+			t.Fatalf("c.Write error %s", err)
+		}
+		_, errMatch := c.WriteMatch(uint32(m), uint32(o))
+		_, err := b.WriteMatchTo(uint32(m), uint32(o), &buf)
+		if err != errMatch {
+			t.Fatalf("WriteMatchTo error %v; WriteMatch error %v",
+				err, errMatch)
+		}
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(buf.Bytes(), c.Data) {
+			t.Fatalf("WriteMatchTo wrote %q; want %q", buf.Bytes(),
+				c.Data)
+		}
+	})
+}
+
+func TestDecoderBufferWriteRepeat(t *testing.T) {
+	var b DecoderBuffer
+	if err := b.Init(DecoderConfig{WindowSize: 1024}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if err := b.WriteRepeat(0xAB, 1000); err != nil {
+		t.Fatalf("b.WriteRepeat(0xAB, 1000) error %s", err)
+	}
+	if !bytes.Equal(b.Data, bytes.Repeat([]byte{0xAB}, 1000)) {
+		t.Fatalf("b.Data doesn't contain 1000 bytes 0xAB")
+	}
+	// The last byte is already 0xAB.
+	if err := b.WriteRepeat(0xAB, 10); err != nil {
+		t.Fatalf("b.WriteRepeat(0xAB, 10) error %s", err)
+	}
+	if err := b.WriteRepeat('x', 3); err != nil {
+		t.Fatalf("b.WriteRepeat('x', 3) error %s", err)
+	}
+	want := append(bytes.Repeat([]byte{0xAB}, 1010), "xxx"...)
+	if !bytes.Equal(b.Data, want) {
+		t.Fatalf("b.Data = %q; want %q", b.Data, want)
+	}
+	if b.Off != int64(len(want)) {
+		t.Fatalf("b.Off = %d; want %d", b.Off, len(want))
+	}
+	if err := b.WriteRepeat('y', 1025); err == nil {
+		t.Fatalf("b.WriteRepeat('y', 1025) returned no error")
+	}
+}
+
+func BenchmarkDecoderBufferWriteRepeat(b *testing.B) {
+	const n = 1000
+	var d DecoderBuffer
+	if err := d.Init(DecoderConfig{WindowSize: n}); err != nil {
+		b.Fatalf("d.Init error %s", err)
+	}
+	b.Run("WriteRepeat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			d.Reset()
+			if err := d.WriteRepeat(0xAB, n); err != nil {
+				b.Fatalf("d.WriteRepeat error %s", err)
+			}
+		}
+	})
+	b.Run("Write", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			d.Reset()
+			p := bytes.Repeat([]byte{0xAB}, n)
+			if _, err := d.Write(p); err != nil {
+				b.Fatalf("d.Write error %s", err)
+			}
+		}
+	})
+}
+
+func TestDecoderBufferWriteBlockProgress(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	want := data[:128*kiB]
+	s := newTestParser(t, &HPConfig{WindowSize: 16 * kiB,
+		BlockSize: 128 * kiB, BufferSize: 256 * kiB})
+	if _, err = s.Write(want); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+	blk := new(Block)
+	if _, err = s.Parse(blk, 0); err != nil {
+		t.Fatalf("s.Parse error %s", err)
+	}
+
+	var b DecoderBuffer
+	err = b.Init(DecoderConfig{WindowSize: 16 * kiB, BufferSize: 32 * kiB})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	var (
+		buf            bytes.Buffer
+		seqOff, litOff int
+		calls          int
+	)
+	for {
+		var n int
+		seqOff, litOff, n, err = b.WriteBlockProgress(blk, seqOff,
+			litOff)
+		calls++
+		if err == nil {
+			break
+		}
+		if err != ErrFullBuffer {
+			t.Fatalf("b.WriteBlockProgress error %s", err)
+		}
+		if n == 0 && len(b.Data) == b.R {
+			t.Fatalf("b.WriteBlockProgress makes no progress")
+		}
+		if _, err = b.WriteTo(&buf); err != nil {
+			t.Fatalf("b.WriteTo error %s", err)
+		}
+	}
+	if seqOff != len(blk.Sequences) || litOff != len(blk.Literals) {
+		t.Fatalf("offsets %d, %d after completion; want %d, %d",
+			seqOff, litOff, len(blk.Sequences), len(blk.Literals))
+	}
+	if _, err = b.WriteTo(&buf); err != nil {
+		t.Fatalf("b.WriteTo error %s", err)
+	}
+	if calls < 2 {
+		t.Fatalf("block written in %d call; want partial writes", calls)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("partial writes produced different output")
+	}
+
+	if _, _, _, err = b.WriteBlockProgress(blk, len(blk.Sequences)+1,
+		0); err == nil {
+		t.Fatalf("b.WriteBlockProgress with seqOff out of range" +
+			" returned no error")
+	}
+}
+
+func TestNewDecoderConfig(t *testing.T) {
+	cfg, err := NewDecoderConfig()
+	if err != nil {
+		t.Fatalf("NewDecoderConfig() error %s", err)
+	}
+	var want DecoderConfig
+	want.SetDefaults()
+	if cfg != want {
+		t.Fatalf("NewDecoderConfig() = %+v; want %+v", cfg, want)
+	}
+
+	cfg, err = NewDecoderConfig(WithWindowSize(1024),
+		WithBufferSize(4096), WithWindowSize(2048))
+	if err != nil {
+		t.Fatalf("NewDecoderConfig error %s", err)
+	}
+	want = DecoderConfig{WindowSize: 2048, BufferSize: 4096}
+	if cfg != want {
+		t.Fatalf("NewDecoderConfig returned %+v; want %+v", cfg, want)
+	}
+
+	// The defaults are applied at the position of the option.
+	cfg, err = NewDecoderConfig(WithWindowSize(1024),
+		WithDecoderDefaults(), WithWindowSize(512))
+	if err != nil {
+		t.Fatalf("NewDecoderConfig error %s", err)
+	}
+	want = DecoderConfig{WindowSize: 512, BufferSize: 2048}
+	if cfg != want {
+		t.Fatalf("NewDecoderConfig returned %+v; want %+v", cfg, want)
+	}
+
+	_, err = NewDecoderConfig(WithWindowSize(4096), WithBufferSize(1024))
+	if err == nil {
+		t.Fatalf("NewDecoderConfig with BufferSize < WindowSize" +
+			" returned no error")
+	}
+}
+
+// zeroWriter accepts no data and reports no error.
+type zeroWriter struct{}
+
+func (zeroWriter) Write(p []byte) (n int, err error) { return 0, nil }
+
+func TestDecoderWriteTo(t *testing.T) {
+	blk := testBlock(t)
+	want, err := blk.Decompress(0)
+	if err != nil {
+		t.Fatalf("blk.Decompress error %s", err)
+	}
+	d, err := NewBufferedDecoder(DecoderConfig{WindowSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("NewBufferedDecoder error %s", err)
+	}
+	if _, _, _, err = d.WriteBlock(*blk); err != nil {
+		t.Fatalf("d.WriteBlock error %s", err)
+	}
+	if _, err = d.WriteTo(zeroWriter{}); err != io.ErrShortWrite {
+		t.Fatalf("d.WriteTo(zeroWriter{}) returned %v; want %v", err,
+			io.ErrShortWrite)
+	}
+	var buf bytes.Buffer
+	n, err := d.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("d.WriteTo error %s", err)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("d.WriteTo returned %d; want %d", n, len(want))
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("d.WriteTo wrote different data")
+	}
+	if d.buf.R != len(d.buf.Data) {
+		t.Fatalf("R=%d after WriteTo; want %d", d.buf.R,
+			len(d.buf.Data))
+	}
+	if n, err = d.WriteTo(&buf); n != 0 || err != nil {
+		t.Fatalf("second d.WriteTo returned %d, %v; want 0, nil", n,
+			err)
+	}
+}
+
+func TestDecoderBufferReadAt(t *testing.T) {
+	var b DecoderBuffer
+	err := b.Init(DecoderConfig{WindowSize: kiB, BufferSize: 4 * kiB})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	data := make([]byte, 10000)
+	r := rand.New(rand.NewSource(1))
+	r.Read(data)
+	p := make([]byte, 1000)
+	for q := data; len(q) > 0; q = q[len(p):] {
+		if _, err := b.Write(q[:len(p)]); err != nil {
+			t.Fatalf("b.Write error %s", err)
+		}
+		if _, err := b.Read(p); err != nil {
+			t.Fatalf("b.Read error %s", err)
+		}
+	}
+	start := b.Off - int64(len(b.Data))
+	if start == 0 {
+		t.Fatalf("buffer didn't discard data")
+	}
+	rpos := b.R
+
+	// middle
+	off := start + int64(len(b.Data))/2
+	n, err := b.ReadAt(p[:100], off)
+	if n != 100 || err != nil {
+		t.Fatalf("b.ReadAt middle returned %d, %v; want 100, nil",
+			n, err)
+	}
+	if !bytes.Equal(p[:n], data[off:off+100]) {
+		t.Fatalf("b.ReadAt middle returned wrong data")
+	}
+
+	// end
+	n, err = b.ReadAt(p[:100], b.Off-100)
+	if n != 100 || err != nil {
+		t.Fatalf("b.ReadAt end returned %d, %v; want 100, nil", n, err)
+	}
+	if !bytes.Equal(p[:n], data[len(data)-100:]) {
+		t.Fatalf("b.ReadAt end returned wrong data")
+	}
+
+	// partially past the end
+	n, err = b.ReadAt(p[:100], b.Off-10)
+	if n != 10 || err != ErrEndOfBuffer {
+		t.Fatalf("b.ReadAt past end returned %d, %v; want 10, %v",
+			n, err, ErrEndOfBuffer)
+	}
+	if !bytes.Equal(p[:n], data[len(data)-10:]) {
+		t.Fatalf("b.ReadAt past end returned wrong data")
+	}
+	n, err = b.ReadAt(p[:100], b.Off)
+	if n != 0 || err != ErrEndOfBuffer {
+		t.Fatalf("b.ReadAt(Off) returned %d, %v; want 0, %v",
+			n, err, ErrEndOfBuffer)
+	}
+
+	// outside
+	for _, off := range []int64{start - 1, b.Off + 1} {
+		if _, err = b.ReadAt(p[:1], off); err != ErrOutOfBuffer {
+			t.Fatalf("b.ReadAt(p, %d) returned %v; want %v",
+				off, err, ErrOutOfBuffer)
+		}
+	}
+	if b.R != rpos {
+		t.Fatalf("b.R=%d after ReadAt; want %d", b.R, rpos)
+	}
+}
+
+// decodeWithCap decodes the blocks using a [DecoderBuffer] with the given
+// configuration and returns the decoded data and the maximum capacity of the
+// buffer.
+func decodeWithCap(tb testing.TB, blocks []Block, cfg DecoderConfig,
+) (data []byte, maxCap int) {
+	var b DecoderBuffer
+	if err := b.Init(cfg); err != nil {
+		tb.Fatalf("b.Init error %s", err)
+	}
+	for i := range blocks {
+		var seqOff, litOff int
+		for {
+			var err error
+			seqOff, litOff, _, err = b.WriteBlockProgress(
+				&blocks[i], seqOff, litOff)
+			maxCap = max(maxCap, cap(b.Data))
+			if err == nil {
+				break
+			}
+			if err != ErrFullBuffer {
+				tb.Fatalf("b.WriteBlockProgress error %s", err)
+			}
+			data = append(data, b.Data[b.R:]...)
+			b.R = len(b.Data)
+		}
+	}
+	data = append(data, b.Data[b.R:]...)
+	return data, maxCap
+}
+
+func TestDecoderBufferAdaptiveGrowth(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:1<<20]
+	const windowSize = 64 * kiB
+	p := newTestParser(t, &HPConfig{WindowSize: windowSize,
+		BlockSize: 4 * kiB})
+	s := Wrap(bytes.NewReader(data), p)
+	var blocks []Block
+	for {
+		var blk Block
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+
+	cfg := DecoderConfig{WindowSize: windowSize, BufferSize: 100_000}
+	got, fixedCap := decodeWithCap(t, blocks, cfg)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("non-adaptive decoding differs from input")
+	}
+	cfg.AdaptiveGrowth = true
+	got, adaptiveCap := decodeWithCap(t, blocks, cfg)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("adaptive decoding differs from input")
+	}
+	t.Logf("max capacity: adaptive %d; non-adaptive %d",
+		adaptiveCap, fixedCap)
+	if adaptiveCap > cfg.BufferSize {
+		t.Fatalf("adaptive capacity %d exceeds BufferSize %d",
+			adaptiveCap, cfg.BufferSize)
+	}
+	if adaptiveCap >= fixedCap {
+		t.Fatalf("adaptive capacity %d; want less than %d",
+			adaptiveCap, fixedCap)
+	}
+
+	// A small input doesn't require the full buffer.
+	cfg.BufferSize = 16 * miB
+	_, smallCap := decodeWithCap(t, blocks[:1], cfg)
+	if smallCap > 2*minAdaptiveCap {
+		t.Fatalf("adaptive capacity %d for a single block; want <= %d",
+			smallCap, 2*minAdaptiveCap)
+	}
+}
+
+func BenchmarkDecoderBufferAdaptiveGrowth(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:1<<20]
+	const windowSize = 64 * kiB
+	p := newTestParser(b, &HPConfig{WindowSize: windowSize})
+	s := Wrap(bytes.NewReader(data), p)
+	var blocks []Block
+	for {
+		var blk Block
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			b.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	for _, adaptive := range []bool{false, true} {
+		name := "fixed"
+		if adaptive {
+			name = "adaptive"
+		}
+		cfg := DecoderConfig{
+			WindowSize:     windowSize,
+			BufferSize:     2 * windowSize,
+			AdaptiveGrowth: adaptive,
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				decodeWithCap(b, blocks, cfg)
+			}
+		})
+	}
+}
+
+func TestDecoderBufferStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		blk  Block
+		err  error
+	}{
+		{"emptySeq", Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 3, Offset: 1},
+				{}},
+			Literals: []byte("ab"),
+		}, errEmptySeq},
+		{"offset0", Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 2, Offset: 0}},
+			Literals:  []byte("ab"),
+		}, errOffset},
+		{"shortMatch", Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 3, Offset: 1},
+				{LitLen: 1, MatchLen: 2, Offset: 2}},
+			Literals: []byte("abc"),
+		}, errMatchLen},
+		{"literalSeq", Block{
+			Sequences: []Seq{{LitLen: 2},
+				{LitLen: 1, MatchLen: 3, Offset: 2}},
+			Literals: []byte("abc"),
+		}, errLiteralSeq},
+		{"offsetOutsideWindow", Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 3, Offset: 1},
+				{LitLen: 1, MatchLen: 3, Offset: 9}},
+			Literals: []byte("abc"),
+		}, errOffset},
+		{"litLen", Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 3, Offset: 1},
+				{LitLen: 2, MatchLen: 3, Offset: 2}},
+			Literals: []byte("abc"),
+		}, errLitLen},
+	}
+	cfg := DecoderConfig{WindowSize: 1024, Strict: true, MinMatchLen: 3}
+	for _, tc := range tests {
+		var b DecoderBuffer
+		if err := b.Init(cfg); err != nil {
+			t.Fatalf("b.Init error %s", err)
+		}
+		if _, err := b.Write([]byte("xy")); err != nil {
+			t.Fatalf("b.Write error %s", err)
+		}
+		_, _, _, err := b.WriteBlock(tc.blk)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: WriteBlock returned error %v; want %v",
+				tc.name, err, tc.err)
+		}
+		if g := string(b.Data); g != "xy" {
+			t.Errorf("%s: WriteBlock wrote data %q", tc.name, g)
+		}
+	}
+
+	// Strict mode must not change the result for valid blocks.
+	blk := testBlock(t)
+	var want []byte
+	for _, strict := range []bool{false, true} {
+		cfg, err := NewDecoderConfig(WithWindowSize(1<<20),
+			WithStrict(strict, 3))
+		if err != nil {
+			t.Fatalf("NewDecoderConfig error %s", err)
+		}
+		var b DecoderBuffer
+		if err = b.Init(cfg); err != nil {
+			t.Fatalf("b.Init error %s", err)
+		}
+		if _, _, _, err = b.WriteBlock(*blk); err != nil {
+			t.Fatalf("strict=%t: WriteBlock error %s", strict, err)
+		}
+		if !strict {
+			want = b.Data
+			continue
+		}
+		if !bytes.Equal(b.Data, want) {
+			t.Fatalf("strict mode changes decoded data")
+		}
+	}
+}
+
+func TestBlockReplay(t *testing.T) {
+	blk := testBlock(t)
+	blocks := []*Block{
+		blk,
+		fragment(blk, []byte{1, 2, 3, 7}),
+		{Literals: []byte("abc")},
+		{},
+	}
+	cfg := DecoderConfig{WindowSize: 1 << 20}
+	for i, blk := range blocks {
+		var want DecoderBuffer
+		if err := want.Init(cfg); err != nil {
+			t.Fatalf("want.Init error %s", err)
+		}
+		if _, _, _, err := want.WriteBlock(*blk); err != nil {
+			t.Fatalf("#%d: WriteBlock error %s", i, err)
+		}
+
+		// The capacity is sufficient for the fast path.
+		var dec DecoderBuffer
+		dec.Data = make([]byte, 0, blk.Len())
+		if err := dec.Init(cfg); err != nil {
+			t.Fatalf("dec.Init error %s", err)
+		}
+		n, ok, err := blk.replayFast(&dec)
+		if err != nil {
+			t.Fatalf("#%d: replayFast error %s", i, err)
+		}
+		if !ok {
+			t.Fatalf("#%d: replayFast didn't take the fast path", i)
+		}
+		if n != blk.Len() {
+			t.Fatalf("#%d: replayFast returned %d; want %d",
+				i, n, blk.Len())
+		}
+		if !bytes.Equal(dec.Data, want.Data) || dec.Off != want.Off {
+			t.Fatalf("#%d: replayFast data differs from WriteBlock",
+				i)
+		}
+
+		// Without capacity Replay falls back to WriteBlock.
+		if err = dec.Init(cfg); err != nil {
+			t.Fatalf("dec.Init error %s", err)
+		}
+		dec.Data = nil
+		if n, err = blk.Replay(&dec); err != nil {
+			t.Fatalf("#%d: Replay error %s", i, err)
+		}
+		if n != blk.Len() {
+			t.Fatalf("#%d: Replay returned %d; want %d",
+				i, n, blk.Len())
+		}
+		if !bytes.Equal(dec.Data, want.Data) {
+			t.Fatalf("#%d: Replay data differs from WriteBlock", i)
+		}
+	}
+
+	var dec DecoderBuffer
+	dec.Data = make([]byte, 0, 64)
+	if err := dec.Init(cfg); err != nil {
+		t.Fatalf("dec.Init error %s", err)
+	}
+	for _, bad := range []*Block{
+		{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 3, Offset: 3}},
+			Literals:  []byte("ab"),
+		},
+		// A literal-only sequence with an offset out of range is
+		// rejected by WriteBlock too.
+		{
+			Sequences: []Seq{{LitLen: 2, Offset: 100}},
+			Literals:  []byte("ab"),
+		},
+	} {
+		var wb DecoderBuffer
+		if err := wb.Init(cfg); err != nil {
+			t.Fatalf("wb.Init error %s", err)
+		}
+		if _, _, _, err := wb.WriteBlock(*bad); !errors.Is(err, errOffset) {
+			t.Fatalf("WriteBlock(%v) returned %v; want %v",
+				bad, err, errOffset)
+		}
+		if _, err := bad.Replay(&dec); !errors.Is(err, errOffset) {
+			t.Fatalf("Replay(%v) returned %v; want %v",
+				bad, err, errOffset)
+		}
+		if len(dec.Data) != 0 {
+			t.Fatalf("Replay(%v) wrote %d bytes", bad, len(dec.Data))
+		}
+	}
+}
+
+func BenchmarkBlockReplay(b *testing.B) {
+	blk := testBlock(b)
+	var dec DecoderBuffer
+	dec.Data = make([]byte, 0, blk.Len())
+	if err := dec.Init(DecoderConfig{WindowSize: 1 << 20}); err != nil {
+		b.Fatalf("dec.Init error %s", err)
+	}
+	b.Run("Replay", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(blk.Len())
+		for i := 0; i < b.N; i++ {
+			dec.Reset()
+			if _, err := blk.Replay(&dec); err != nil {
+				b.Fatalf("Replay error %s", err)
+			}
+		}
+	})
+	b.Run("WriteBlock", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(blk.Len())
+		for i := 0; i < b.N; i++ {
+			dec.Reset()
+			if _, _, _, err := dec.WriteBlock(*blk); err != nil {
+				b.Fatalf("WriteBlock error %s", err)
+			}
+		}
+	})
+}
+
+func TestDecoderBufferGrownCapacity(t *testing.T) {
+	// The capacity of the data slice may grow beyond BufferSize by
+	// append. The write methods must not report ErrFullBuffer then.
+	var b DecoderBuffer
+	err := b.Init(DecoderConfig{WindowSize: 10, BufferSize: 15})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	b.Data = make([]byte, 0, 5)
+	var blk Block
+	for i := 0; i < 10; i++ {
+		blk.Sequences = []Seq{{LitLen: 2, MatchLen: 3, Offset: 2}}
+		blk.Literals = []byte("ab")
+		if _, _, _, err := b.WriteBlock(blk); err != nil {
+			t.Fatalf("b.WriteBlock #%d error %s", i, err)
+		}
+		if _, err := b.WriteMatch(2, 2); err != nil {
+			t.Fatalf("b.WriteMatch #%d error %s", i, err)
+		}
+		if err := b.WriteRepeat('c', 2); err != nil {
+			t.Fatalf("b.WriteRepeat #%d error %s", i, err)
+		}
+		b.R = len(b.Data)
+	}
+}
@@ -5,6 +5,7 @@
 package lz
 
 import (
+	"fmt"
 	"math/bits"
 )
 
@@ -91,6 +92,15 @@ func (cfg DHPConfig) NewParser() (s Parser, err error) {
 // ratio is much better.
 type doubleHashParser struct {
 	doubleHashDictionary
+	seqHistograms
+	statsAccumulator
+	hm hashMetrics
+	// h1Hits and h2Hits count the hits of the individual hash tables.
+	h1Hits int64
+	h2Hits int64
+
+	// maxMatchLen limits the length of matches if it is positive.
+	maxMatchLen int
 
 	DHPConfig
 }
@@ -118,6 +128,109 @@ func (s *doubleHashParser) ParserConfig() ParserConfig {
 	return &s.DHPConfig
 }
 
+// Reset puts the parser into its initial state with the data slice as buffer
+// content. The histograms are cleared.
+func (s *doubleHashParser) Reset(data []byte) error {
+	var err error
+	if err = s.doubleHashDictionary.Reset(data); err != nil {
+		return err
+	}
+	s.seqHistograms.reset()
+	return nil
+}
+
+// CopyState makes the state of the parser a deep copy of the state of src,
+// which must be a parser of the same type with the same configuration. A
+// parser wrapped for profiler labels is unwrapped. Otherwise an error
+// wrapping [ErrIncompatibleConfig] is returned. After the call both parsers
+// will produce the same sequences for the same input.
+func (s *doubleHashParser) CopyState(src Parser) error {
+	t, ok := unwrapParser(src).(*doubleHashParser)
+	if !ok {
+		return fmt.Errorf("%w: can't copy state from %T to %T",
+			ErrIncompatibleConfig, src, s)
+	}
+	if s.DHPConfig != t.DHPConfig {
+		return fmt.Errorf("%w: DHPConfig %+v differs from %+v",
+			ErrIncompatibleConfig, t.DHPConfig, s.DHPConfig)
+	}
+	s.doubleHashDictionary.copyFrom(&t.doubleHashDictionary)
+	s.seqHistograms.copyFrom(&t.seqHistograms)
+	s.statsAccumulator = t.statsAccumulator
+	s.hm = t.hm
+	s.h1Hits, s.h2Hits = t.h1Hits, t.h2Hits
+	s.maxMatchLen = t.maxMatchLen
+	return nil
+}
+
+// ExtendWindow increases the window size of the parser by extra bytes. The
+// hash tables are not updated.
+func (s *doubleHashParser) ExtendWindow(extra int) error {
+	var err error
+	if err = s.ParserBuffer.ExtendWindow(extra); err != nil {
+		return err
+	}
+	s.DHPConfig.WindowSize = s.ParserBuffer.WindowSize
+	return nil
+}
+
+// SetWindowSize sets the window size of the parser to n, which must be in the
+// range [InputLen1..BufferSize]. If the window is reduced the entries of both
+// hash tables outside of the new window are evicted.
+func (s *doubleHashParser) SetWindowSize(n int) error {
+	if !(s.InputLen1 <= n && n <= s.BufferSize) {
+		return fmt.Errorf(
+			"lz: window size %d out of range [InputLen1=%d..BufferSize=%d]",
+			n, s.InputLen1, s.BufferSize)
+	}
+	if n < s.WindowSize {
+		s.h1.evict(s.W, n)
+		s.h2.evict(s.W, n)
+	}
+	s.ParserBuffer.WindowSize = n
+	s.DHPConfig.WindowSize = n
+	return nil
+}
+
+// Metrics returns the performance data of the parser. In addition to the
+// keys of all parsers it provides hash_hits and hash_misses as well as
+// h1_hits and h2_hits for the hits of the individual hash tables.
+func (s *doubleHashParser) Metrics() map[string]int64 {
+	m := s.statsAccumulator.Metrics()
+	s.hm.addMetrics(m)
+	m["h1_hits"] = s.h1Hits
+	m["h2_hits"] = s.h2Hits
+	return m
+}
+
+// ResetMetrics clears the counters reported by Metrics.
+func (s *doubleHashParser) ResetMetrics() {
+	s.statsAccumulator.ResetMetrics()
+	s.hm = hashMetrics{}
+	s.h1Hits, s.h2Hits = 0, 0
+}
+
+// SetMaxMatchLen limits the length of the matches generated by Parse to n
+// bytes. The value zero removes the limit. The limit must not be smaller than
+// InputLen1.
+func (s *doubleHashParser) SetMaxMatchLen(n int) error {
+	if n < 0 {
+		return fmt.Errorf("lz: maxMatchLen %d must not be negative", n)
+	}
+	if n > 0 && n < s.InputLen1 {
+		return fmt.Errorf("lz: maxMatchLen %d < InputLen1 %d",
+			n, s.InputLen1)
+	}
+	s.maxMatchLen = n
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse. It
+// is the smaller of InputLen1 and 3.
+func (s *doubleHashParser) MatchLen() int {
+	return min(s.h1.inputLen, 3)
+}
+
 // Parse generates the LZ77 sequences. It returns the number of bytes covered
 // by the new sequences. The block will be overwritten but the memory for the
 // slices will be reused.
@@ -132,11 +245,11 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 		}
 		t := s.W + n
 		s.processSegment(s.W-s.h2.inputLen+1, t)
+		s.W = t
 		return n, nil
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -149,11 +262,9 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	e2 := len(p) - s.h2.inputLen + 1
 	i := s.W
 	litIndex := i
+	var h1Hits, h2Hits, misses int64
 
-	minMatchLen := 3
-	if s.h1.inputLen < minMatchLen {
-		minMatchLen = s.h1.inputLen
-	}
+	minMatchLen := s.MatchLen()
 
 	// Ensure that we can use _getLE64 all the time.
 	_p := s.Data[:e1+7]
@@ -161,21 +272,25 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	for ; i < e2; i++ {
 		y := _getLE64(_p[i:])
 		x := y & s.h2.mask
-		h := hashValue(x, s.h2.shift)
+		h := s.hashValue2(x)
 		entry := s.h2.table[h]
 		v2 := uint32(x)
 		pos := uint32(i)
 		s.h2.table[h] = hashEntry{pos: pos, value: v2}
 		x = y & s.h1.mask
-		h = hashValue(x, s.h1.shift)
+		h = s.hashValue1(x)
 		entry1 := s.h1.table[h]
 		v1 := uint32(x)
 		s.h1.table[h] = hashEntry{pos: pos, value: v1}
 		if v2 != entry.value {
 			if v1 != entry1.value {
+				misses++
 				continue
 			}
+			h1Hits++
 			entry = entry1
+		} else {
+			h2Hits++
 		}
 		// potential match
 		j := int(entry.pos)
@@ -213,6 +328,9 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			}
 		match:
 		}
+		if s.maxMatchLen > 0 && k > s.maxMatchLen {
+			k = s.maxMatchLen
+		}
 		q := p[litIndex:i]
 		blk.Sequences = append(blk.Sequences,
 			Seq{
@@ -229,11 +347,11 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 		for j = i + 1; j < b; j++ {
 			y := _getLE64(_p[j:])
 			x := y & s.h2.mask
-			h := hashValue(x, s.h2.shift)
+			h := s.hashValue2(x)
 			pos := uint32(j)
 			s.h2.table[h] = hashEntry{pos: pos, value: uint32(x)}
 			x = y & s.h1.mask
-			h = hashValue(x, s.h1.shift)
+			h = s.hashValue1(x)
 			s.h1.table[h] = hashEntry{pos: pos, value: uint32(x)}
 		}
 		if j < litIndex {
@@ -243,7 +361,7 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			}
 			for ; j < b; j++ {
 				x := _getLE64(_p[j:]) & s.h1.mask
-				h := hashValue(x, s.h1.shift)
+				h := s.hashValue1(x)
 				s.h1.table[h] = hashEntry{
 					pos:   uint32(j),
 					value: uint32(x),
@@ -255,7 +373,7 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	for ; i < e1; i++ {
 		y := _getLE64(_p[i:])
 		x := y & s.h1.mask
-		h := hashValue(x, s.h1.shift)
+		h := s.hashValue1(x)
 		entry := s.h1.table[h]
 		v1 := uint32(x)
 		s.h1.table[h] = hashEntry{
@@ -263,8 +381,10 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			value: v1,
 		}
 		if v1 != entry.value {
+			misses++
 			continue
 		}
+		h1Hits++
 		// potential match
 		j := int(entry.pos)
 		o := i - j
@@ -301,6 +421,9 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 			}
 		match1:
 		}
+		if s.maxMatchLen > 0 && k > s.maxMatchLen {
+			k = s.maxMatchLen
+		}
 		q := p[litIndex:i]
 		blk.Sequences = append(blk.Sequences,
 			Seq{
@@ -316,7 +439,7 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 		}
 		for ; j < b; j++ {
 			x := _getLE64(_p[j:]) & s.h1.mask
-			h := hashValue(x, s.h1.shift)
+			h := s.hashValue1(x)
 			s.h1.table[h] = hashEntry{
 				pos:   uint32(j),
 				value: uint32(x),
@@ -333,5 +456,11 @@ func (s *doubleHashParser) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = int(i) - s.W
 	s.W = int(i)
+	s.seqHistograms.add(blk.Sequences)
+	s.h1Hits += h1Hits
+	s.h2Hits += h2Hits
+	s.hm.hits += h1Hits + h2Hits
+	s.hm.misses += misses
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
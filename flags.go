@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultFlagPrefix is used by RegisterFlags if the prefix is empty.
+const defaultFlagPrefix = "lz-"
+
+// sizeFlag is a flag value for sizes in bytes. The suffixes K, M and G, with
+// optional B or iB, select binary multiples.
+type sizeFlag struct {
+	p *int
+}
+
+// parseSize parses a size in bytes with an optional binary unit suffix.
+func parseSize(s string) (int, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(strings.TrimSuffix(t, "B"), "I")
+	shift := 0
+	switch {
+	case strings.HasSuffix(t, "K"):
+		shift = 10
+	case strings.HasSuffix(t, "M"):
+		shift = 20
+	case strings.HasSuffix(t, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		t = t[:len(t)-1]
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("lz: invalid size %q", s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("lz: size %q must not be negative", s)
+	}
+	if n > int64(maxInt)>>shift {
+		return 0, fmt.Errorf("lz: size %q too large", s)
+	}
+	return int(n << shift), nil
+}
+
+func (f sizeFlag) String() string {
+	if f.p == nil {
+		return "0"
+	}
+	return windowSizeString(*f.p)
+}
+
+func (f sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*f.p = n
+	return nil
+}
+
+// effortFlag sets the Effort and EffortSet fields of the configuration, so
+// that effort 0 can be selected on the command line.
+type effortFlag struct {
+	cfg *Config
+}
+
+func (f effortFlag) String() string {
+	if f.cfg == nil {
+		return "0"
+	}
+	return strconv.Itoa(f.cfg.Effort)
+}
+
+func (f effortFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("lz: invalid effort %q", s)
+	}
+	f.cfg.Effort = n
+	f.cfg.EffortSet = true
+	return nil
+}
+
+// RegisterFlags registers the flags effort, memory-budget and min-throughput
+// for the configuration in fs. The flag names are prefixed by prefix, which
+// defaults to "lz-". The flags write directly into cfg, so its current
+// values are the defaults. Sizes can be given with the suffixes K, M and G.
+// Call [Config.ParseFlags] after fs.Parse to verify the values.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	if prefix == "" {
+		prefix = defaultFlagPrefix
+	}
+	fs.Var(effortFlag{cfg}, prefix+"effort", fmt.Sprintf(
+		"compression effort from 1 (fastest) to %d (best);"+
+			" 0 disables compression", maxEffort))
+	fs.Var(sizeFlag{&cfg.MemoryBudget}, prefix+"memory-budget",
+		"maximum memory used by the parser in bytes; 0 means no limit")
+	fs.IntVar(&cfg.MinThroughputMBps, prefix+"min-throughput",
+		cfg.MinThroughputMBps,
+		"minimum throughput in MB/s required by AutoTune")
+}
+
+// ParseFlags completes the configuration after the flags registered by
+// [Config.RegisterFlags] have been parsed and verifies it. Zero values are
+// replaced by defaults.
+func (cfg *Config) ParseFlags(fs *flag.FlagSet) error {
+	if !fs.Parsed() {
+		return errors.New("lz: flags have not been parsed")
+	}
+	cfg.SetDefaults()
+	return cfg.Verify()
+}
+
+// RegisterFlags registers the flags window-size and block-size for the buffer
+// configuration in fs. The flag names are prefixed by prefix, which defaults
+// to "lz-". Zero values select the defaults of the parser configuration. The
+// values are checked when the buffer configuration is used, for instance by
+// [Config.ParserConfig].
+func (bc *BufConfig) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	if prefix == "" {
+		prefix = defaultFlagPrefix
+	}
+	fs.Var(sizeFlag{&bc.WindowSize}, prefix+"window-size",
+		"size of the sliding dictionary window in bytes;"+
+			" 0 selects the default")
+	fs.Var(sizeFlag{&bc.BlockSize}, prefix+"block-size",
+		"maximum number of bytes parsed into a block;"+
+			" 0 selects the default")
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestConfigFlags(t *testing.T) {
+	newFlagSet := func(prefix string) (*flag.FlagSet, *Config, *BufConfig) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cfg, bc := new(Config), new(BufConfig)
+		cfg.RegisterFlags(fs, prefix)
+		bc.RegisterFlags(fs, prefix)
+		return fs, cfg, bc
+	}
+
+	fs, _, _ := newFlagSet("")
+	for _, name := range []string{"lz-effort", "lz-memory-budget",
+		"lz-min-throughput", "lz-window-size", "lz-block-size"} {
+		f := fs.Lookup(name)
+		if f == nil {
+			t.Fatalf("flag %s not registered", name)
+		}
+		if f.Usage == "" {
+			t.Fatalf("flag %s has no usage string", name)
+		}
+	}
+	fs, _, _ = newFlagSet("x-")
+	if fs.Lookup("x-effort") == nil || fs.Lookup("lz-effort") != nil {
+		t.Fatalf("prefix x- not used")
+	}
+
+	fs, cfg, bc := newFlagSet("")
+	err := fs.Parse([]string{"-lz-effort=7", "-lz-memory-budget=64M",
+		"-lz-window-size", "1MiB", "-lz-block-size=65536"})
+	if err != nil {
+		t.Fatalf("fs.Parse error %s", err)
+	}
+	if err = cfg.ParseFlags(fs); err != nil {
+		t.Fatalf("cfg.ParseFlags error %s", err)
+	}
+	wantCfg := Config{Effort: 7, EffortSet: true, MemoryBudget: 64 * miB}
+	if *cfg != wantCfg {
+		t.Fatalf("cfg = %+v; want %+v", *cfg, wantCfg)
+	}
+	wantBC := BufConfig{WindowSize: miB, BlockSize: 64 * kiB}
+	if *bc != wantBC {
+		t.Fatalf("bc = %+v; want %+v", *bc, wantBC)
+	}
+	if _, err = cfg.NewParser(*bc); err != nil {
+		t.Fatalf("cfg.NewParser error %s", err)
+	}
+
+	fs, cfg, _ = newFlagSet("")
+	if err = fs.Parse([]string{"-lz-effort=0"}); err != nil {
+		t.Fatalf("fs.Parse error %s", err)
+	}
+	if err = cfg.ParseFlags(fs); err != nil {
+		t.Fatalf("cfg.ParseFlags error %s", err)
+	}
+	if cfg.Effort != 0 || !cfg.EffortSet {
+		t.Fatalf("-lz-effort=0 resulted in %+v", *cfg)
+	}
+
+	fs, cfg, _ = newFlagSet("")
+	if err = fs.Parse(nil); err != nil {
+		t.Fatalf("fs.Parse error %s", err)
+	}
+	if err = cfg.ParseFlags(fs); err != nil {
+		t.Fatalf("cfg.ParseFlags error %s", err)
+	}
+	if cfg.Effort != 5 {
+		t.Fatalf("default effort %d; want %d", cfg.Effort, 5)
+	}
+
+	for _, args := range [][]string{
+		{"-lz-effort=high"},
+		{"-lz-memory-budget=-1"},
+		{"-lz-memory-budget=12X"},
+		{"-lz-window-size=1T"},
+		{"-lz-min-throughput=fast"},
+	} {
+		fs, _, _ := newFlagSet("")
+		if err = fs.Parse(args); err == nil {
+			t.Fatalf("fs.Parse(%q) returned no error", args)
+		}
+	}
+	fs, cfg, _ = newFlagSet("")
+	if err = fs.Parse([]string{"-lz-effort=12"}); err != nil {
+		t.Fatalf("fs.Parse error %s", err)
+	}
+	if err = cfg.ParseFlags(fs); err == nil {
+		t.Fatalf("cfg.ParseFlags accepted effort 12")
+	}
+	fs, cfg, _ = newFlagSet("")
+	if err = cfg.ParseFlags(fs); err == nil {
+		t.Fatalf("cfg.ParseFlags accepted unparsed flag set")
+	}
+}
@@ -119,6 +119,8 @@ type gsap struct {
 	// been processed
 	bits bitset
 
+	statsAccumulator
+
 	GSAPConfig
 }
 
@@ -195,6 +197,30 @@ func (s *gsap) sort() {
 	}
 }
 
+// Rollback moves the window head back to the checkpoint. The positions at or
+// after the checkpoint are removed from the suffix array marks. See
+// [ParserBuffer.Rollback].
+func (s *gsap) Rollback(cp ParserCheckpoint) error {
+	w := s.W
+	var err error
+	if err = s.ParserBuffer.Rollback(cp); err != nil {
+		return err
+	}
+	if w > len(s.isa) {
+		w = len(s.isa)
+	}
+	for i := s.W; i < w; i++ {
+		s.bits.delete(int(s.isa[i]))
+	}
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse as
+// configured by MinMatchLen.
+func (s *gsap) MatchLen() int {
+	return s.MinMatchLen
+}
+
 // Parse computes the sequences for the next block. Data in the block will be
 // overwritten. The NoTrailingLiterals flag is supported. It returns the number
 // of bytes covered by the computed sequences. If the buffer is empty
@@ -211,11 +237,17 @@ func (s *gsap) Parse(blk *Block, flags int) (n int, err error) {
 		if n == 0 {
 			return 0, ErrEmptyBuffer
 		}
+		// If the suffix array doesn't cover the positions, sort will
+		// mark them later.
+		if s.W+n <= len(s.sa) {
+			for i := s.W; i < s.W+n; i++ {
+				s.bits.insert(int(s.isa[i]))
+			}
+		}
 		s.W += n
 		return n, nil
 	}
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 	if n == 0 {
 		return 0, ErrEmptyBuffer
 	}
@@ -226,7 +258,7 @@ func (s *gsap) Parse(blk *Block, flags int) (n int, err error) {
 
 	p := s.Data[:i+n]
 	litIndex := i
-	for ; i < len(p); i++ {
+	for i < len(p) {
 		j := int(s.isa[i])
 		s.bits.insert(j)
 		k1, ok1 := s.bits.memberBefore(j)
@@ -248,7 +280,7 @@ func (s *gsap) Parse(blk *Block, flags int) (n int, err error) {
 			continue
 		}
 		o := i - f
-		if !(0 < o && o < s.WindowSize) {
+		if !(0 < o && o <= s.WindowSize) {
 			i++
 			continue
 		}
@@ -275,5 +307,6 @@ func (s *gsap) Parse(blk *Block, flags int) (n int, err error) {
 
 	n = i - s.W
 	s.W = i
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
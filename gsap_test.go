@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// parseCost parses the data completely and returns the cost of all blocks
+// and the decoded data.
+func parseCost(t *testing.T, cfg ParserConfig, data []byte) (cost int64,
+	decoded []byte) {
+	s := Wrap(bytes.NewReader(data), newTestParser(t, cfg))
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{
+		WindowSize: cfg.BufConfig().WindowSize})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	var blk Block
+	for {
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		cost += blk.Cost(XZCost)
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	return cost, buf.Bytes()
+}
+
+func TestGSAPCompression(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+
+	const windowSize = 64 * kiB
+	hpCost, hpData := parseCost(t, &HPConfig{WindowSize: windowSize}, data)
+	gsapCost, gsapData := parseCost(t, &GSAPConfig{WindowSize: windowSize},
+		data)
+	if !bytes.Equal(hpData, data) {
+		t.Fatalf("HP decoded data differs from input")
+	}
+	if !bytes.Equal(gsapData, data) {
+		t.Fatalf("GSAP decoded data differs from input")
+	}
+	t.Logf("HP cost %d; GSAP cost %d", hpCost, gsapCost)
+	if gsapCost > hpCost {
+		t.Fatalf("GSAP cost %d larger than HP cost %d", gsapCost,
+			hpCost)
+	}
+}
+
+func FuzzGSAPvsHP(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="))
+	f.Add([]byte("abbababbabbababbababbabbababbabb"))
+	f.Fuzz(func(t *testing.T, p []byte) {
+		bc := BufConfig{WindowSize: 1024, BlockSize: 512}
+		hp := &HPConfig{}
+		hp.SetBufConfig(bc)
+		gsap := &GSAPConfig{}
+		gsap.SetBufConfig(bc)
+		_, hpData := parseCost(t, hp, p)
+		_, gsapData := parseCost(t, gsap, p)
+		if !bytes.Equal(gsapData, hpData) {
+			t.Fatalf("GSAP decoded data differs from HP")
+		}
+		if !bytes.Equal(gsapData, p) {
+			t.Fatalf("GSAP decoded data differs from input")
+		}
+	})
+}
+
+// BenchmarkGSAPvsHP compares the throughput and the compression of the
+// greedy suffix array parser with the hash parser. The metric bits/byte
+// gives the XZ cost of the blocks per input byte.
+func BenchmarkGSAPvsHP(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	const windowSize = 256 * kiB
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: windowSize},
+		&GSAPConfig{WindowSize: windowSize},
+	} {
+		b.Run(parserName(cfg), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var cost int64
+			for i := 0; i < b.N; i++ {
+				cost = 0
+				s := Wrap(bytes.NewReader(data),
+					newTestParser(b, cfg))
+				var blk Block
+				for {
+					if _, err := s.Parse(&blk, 0); err != nil {
+						if err == io.EOF {
+							break
+						}
+						b.Fatalf("s.Parse error %s", err)
+					}
+					cost += blk.Cost(XZCost)
+				}
+			}
+			b.ReportMetric(float64(cost)/float64(len(data)),
+				"bits/byte")
+		})
+	}
+}
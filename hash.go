@@ -7,6 +7,7 @@ package lz
 import (
 	"errors"
 	"fmt"
+	"math/bits"
 	"reflect"
 )
 
@@ -20,6 +21,13 @@ func hashValue(x uint64, shift uint) uint32 {
 	return uint32((x * prime) >> shift)
 }
 
+// DefaultHash is the hash function used by the parsers. The input string is
+// stored in x with the first byte stored on the lowest bits. The hash value
+// must use only the 64 - shift lowest bits.
+func DefaultHash(x uint64, shift uint) uint32 {
+	return hashValue(x, shift)
+}
+
 // hashEntry is used for hashEntry. The value field allows a fast check whether
 // a match has been found, which is cache-optimized.
 type hashEntry struct {
@@ -73,6 +81,14 @@ func (h *hash) reset() {
 	}
 }
 
+// copyFrom makes h a deep copy of src. The memory of the table is reused if
+// possible.
+func (h *hash) copyFrom(src *hash) {
+	table := append(h.table[:0], src.table...)
+	*h = *src
+	h.table = table
+}
+
 // shiftOffsets removes delta from all positions in the hash table. Entries with
 // positions smaller than delta will be cleared.
 func (h *hash) shiftOffsets(delta uint32) {
@@ -88,6 +104,52 @@ func (h *hash) shiftOffsets(delta uint32) {
 	}
 }
 
+// evict clears all entries with positions that are more than windowSize bytes
+// behind position w.
+func (h *hash) evict(w, windowSize int) {
+	for i, e := range h.table {
+		if int64(w)-int64(e.pos) > int64(windowSize) {
+			h.table[i] = hashEntry{}
+		}
+	}
+}
+
+// clearFrom clears all entries with positions larger or equal w.
+func (h *hash) clearFrom(w int) {
+	for i, e := range h.table {
+		if int64(e.pos) >= int64(w) {
+			h.table[i] = hashEntry{}
+		}
+	}
+}
+
+// allocBits returns the number of hash bits supported by the allocated table.
+func (h *hash) allocBits() int {
+	return bits.Len(uint(cap(h.table))) - 1
+}
+
+// setBits restricts the hash to the first 1<<hashBits entries of the allocated
+// table, which must be large enough. The table is cleared.
+func (h *hash) setBits(hashBits int) {
+	h.table = h.table[:1<<hashBits]
+	h.shift = 64 - uint(hashBits)
+	h.reset()
+}
+
+// tuneBits returns the hash bits for a table holding the estimated number of
+// distinct strings with a load factor of about 0.5. The result is not larger
+// than maxBits and not smaller than 8, unless maxBits is smaller.
+func tuneBits(distinct float64, maxBits int) int {
+	b := 8
+	for b < maxBits && float64(int64(1)<<b) < 2*distinct {
+		b++
+	}
+	if b > maxBits {
+		b = maxBits
+	}
+	return b
+}
+
 // hashConfig provides the configuration for the hash match finder.
 type hashConfig struct {
 	InputLen int
@@ -177,6 +239,12 @@ func (f *hashDictionary) Reset(data []byte) error {
 	return nil
 }
 
+// copyFrom makes f a deep copy of src.
+func (f *hashDictionary) copyFrom(src *hashDictionary) {
+	f.ParserBuffer = *src.ParserBuffer.Clone()
+	f.hash.copyFrom(&src.hash)
+}
+
 func (f *hashDictionary) Shrink() int {
 	delta := f.ParserBuffer.Shrink()
 	if delta > 0 {
@@ -185,6 +253,40 @@ func (f *hashDictionary) Shrink() int {
 	return delta
 }
 
+// EvictOlderThan removes all hash entries for positions more than maxOffset
+// bytes behind the window head. Matches for the data preceding that distance
+// will not be found anymore. Entries added later are not affected. The cost
+// is proportional to the size of the hash table.
+func (f *hashDictionary) EvictOlderThan(maxOffset uint32) {
+	if int64(maxOffset) >= int64(f.W) {
+		// No entry can be older.
+		return
+	}
+	f.hash.evict(f.W, int(maxOffset))
+}
+
+// Rollback moves the window head back to the checkpoint and clears the hash
+// entries for the positions at or after it. Entries for earlier positions
+// that have been overwritten since the checkpoint are not restored, so
+// parsing the data again may find fewer matches. See
+// [ParserBuffer.Rollback].
+func (f *hashDictionary) Rollback(cp ParserCheckpoint) error {
+	var err error
+	if err = f.ParserBuffer.Rollback(cp); err != nil {
+		return err
+	}
+	f.hash.clearFrom(f.W)
+	return nil
+}
+
+// Skip adds the next n bytes of the buffer to the search structures without
+// generating sequences. The bytes become part of the window and can be
+// referenced by later matches. If fewer than n bytes are buffered, all of them
+// are skipped. The method returns the number of skipped bytes.
+func (f *hashDictionary) Skip(n int) (int, error) {
+	return f.skip(n, f.inputLen, f.processSegment)
+}
+
 // ProcessSegment adds the hashes between position a and b into the hash.
 func (f *hashDictionary) processSegment(a, b int) {
 	if a < 0 {
@@ -289,6 +391,30 @@ type doubleHashDictionary struct {
 	ParserBuffer
 	h1 hash
 	h2 hash
+
+	// hash functions for h1 and h2; nil selects hashValue, which can be
+	// inlined in the hot loops
+	hash1 func(x uint64, shift uint) uint32
+	hash2 func(x uint64, shift uint) uint32
+}
+
+// hashValue1 computes the hash for table h1.
+func (f *doubleHashDictionary) hashValue1(x uint64) uint32 {
+	return hashWith(f.hash1, x, f.h1.shift)
+}
+
+// hashValue2 computes the hash for table h2.
+func (f *doubleHashDictionary) hashValue2(x uint64) uint32 {
+	return hashWith(f.hash2, x, f.h2.shift)
+}
+
+// hashWith computes the hash value using fn or hashValue if fn is nil. The
+// function is small enough to be inlined.
+func hashWith(fn func(x uint64, shift uint) uint32, x uint64, shift uint) uint32 {
+	if fn == nil {
+		return hashValue(x, shift)
+	}
+	return fn(x, shift)
 }
 
 func (f *doubleHashDictionary) init(cfg dhConfig, bcfg BufConfig) error {
@@ -303,8 +429,67 @@ func (f *doubleHashDictionary) init(cfg dhConfig, bcfg BufConfig) error {
 	if err = f.h1.init(cfg.H1.InputLen, cfg.H1.HashBits); err != nil {
 		return err
 	}
-	err = f.h2.init(cfg.H2.InputLen, cfg.H2.HashBits)
-	return err
+	if err = f.h2.init(cfg.H2.InputLen, cfg.H2.HashBits); err != nil {
+		return err
+	}
+	f.hash1, f.hash2 = nil, nil
+	return nil
+}
+
+// TuneForText adjusts the number of hash bits used by the two hash tables to
+// the number of distinct input strings in the sample, which is estimated
+// using a HyperLogLog sketch. Data with few distinct strings uses only a part
+// of the tables, which improves the cache locality. The allocated tables are
+// never enlarged, so the configured HashBits are an upper limit and continue
+// to describe the memory used. The tables are rebuilt from the current
+// window, so the data already parsed can still be referenced.
+func (f *doubleHashDictionary) TuneForText(sample []byte) error {
+	if len(sample) < f.h2.inputLen {
+		return fmt.Errorf("lz: sample length %d less than InputLen2=%d",
+			len(sample), f.h2.inputLen)
+	}
+	var s1, s2 hyperLogLog
+	for i := 0; i+f.h1.inputLen <= len(sample); i++ {
+		x := getLE64(sample[i:min(i+8, len(sample))])
+		s1.add(x & f.h1.mask)
+		if i+f.h2.inputLen <= len(sample) {
+			s2.add(x & f.h2.mask)
+		}
+	}
+	f.h1.setBits(tuneBits(s1.estimate(), f.h1.allocBits()))
+	f.h2.setBits(tuneBits(s2.estimate(), f.h2.allocBits()))
+	if f.W > 0 {
+		f.processSegment(doz(f.W, f.WindowSize), f.W)
+	}
+	return nil
+}
+
+// SetHashFunctions replaces the hash functions for the two hash tables. A nil
+// function selects [DefaultHash]. The functions must return values that use
+// only the 64 - shift lowest bits. The hash tables will be cleared.
+func (f *doubleHashDictionary) SetHashFunctions(
+	h1, h2 func(x uint64, shift uint) uint32) {
+	f.hash1, f.hash2 = h1, h2
+	f.h1.reset()
+	f.h2.reset()
+}
+
+func (f *doubleHashDictionary) Reset(data []byte) error {
+	var err error
+	if err = f.ParserBuffer.Reset(data); err != nil {
+		return err
+	}
+	f.h1.reset()
+	f.h2.reset()
+	return nil
+}
+
+// copyFrom makes f a deep copy of src.
+func (f *doubleHashDictionary) copyFrom(src *doubleHashDictionary) {
+	f.ParserBuffer = *src.ParserBuffer.Clone()
+	f.h1.copyFrom(&src.h1)
+	f.h2.copyFrom(&src.h2)
+	f.hash1, f.hash2 = src.hash1, src.hash2
 }
 
 func (f *doubleHashDictionary) Shrink() int {
@@ -316,6 +501,37 @@ func (f *doubleHashDictionary) Shrink() int {
 	return delta
 }
 
+// EvictOlderThan removes the entries of both hash tables for positions more
+// than maxOffset bytes behind the window head. See
+// [hashDictionary.EvictOlderThan].
+func (f *doubleHashDictionary) EvictOlderThan(maxOffset uint32) {
+	if int64(maxOffset) >= int64(f.W) {
+		// No entry can be older.
+		return
+	}
+	f.h1.evict(f.W, int(maxOffset))
+	f.h2.evict(f.W, int(maxOffset))
+}
+
+// Rollback moves the window head back to the checkpoint and clears the
+// entries of both hash tables for the positions at or after it. See
+// [hashDictionary.Rollback].
+func (f *doubleHashDictionary) Rollback(cp ParserCheckpoint) error {
+	var err error
+	if err = f.ParserBuffer.Rollback(cp); err != nil {
+		return err
+	}
+	f.h1.clearFrom(f.W)
+	f.h2.clearFrom(f.W)
+	return nil
+}
+
+// Skip adds the next n bytes of the buffer to the search structures without
+// generating sequences. See [hashDictionary.Skip].
+func (f *doubleHashDictionary) Skip(n int) (int, error) {
+	return f.skip(n, f.h2.inputLen, f.processSegment)
+}
+
 // processSegment adds the hashes between position a and b into the hash.
 func (f *doubleHashDictionary) processSegment(a, b int) {
 	if a < 0 {
@@ -340,17 +556,208 @@ func (f *doubleHashDictionary) processSegment(a, b int) {
 
 	_p := f.Data[:b1+7]
 	for i := a; i < b2; i++ {
-		x := _getLE64(_p[i:])
-		e := hashEntry{
-			pos:   uint32(i),
+		y := _getLE64(_p[i:])
+		pos := uint32(i)
+		x := y & h1.mask
+		h1.table[f.hashValue1(x)] = hashEntry{
+			pos:   pos,
+			value: uint32(x),
+		}
+		x = y & h2.mask
+		h2.table[f.hashValue2(x)] = hashEntry{
+			pos:   pos,
 			value: uint32(x),
 		}
-		h1.table[hashValue(x&h1.mask, h1.shift)] = e
-		h2.table[hashValue(x&h2.mask, h2.shift)] = e
 	}
 	for i := b2; i < b1; i++ {
-		x := _getLE64(_p[i:])
-		h1.table[hashValue(x&h1.mask, h1.shift)] = hashEntry{
+		x := _getLE64(_p[i:]) & h1.mask
+		h1.table[f.hashValue1(x)] = hashEntry{
+			pos:   uint32(i),
+			value: uint32(x),
+		}
+	}
+}
+
+// thConfig describes the three hash tables of the triple hash parser. The
+// input lengths must be increasing.
+type thConfig struct {
+	H1 hashConfig
+	H2 hashConfig
+	H3 hashConfig
+}
+
+// SetDefaults sets the input lengths 3, 5 and 8 if they are zero. The second
+// input length is always two bytes longer than the first.
+func (cfg *thConfig) SetDefaults() {
+	cfg.H1.SetDefaults()
+	if cfg.H2.InputLen == 0 {
+		cfg.H2.InputLen = cfg.H1.InputLen + 2
+	}
+	if cfg.H3.InputLen == 0 {
+		cfg.H3.InputLen = 8
+	}
+	cfg.H2.SetDefaults()
+	cfg.H3.SetDefaults()
+}
+
+// Verify checks the configuration parameters.
+func (cfg *thConfig) Verify() error {
+	var err error
+	d := dhConfig{H1: cfg.H1, H2: cfg.H2}
+	if err = d.Verify(); err != nil {
+		return err
+	}
+	if err = cfg.H3.Verify(); err != nil {
+		return err
+	}
+	il2, il3 := cfg.H2.InputLen, cfg.H3.InputLen
+	if !(il2 < il3) {
+		return fmt.Errorf("lz: inputLen2=%d must be < inputLen3=%d",
+			il2, il3)
+	}
+	return nil
+}
+
+// tripleHashDictionary adds a third hash table with the longest input length
+// to the double hash dictionary. The third table always uses [DefaultHash];
+// the promoted SetHashFunctions method replaces only the hash functions of
+// the first two tables and keeps the entries of the third table.
+type tripleHashDictionary struct {
+	doubleHashDictionary
+	h3 hash
+}
+
+func (f *tripleHashDictionary) init(cfg thConfig, bcfg BufConfig) error {
+	var err error
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err != nil {
+		return err
+	}
+	d := dhConfig{H1: cfg.H1, H2: cfg.H2}
+	if err = f.doubleHashDictionary.init(d, bcfg); err != nil {
+		return err
+	}
+	if err = f.h3.init(cfg.H3.InputLen, cfg.H3.HashBits); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TuneForText adjusts the number of hash bits used by all three hash tables
+// to the sample like [doubleHashDictionary.TuneForText] and rebuilds the
+// tables from the current window.
+func (f *tripleHashDictionary) TuneForText(sample []byte) error {
+	if len(sample) < f.h3.inputLen {
+		return fmt.Errorf("lz: sample length %d less than InputLen3=%d",
+			len(sample), f.h3.inputLen)
+	}
+	var s1, s2, s3 hyperLogLog
+	for i := 0; i+f.h1.inputLen <= len(sample); i++ {
+		x := getLE64(sample[i:min(i+8, len(sample))])
+		s1.add(x & f.h1.mask)
+		if i+f.h2.inputLen <= len(sample) {
+			s2.add(x & f.h2.mask)
+		}
+		if i+f.h3.inputLen <= len(sample) {
+			s3.add(x & f.h3.mask)
+		}
+	}
+	f.h1.setBits(tuneBits(s1.estimate(), f.h1.allocBits()))
+	f.h2.setBits(tuneBits(s2.estimate(), f.h2.allocBits()))
+	f.h3.setBits(tuneBits(s3.estimate(), f.h3.allocBits()))
+	if f.W > 0 {
+		f.processSegment(doz(f.W, f.WindowSize), f.W)
+	}
+	return nil
+}
+
+func (f *tripleHashDictionary) Reset(data []byte) error {
+	var err error
+	if err = f.doubleHashDictionary.Reset(data); err != nil {
+		return err
+	}
+	f.h3.reset()
+	return nil
+}
+
+// copyFrom makes f a deep copy of src.
+func (f *tripleHashDictionary) copyFrom(src *tripleHashDictionary) {
+	f.doubleHashDictionary.copyFrom(&src.doubleHashDictionary)
+	f.h3.copyFrom(&src.h3)
+}
+
+func (f *tripleHashDictionary) Shrink() int {
+	delta := f.doubleHashDictionary.Shrink()
+	if delta > 0 {
+		f.h3.shiftOffsets(uint32(delta))
+	}
+	return delta
+}
+
+// EvictOlderThan removes the entries of all three hash tables for positions
+// more than maxOffset bytes behind the window head. See
+// [hashDictionary.EvictOlderThan].
+func (f *tripleHashDictionary) EvictOlderThan(maxOffset uint32) {
+	if int64(maxOffset) >= int64(f.W) {
+		// No entry can be older.
+		return
+	}
+	f.doubleHashDictionary.EvictOlderThan(maxOffset)
+	f.h3.evict(f.W, int(maxOffset))
+}
+
+// Rollback moves the window head back to the checkpoint and clears the
+// entries of all three hash tables for the positions at or after it. See
+// [hashDictionary.Rollback].
+func (f *tripleHashDictionary) Rollback(cp ParserCheckpoint) error {
+	var err error
+	if err = f.doubleHashDictionary.Rollback(cp); err != nil {
+		return err
+	}
+	f.h3.clearFrom(f.W)
+	return nil
+}
+
+// Skip adds the next n bytes of the buffer to the search structures without
+// generating sequences. See [hashDictionary.Skip].
+func (f *tripleHashDictionary) Skip(n int) (int, error) {
+	return f.skip(n, f.h3.inputLen, f.processSegment)
+}
+
+// processSegment adds the hashes between position a and b into the three
+// hash tables.
+func (f *tripleHashDictionary) processSegment(a, b int) {
+	if a < 0 {
+		a = 0
+	}
+	h1, h2, h3 := &f.h1, &f.h2, &f.h3
+	// The input lengths are increasing, so b1 >= b2 >= b3.
+	b1 := min(b, len(f.Data)-h1.inputLen+1)
+	if b1 <= a {
+		return
+	}
+	b2 := min(b, len(f.Data)-h2.inputLen+1)
+	b3 := min(b, len(f.Data)-h3.inputLen+1)
+
+	// Each table stores the value masked to its own input length.
+	_p := f.Data[:b1+7]
+	for i := a; i < b1; i++ {
+		x := _getLE64(_p[i:]) & h1.mask
+		h1.table[f.hashValue1(x)] = hashEntry{
+			pos:   uint32(i),
+			value: uint32(x),
+		}
+	}
+	for i := a; i < b2; i++ {
+		x := _getLE64(_p[i:]) & h2.mask
+		h2.table[f.hashValue2(x)] = hashEntry{
+			pos:   uint32(i),
+			value: uint32(x),
+		}
+	}
+	for i := a; i < b3; i++ {
+		x := _getLE64(_p[i:]) & h3.mask
+		h3.table[hashValue(x, h3.shift)] = hashEntry{
 			pos:   uint32(i),
 			value: uint32(x),
 		}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import "math/bits"
+
+// seqCounts contains the histograms of the match lengths and offsets.
+type seqCounts struct {
+	matchLens [8]int64
+	offsets   [24]int64
+}
+
+// seqHistograms collects histograms of the sequences generated by a parser.
+// The counters are allocated by the first call of one of the histogram
+// methods; until then Parse doesn't spend any time on them.
+type seqHistograms struct {
+	counts *seqCounts
+}
+
+// log2Bucket returns the index of the bucket containing x, which covers the
+// values from 2^k to 2^(k+1)-1. Values exceeding the last bucket are
+// counted in it.
+func log2Bucket(x uint32, n int) int {
+	k := bits.Len32(x) - 1
+	if k >= n {
+		k = n - 1
+	}
+	return k
+}
+
+// add counts the sequences. Only matches are counted, sequences with a
+// match length of zero are ignored.
+func (h *seqHistograms) add(seqs []Seq) {
+	c := h.counts
+	if c == nil {
+		return
+	}
+	for _, s := range seqs {
+		if s.MatchLen == 0 {
+			continue
+		}
+		c.matchLens[log2Bucket(s.MatchLen, len(c.matchLens))]++
+		c.offsets[log2Bucket(s.Offset, len(c.offsets))]++
+	}
+}
+
+// reset clears the counters.
+func (h *seqHistograms) reset() {
+	if h.counts != nil {
+		*h.counts = seqCounts{}
+	}
+}
+
+// copyFrom makes h a deep copy of src.
+func (h *seqHistograms) copyFrom(src *seqHistograms) {
+	if src.counts == nil {
+		h.counts = nil
+		return
+	}
+	c := *src.counts
+	h.counts = &c
+}
+
+// MatchLengthHistogram returns the number of matches in buckets; bucket k
+// counts the matches with lengths from 2^k to 2^(k+1)-1. Longer matches are
+// counted in the last bucket. The counters accumulate over all Parse calls
+// following the first call of MatchLengthHistogram or OffsetHistogram and
+// are cleared by Reset.
+func (h *seqHistograms) MatchLengthHistogram() [8]int64 {
+	if h.counts == nil {
+		h.counts = new(seqCounts)
+	}
+	return h.counts.matchLens
+}
+
+// OffsetHistogram returns the number of matches in buckets; bucket k counts
+// the matches with offsets from 2^k to 2^(k+1)-1. Larger offsets are counted
+// in the last bucket. See [seqHistograms.MatchLengthHistogram] when the
+// counting starts.
+func (h *seqHistograms) OffsetHistogram() [24]int64 {
+	if h.counts == nil {
+		h.counts = new(seqCounts)
+	}
+	return h.counts.offsets
+}
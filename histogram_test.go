@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import "testing"
+
+func TestParserHistograms(t *testing.T) {
+	type histParser interface {
+		Parser
+		MatchLengthHistogram() [8]int64
+		OffsetHistogram() [24]int64
+	}
+	// The first string contains a single match of length 8 at offset 8,
+	// the second a single match of length 3 at offset 4.
+	tests := []string{"abcdefghabcdefgh", "klm-klm"}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 1024},
+		&BHPConfig{WindowSize: 1024},
+		&DHPConfig{WindowSize: 1024},
+	} {
+		s, ok := newTestParser(t, cfg).(histParser)
+		if !ok {
+			t.Fatalf("%T doesn't support histograms", cfg)
+		}
+		if h := s.MatchLengthHistogram(); h != [8]int64{} {
+			t.Fatalf("%T: initial histogram %v; want zeros", s, h)
+		}
+		var blk Block
+		for _, str := range tests {
+			if _, err := s.Write([]byte(str)); err != nil {
+				t.Fatalf("s.Write error %s", err)
+			}
+			if _, err := s.Parse(&blk, 0); err != nil {
+				t.Fatalf("s.Parse error %s", err)
+			}
+			t.Logf("%T: %q %+v", s, str, blk.Sequences)
+		}
+		var wantLens [8]int64
+		wantLens[3], wantLens[1] = 1, 1
+		if h := s.MatchLengthHistogram(); h != wantLens {
+			t.Fatalf("%T: MatchLengthHistogram() = %v; want %v",
+				s, h, wantLens)
+		}
+		var wantOffsets [24]int64
+		wantOffsets[3], wantOffsets[2] = 1, 1
+		if h := s.OffsetHistogram(); h != wantOffsets {
+			t.Fatalf("%T: OffsetHistogram() = %v; want %v",
+				s, h, wantOffsets)
+		}
+
+		if err := s.Reset(nil); err != nil {
+			t.Fatalf("s.Reset error %s", err)
+		}
+		if h := s.OffsetHistogram(); h != [24]int64{} {
+			t.Fatalf("%T: OffsetHistogram() after Reset = %v;"+
+				" want zeros", s, h)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"math"
+	"math/bits"
+)
+
+// hllBits gives the number of index bits of the HyperLogLog sketch. The
+// standard error of the estimate is about 1.04/sqrt(1<<hllBits) or 3.3%.
+const hllBits = 10
+
+// hyperLogLog estimates the number of distinct values using the HyperLogLog
+// sketch of Flajolet, Fusy, Gandouet and Meunier (2007).
+type hyperLogLog struct {
+	reg [1 << hllBits]uint8
+}
+
+// mix64 is the finalizer of the SplitMix64 generator. It distributes the
+// bits of x over the whole word.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// add adds the value x to the sketch.
+func (h *hyperLogLog) add(x uint64) {
+	x = mix64(x)
+	i := x >> (64 - hllBits)
+	// The or-ed bit limits the rank to 64 - hllBits + 1.
+	r := uint8(bits.LeadingZeros64(x<<hllBits|1<<(hllBits-1))) + 1
+	if r > h.reg[i] {
+		h.reg[i] = r
+	}
+}
+
+// estimate returns the estimated number of distinct values added to the
+// sketch. Small cardinalities are estimated using linear counting.
+func (h *hyperLogLog) estimate() float64 {
+	const m = 1 << hllBits
+	var sum float64
+	zeros := 0
+	for _, r := range h.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return e
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000, 1000000} {
+		var h hyperLogLog
+		for k := 0; k < 3; k++ {
+			for i := 0; i < n; i++ {
+				h.add(uint64(i))
+			}
+		}
+		e := h.estimate()
+		t.Logf("n=%d: estimate %.1f", n, e)
+		if d := math.Abs(e - float64(n)); d > 0.1*float64(n)+1 {
+			t.Fatalf("n=%d: estimate %.1f too far off", n, e)
+		}
+	}
+}
@@ -5,6 +5,11 @@
 package lz
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"math/bits"
 )
 
@@ -12,6 +17,12 @@ import (
 // table.
 type hashParser struct {
 	hashDictionary
+	seqHistograms
+	statsAccumulator
+	hm hashMetrics
+
+	// maxMatchLen limits the length of matches if it is positive.
+	maxMatchLen int
 
 	HPConfig
 }
@@ -114,6 +125,241 @@ func (s *hashParser) ParserConfig() ParserConfig {
 	return &s.HPConfig
 }
 
+// Reset puts the parser into its initial state with the data slice as buffer
+// content. The histograms are cleared.
+func (s *hashParser) Reset(data []byte) error {
+	var err error
+	if err = s.hashDictionary.Reset(data); err != nil {
+		return err
+	}
+	s.seqHistograms.reset()
+	return nil
+}
+
+// PreHash loads the tail of data, at most WindowSize bytes, as dictionary
+// into the parser and adds it to the hash table. The parser is reset before.
+// No sequences are generated for the dictionary, but the following data can
+// reference it. The decoder must be initialized with the same dictionary
+// using [DecoderBuffer.PreloadDictionary].
+func (s *hashParser) PreHash(data []byte) error {
+	var err error
+	if err = s.Reset(nil); err != nil {
+		return err
+	}
+	data = data[doz(len(data), min(s.WindowSize, s.BufferSize)):]
+	if _, err = s.Write(data); err != nil {
+		return err
+	}
+	s.processSegment(0, len(s.Data))
+	s.W = len(s.Data)
+	return nil
+}
+
+// CopyState makes the state of the parser a deep copy of the state of src,
+// which must be a parser of the same type with the same configuration. A
+// parser wrapped for profiler labels is unwrapped. Otherwise an error
+// wrapping [ErrIncompatibleConfig] is returned. After the call both parsers
+// will produce the same sequences for the same input.
+func (s *hashParser) CopyState(src Parser) error {
+	t, ok := unwrapParser(src).(*hashParser)
+	if !ok {
+		return fmt.Errorf("%w: can't copy state from %T to %T",
+			ErrIncompatibleConfig, src, s)
+	}
+	if s.HPConfig != t.HPConfig {
+		return fmt.Errorf("%w: HPConfig %+v differs from %+v",
+			ErrIncompatibleConfig, t.HPConfig, s.HPConfig)
+	}
+	s.hashDictionary.copyFrom(&t.hashDictionary)
+	s.seqHistograms.copyFrom(&t.seqHistograms)
+	s.statsAccumulator = t.statsAccumulator
+	s.hm = t.hm
+	s.maxMatchLen = t.maxMatchLen
+	return nil
+}
+
+// hpStateMagic starts the state blob created by MarshalState.
+var hpStateMagic = [3]byte{'L', 'Z', 'H'}
+
+// hpStateVersion is the version of the state blob format.
+const hpStateVersion = 1
+
+// ErrStateFormat indicates that a parser state blob cannot be decoded.
+var ErrStateFormat = errors.New("lz: invalid parser state format")
+
+// MarshalState serializes the state of the parser into a binary blob, which
+// can be restored by UnmarshalState after a restart of the program. The blob
+// contains the window, the data not parsed yet and the hash table. Data in
+// front of the window is not included. The configuration parameters InputLen,
+// HashBits and WindowSize are stored to detect incompatible parsers. The
+// histograms, statistics and metrics are not part of the state.
+func (s *hashParser) MarshalState() ([]byte, error) {
+	start := doz(s.W, s.WindowSize)
+	data := s.Data[start:]
+	p := make([]byte, 0, 4+8*binary.MaxVarintLen64+len(data)+
+		8*len(s.table))
+	p = append(p, hpStateMagic[:]...)
+	p = append(p, hpStateVersion)
+	for _, x := range []int{s.InputLen, s.HashBits, s.WindowSize,
+		s.BlockSize, s.maxMatchLen} {
+		p = binary.AppendUvarint(p, uint64(x))
+	}
+	p = binary.AppendUvarint(p, uint64(s.Off+int64(start)))
+	p = binary.AppendUvarint(p, uint64(s.W-start))
+	p = binary.AppendUvarint(p, uint64(len(data)))
+	p = append(p, data...)
+	for _, e := range s.table {
+		if int(e.pos) < start {
+			// The entry is outside of the window.
+			e = hashEntry{}
+		} else {
+			e.pos -= uint32(start)
+		}
+		p = binary.LittleEndian.AppendUint32(p, e.pos)
+		p = binary.LittleEndian.AppendUint32(p, e.value)
+	}
+	return p, nil
+}
+
+// UnmarshalState restores the state serialized by MarshalState. The parser
+// must have the same InputLen, HashBits and WindowSize as the parser that
+// created the blob; otherwise an error wrapping [ErrIncompatibleConfig] is
+// returned. The block size is restored. An error wrapping [ErrStateFormat]
+// is returned for a corrupted blob. The parser is not modified if an error is
+// returned.
+func (s *hashParser) UnmarshalState(p []byte) error {
+	if len(p) < 4 || !bytes.Equal(p[:3], hpStateMagic[:]) {
+		return fmt.Errorf("%w: wrong magic bytes", ErrStateFormat)
+	}
+	if p[3] != hpStateVersion {
+		return fmt.Errorf("%w: unsupported version %d",
+			ErrStateFormat, p[3])
+	}
+	p = p[4:]
+	var hdr [8]uint64
+	for i := range hdr {
+		x, k := binary.Uvarint(p)
+		if k <= 0 || (i != 5 && x > maxInt) {
+			return fmt.Errorf("%w: invalid header", ErrStateFormat)
+		}
+		hdr[i] = x
+		p = p[k:]
+	}
+	inputLen, hashBits, windowSize := int(hdr[0]), int(hdr[1]), int(hdr[2])
+	if inputLen != s.InputLen || hashBits != s.HashBits ||
+		windowSize != s.WindowSize {
+		return fmt.Errorf(
+			"%w: state for InputLen=%d, HashBits=%d and WindowSize=%d",
+			ErrIncompatibleConfig, inputLen, hashBits, windowSize)
+	}
+	blockSize, maxMatchLen := int(hdr[3]), int(hdr[4])
+	if blockSize < 1 || (maxMatchLen > 0 && maxMatchLen < inputLen) {
+		return fmt.Errorf("%w: invalid BlockSize=%d or maxMatchLen=%d",
+			ErrStateFormat, blockSize, maxMatchLen)
+	}
+	if hdr[5] > math.MaxInt64 {
+		return fmt.Errorf("%w: invalid offset", ErrStateFormat)
+	}
+	off, w, n := int64(hdr[5]), int(hdr[6]), int(hdr[7])
+	if n > s.BufferSize {
+		return fmt.Errorf("%w: state has %d bytes; more than"+
+			" BufferSize=%d", ErrIncompatibleConfig, n, s.BufferSize)
+	}
+	if w > n || w > windowSize {
+		return fmt.Errorf("%w: window head %d out of range",
+			ErrStateFormat, w)
+	}
+	if len(p) != n+8*len(s.table) {
+		return fmt.Errorf("%w: state has %d bytes; want %d",
+			ErrStateFormat, len(p), n+8*len(s.table))
+	}
+
+	// The capacity limit forces a copy of the data.
+	if err := s.ParserBuffer.Reset(p[:n:n]); err != nil {
+		return err
+	}
+	s.W = w
+	s.Off = off
+	p = p[n:]
+	for i := range s.table {
+		s.table[i] = hashEntry{
+			pos:   binary.LittleEndian.Uint32(p),
+			value: binary.LittleEndian.Uint32(p[4:]),
+		}
+		p = p[8:]
+	}
+	s.ParserBuffer.BlockSize = blockSize
+	s.HPConfig.BlockSize = blockSize
+	s.maxMatchLen = maxMatchLen
+	s.seqHistograms.reset()
+	return nil
+}
+
+// ExtendWindow increases the window size of the parser by extra bytes. The
+// hash table is not updated, entries outside of the window are ignored by
+// Parse anyway.
+func (s *hashParser) ExtendWindow(extra int) error {
+	var err error
+	if err = s.ParserBuffer.ExtendWindow(extra); err != nil {
+		return err
+	}
+	s.HPConfig.WindowSize = s.ParserBuffer.WindowSize
+	return nil
+}
+
+// SetWindowSize sets the window size of the parser to n, which must be in the
+// range [InputLen..BufferSize]. If the window is reduced the hash table
+// entries outside of the new window are evicted.
+func (s *hashParser) SetWindowSize(n int) error {
+	if !(s.InputLen <= n && n <= s.BufferSize) {
+		return fmt.Errorf(
+			"lz: window size %d out of range [InputLen=%d..BufferSize=%d]",
+			n, s.InputLen, s.BufferSize)
+	}
+	if n < s.WindowSize {
+		s.hash.evict(s.W, n)
+	}
+	s.ParserBuffer.WindowSize = n
+	s.HPConfig.WindowSize = n
+	return nil
+}
+
+// Metrics returns the performance data of the parser. In addition to the
+// keys of all parsers it provides hash_hits and hash_misses.
+func (s *hashParser) Metrics() map[string]int64 {
+	m := s.statsAccumulator.Metrics()
+	s.hm.addMetrics(m)
+	return m
+}
+
+// ResetMetrics clears the counters reported by Metrics.
+func (s *hashParser) ResetMetrics() {
+	s.statsAccumulator.ResetMetrics()
+	s.hm = hashMetrics{}
+}
+
+// SetMaxMatchLen limits the length of the matches generated by Parse to n
+// bytes. The value zero removes the limit. The limit must not be smaller than
+// InputLen.
+func (s *hashParser) SetMaxMatchLen(n int) error {
+	if n < 0 {
+		return fmt.Errorf("lz: maxMatchLen %d must not be negative", n)
+	}
+	if n > 0 && n < s.InputLen {
+		return fmt.Errorf("lz: maxMatchLen %d < InputLen %d",
+			n, s.InputLen)
+	}
+	s.maxMatchLen = n
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse and
+// MatchAt. Hash hits are verified byte by byte, so matches of 3 bytes are
+// accepted even if InputLen is larger.
+func (s *hashParser) MatchLen() int {
+	return min(s.inputLen, 3)
+}
+
 // Parse converts the next block to sequences. The contents of the blk variable
 // will be overwritten. The method returns the number of bytes sequenced and any
 // error encountered. It returns ErrEmptyBuffer if there is no further data
@@ -138,8 +384,7 @@ func (s *hashParser) Parse(blk *Block, flags int) (n int, err error) {
 
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -151,12 +396,8 @@ func (s *hashParser) Parse(blk *Block, flags int) (n int, err error) {
 	inputEnd := len(p) - s.inputLen + 1
 	i := s.W
 	litIndex := i
-	var minMatchLen int
-	if s.inputLen < 3 {
-		minMatchLen = s.inputLen
-	} else {
-		minMatchLen = 3
-	}
+	var hits, misses int64
+	minMatchLen := s.MatchLen()
 
 	// Ensure that we can use _getLE64 all the time.
 	_p := s.Data[:inputEnd+7]
@@ -172,8 +413,10 @@ func (s *hashParser) Parse(blk *Block, flags int) (n int, err error) {
 			value: v,
 		}
 		if v != entry.value {
+			misses++
 			continue
 		}
+		hits++
 		// potential match
 		j := int(entry.pos)
 		o := i - j
@@ -210,6 +453,9 @@ func (s *hashParser) Parse(blk *Block, flags int) (n int, err error) {
 			}
 		match:
 		}
+		if s.maxMatchLen > 0 && k > s.maxMatchLen {
+			k = s.maxMatchLen
+		}
 
 		q := p[litIndex:i]
 		blk.Sequences = append(blk.Sequences,
@@ -248,5 +494,46 @@ func (s *hashParser) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = i - s.W
 	s.W = i
+	s.seqHistograms.add(blk.Sequences)
+	s.hm.hits += hits
+	s.hm.misses += misses
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
+
+// MatchAt returns the match position and length for the position pos in the
+// buffer data without modifying the hash table. The match length is limited to
+// maxLen. If no match can be found (-1, 0) will be returned. The method can be
+// used by external parsers that want to drive the hash parser manually.
+//
+// Note that pos must not have been added to the hash table yet, which is the
+// case for all positions larger or equal to W.
+func (s *hashParser) MatchAt(pos int, maxLen int) (matchPos, matchLen int) {
+	if !(0 <= pos && pos <= len(s.Data)-s.inputLen) {
+		return -1, 0
+	}
+	if n := len(s.Data) - pos; maxLen > n {
+		maxLen = n
+	}
+	minMatchLen := s.MatchLen()
+	if maxLen < minMatchLen {
+		return -1, 0
+	}
+
+	// The data slice has a margin of 7 bytes.
+	_p := s.Data[:pos+8]
+	x := _getLE64(_p[pos:]) & s.mask
+	entry := s.table[hashValue(x, s.shift)]
+	if uint32(x) != entry.value {
+		return -1, 0
+	}
+	j := int(entry.pos)
+	if o := pos - j; !(0 < o && o <= s.WindowSize) {
+		return -1, 0
+	}
+	k := lcp(s.Data[j:], s.Data[pos:pos+maxLen])
+	if k < minMatchLen {
+		return -1, 0
+	}
+	return j, k
+}
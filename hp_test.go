@@ -8,12 +8,16 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestHashParserSimple(t *testing.T) {
@@ -370,3 +374,1104 @@ func TestHP_JSON(t *testing.T) {
 		t.Fatalf("ParseJSON returned %+v; want %+v", c, a)
 	}
 }
+
+func TestHashParserMatchAt(t *testing.T) {
+	const str = "=====foofoobarfoobar bartender===="
+
+	var s hashParser
+	err := s.init(HPConfig{
+		WindowSize: 1024,
+		BlockSize:  512,
+		InputLen:   3,
+	})
+	if err != nil {
+		t.Fatalf("s.init error %s", err)
+	}
+	if _, err = s.Write([]byte(str)); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+	var blk Block
+	if _, err = s.Parse(&blk, 0); err != nil {
+		t.Fatalf("s.Parse error %s", err)
+	}
+
+	const tail = "foobar bartender"
+	if _, err = s.Write([]byte(tail)); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+	pos := s.W
+	j, k := s.MatchAt(pos, len(tail))
+	if j < 0 {
+		t.Fatalf("s.MatchAt(%d, %d) found no match", pos, len(tail))
+	}
+	if k < 3 {
+		t.Fatalf("s.MatchAt(%d, %d) returned length %d; want >= 3",
+			pos, len(tail), k)
+	}
+	if !bytes.Equal(s.Data[j:j+k], s.Data[pos:pos+k]) {
+		t.Fatalf("s.MatchAt(%d, %d) returned %q; want %q",
+			pos, len(tail), s.Data[j:j+k], s.Data[pos:pos+k])
+	}
+	j2, k2 := s.MatchAt(pos, len(tail))
+	if j2 != j || k2 != k {
+		t.Fatalf("second s.MatchAt(%d, %d) returned (%d, %d); want (%d, %d)",
+			pos, len(tail), j2, k2, j, k)
+	}
+
+	if _, err = s.Parse(&blk, 0); err != nil {
+		t.Fatalf("s.Parse error %s", err)
+	}
+	if len(blk.Sequences) == 0 {
+		t.Fatalf("s.Parse returned no sequences")
+	}
+	seq := blk.Sequences[0]
+	if seq.LitLen != 0 || int(seq.Offset) != pos-j ||
+		int(seq.MatchLen) != k {
+		t.Fatalf("s.Parse returned %+v; want match length %d and"+
+			" offset %d", seq, k, pos-j)
+	}
+}
+
+func TestHashParserExtendWindow(t *testing.T) {
+	const str = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 16, BufferSize: 1024, InputLen: 3},
+		&DHPConfig{WindowSize: 16, BufferSize: 1024},
+	} {
+		s := newTestParser(t, cfg)
+		if _, err := s.Write([]byte(str)); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		if _, err := s.Parse(&blk, 0); err != nil {
+			t.Fatalf("s.Parse error %s", err)
+		}
+		x, ok := s.(interface{ ExtendWindow(extra int) error })
+		if !ok {
+			t.Fatalf("%T doesn't support ExtendWindow", s)
+		}
+		if err := x.ExtendWindow(2000); err == nil {
+			t.Fatalf("ExtendWindow(2000) succeeded; want error")
+		}
+		if err := x.ExtendWindow(48); err != nil {
+			t.Fatalf("ExtendWindow(48) error %s", err)
+		}
+		if _, err := s.Write([]byte(str)); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		blk2 := Block{}
+		if _, err := s.Parse(&blk2, 0); err != nil {
+			t.Fatalf("s.Parse error %s", err)
+		}
+		if len(blk2.Sequences) != 1 ||
+			blk2.Sequences[0].Offset != uint32(len(str)) {
+			t.Fatalf("%T: got sequences %+v; want single match"+
+				" with offset %d", s, blk2.Sequences, len(str))
+		}
+
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 64})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		for _, b := range []Block{blk, blk2} {
+			if _, _, _, err = d.WriteBlock(b); err != nil {
+				t.Fatalf("d.WriteBlock error %s", err)
+			}
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if g := buf.String(); g != str+str {
+			t.Fatalf("decoded %q; want %q", g, str+str)
+		}
+	}
+}
+
+func TestHashParserSetWindowSize(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:128*kiB]
+	const str = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	type windowSetter interface {
+		SetWindowSize(n int) error
+	}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB, BlockSize: 32 * kiB,
+			BufferSize: 256 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB, BlockSize: 32 * kiB,
+			BufferSize: 256 * kiB},
+	} {
+		s := newTestParser(t, cfg)
+		x, ok := s.(windowSetter)
+		if !ok {
+			t.Fatalf("%T doesn't support SetWindowSize", s)
+		}
+		if err = x.SetWindowSize(1); err == nil {
+			t.Fatalf("%T.SetWindowSize(1) succeeded; want error", s)
+		}
+		if err = x.SetWindowSize(512 * kiB); err == nil {
+			t.Fatalf("%T.SetWindowSize(512 KiB) succeeded;"+
+				" want error", s)
+		}
+		if _, err = s.Write(data); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		if _, err = s.Parse(&blk, 0); err != nil {
+			t.Fatalf("s.Parse error %s", err)
+		}
+
+		const smaller = kiB
+		if err = x.SetWindowSize(smaller); err != nil {
+			t.Fatalf("%T.SetWindowSize(%d) error %s", s, smaller,
+				err)
+		}
+		if g := s.BufferConfig().WindowSize; g != smaller {
+			t.Fatalf("WindowSize is %d; want %d", g, smaller)
+		}
+		for {
+			if _, err = s.Parse(&blk, 0); err != nil {
+				if err == ErrEmptyBuffer {
+					break
+				}
+				t.Fatalf("s.Parse error %s", err)
+			}
+			for _, seq := range blk.Sequences {
+				if seq.Offset > smaller {
+					t.Fatalf("%T: offset %d exceeds window"+
+						" size %d", s, seq.Offset,
+						smaller)
+				}
+			}
+		}
+
+		// The string is placed more than the smaller window size
+		// behind its repetition.
+		p := []byte(str)
+		p = append(p, data[:2*kiB]...)
+		p = append(p, str...)
+		if _, err = s.Write(p); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		const larger = 64 * kiB
+		if err = x.SetWindowSize(larger); err != nil {
+			t.Fatalf("%T.SetWindowSize(%d) error %s", s, larger,
+				err)
+		}
+		if _, err = s.Parse(&blk, 0); err != nil {
+			t.Fatalf("s.Parse error %s", err)
+		}
+		found := false
+		for _, seq := range blk.Sequences {
+			if seq.Offset > smaller {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("%T: no match beyond %d bytes found after"+
+				" SetWindowSize(%d)", s, smaller, larger)
+		}
+	}
+}
+
+func TestHashParserSetMaxMatchLen(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	// Append a repetition to ensure a match longer than 273 bytes.
+	data = append(data[:60*kiB:60*kiB], data[40*kiB:44*kiB]...)
+
+	type maxMatchLenSetter interface {
+		SetMaxMatchLen(n int) error
+	}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB},
+		&BHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB},
+		&THPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB},
+	} {
+		s := newTestParser(t, cfg)
+		x, ok := s.(maxMatchLenSetter)
+		if !ok {
+			t.Fatalf("%T doesn't support SetMaxMatchLen", s)
+		}
+		if err = x.SetMaxMatchLen(1); err == nil {
+			t.Fatalf("%T.SetMaxMatchLen(1) succeeded; want error", s)
+		}
+		for _, m := range []int{258, 273, 0} {
+			if err = x.SetMaxMatchLen(m); err != nil {
+				t.Fatalf("%T.SetMaxMatchLen(%d) error %s",
+					s, m, err)
+			}
+			if err = s.Reset(data); err != nil {
+				t.Fatalf("s.Reset error %s", err)
+			}
+			var blk Block
+			if _, err = Flush(s, &blk); err != nil {
+				t.Fatalf("s.Flush error %s", err)
+			}
+			longer := false
+			for _, seq := range blk.Sequences {
+				if m > 0 && seq.MatchLen > uint32(m) {
+					t.Fatalf("%T: match length %d exceeds"+
+						" %d", s, seq.MatchLen, m)
+				}
+				if seq.MatchLen > 273 {
+					longer = true
+				}
+			}
+			if m == 0 && !longer {
+				t.Fatalf("%T: no long match after"+
+					" SetMaxMatchLen(0)", s)
+			}
+			g, err := blk.Decompress(0)
+			if err != nil {
+				t.Fatalf("blk.Decompress(0) error %s", err)
+			}
+			if !bytes.Equal(g, data) {
+				t.Fatalf("%T: decompressed data differs for"+
+					" maxMatchLen %d", s, m)
+			}
+		}
+	}
+}
+
+func TestParserMatchLen(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+
+	type matchLener interface {
+		MatchLen() int
+	}
+	// For input lengths larger than 3 matches of length 3 are only found
+	// by hash collisions, so the shortest match observed might be longer.
+	tests := []struct {
+		cfg   ParserConfig
+		want  int
+		exact bool
+	}{
+		{&HPConfig{InputLen: 2, HashBits: 16}, 2, true},
+		{&HPConfig{InputLen: 3}, 3, true},
+		{&HPConfig{InputLen: 6}, 3, false},
+		{&BHPConfig{InputLen: 4}, 3, false},
+		{&DHPConfig{InputLen1: 2, HashBits1: 16, InputLen2: 6}, 2, true},
+		{&BDHPConfig{InputLen1: 3, InputLen2: 6}, 3, true},
+		{&THPConfig{}, 3, true},
+		{&BUPConfig{InputLen: 2, HashBits: 16}, 2, true},
+		{&BBUPConfig{InputLen: 5}, 3, false},
+		{&GSAPConfig{MinMatchLen: 4}, 4, true},
+		{&OSAPConfig{MinMatchLen: 5}, 5, true},
+	}
+	for _, tc := range tests {
+		tc.cfg.SetBufConfig(BufConfig{
+			WindowSize: 32 * kiB,
+			BlockSize:  16 * kiB,
+			BufferSize: 64 * kiB,
+		})
+		s := newTestParser(t, tc.cfg)
+		x, ok := s.(matchLener)
+		if !ok {
+			t.Fatalf("%T doesn't support MatchLen", s)
+		}
+		if g := x.MatchLen(); g != tc.want {
+			t.Fatalf("%T.MatchLen() returned %d; want %d",
+				s, g, tc.want)
+		}
+		blocks := parseAll(t, s, data)
+		shortest := 0
+		for _, blk := range blocks {
+			for _, seq := range blk.Sequences {
+				m := int(seq.MatchLen)
+				if m == 0 {
+					continue
+				}
+				if m < tc.want {
+					t.Fatalf("%T: match length %d < MatchLen %d",
+						s, m, tc.want)
+				}
+				if shortest == 0 || m < shortest {
+					shortest = m
+				}
+			}
+		}
+		if tc.exact && shortest != tc.want {
+			t.Errorf("%T: shortest match %d; want MatchLen %d",
+				s, shortest, tc.want)
+		}
+	}
+}
+
+// fnvHash adapts the FNV-1a hash to the signature of [DefaultHash].
+func fnvHash(x uint64, shift uint) uint32 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < 8; i++ {
+		h ^= x & 0xff
+		h *= prime
+		x >>= 8
+	}
+	return uint32(h >> shift)
+}
+
+func TestDoubleHashSetHashFunctions(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+
+	type hashSetter interface {
+		SetHashFunctions(h1, h2 func(x uint64, shift uint) uint32)
+	}
+	for _, cfg := range []ParserConfig{
+		&DHPConfig{WindowSize: 32 * kiB},
+		&BDHPConfig{WindowSize: 32 * kiB},
+		// replaces only the functions of the first two tables
+		&THPConfig{WindowSize: 32 * kiB},
+	} {
+		s := newTestParser(t, cfg)
+		hs, ok := s.(hashSetter)
+		if !ok {
+			t.Fatalf("%T doesn't support SetHashFunctions", s)
+		}
+		hs.SetHashFunctions(DefaultHash, DefaultHash)
+		want := parseAll(t, newTestParser(t, cfg), data)
+		if diff := cmp.Diff(parseAll(t, s, data), want); diff != "" {
+			t.Fatalf("%T: DefaultHash blocks differ:\n%s", s, diff)
+		}
+
+		s = newTestParser(t, cfg)
+		s.(hashSetter).SetHashFunctions(fnvHash, fnvHash)
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 32 * kiB})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		for _, blk := range parseAll(t, s, data) {
+			if _, _, _, err = d.WriteBlock(blk); err != nil {
+				t.Fatalf("d.WriteBlock error %s", err)
+			}
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%T: decoded data differs from input", s)
+		}
+	}
+}
+
+func TestParserCopyState(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+
+	type stateCopier interface {
+		Parser
+		CopyState(src Parser) error
+	}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&THPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+	} {
+		src := newTestParser(t, cfg)
+		if _, err = src.Write(data[:32*kiB]); err != nil {
+			t.Fatalf("src.Write error %s", err)
+		}
+		var blk Block
+		for i := 0; i < 2; i++ {
+			if _, err = src.Parse(&blk, 0); err != nil {
+				t.Fatalf("src.Parse error %s", err)
+			}
+		}
+
+		dst, ok := newTestParser(t, cfg).(stateCopier)
+		if !ok {
+			t.Fatalf("%T doesn't support CopyState", src)
+		}
+		if err = dst.CopyState(src); err != nil {
+			t.Fatalf("dst.CopyState error %s", err)
+		}
+		for _, s := range []Parser{src, dst} {
+			if _, err = s.Write(data[32*kiB:]); err != nil {
+				t.Fatalf("s.Write error %s", err)
+			}
+		}
+		for {
+			var blk1, blk2 Block
+			_, err1 := src.Parse(&blk1, 0)
+			_, err2 := dst.Parse(&blk2, 0)
+			if err1 != err2 {
+				t.Fatalf("%T: Parse errors %v and %v differ",
+					src, err1, err2)
+			}
+			if err1 == ErrEmptyBuffer {
+				break
+			}
+			if diff := cmp.Diff(blk1, blk2); diff != "" {
+				t.Fatalf("%T: blocks differ:\n%s", src, diff)
+			}
+		}
+
+		c := cfg.Clone()
+		bc := c.BufConfig()
+		bc.WindowSize = 16 * kiB
+		c.SetBufConfig(bc)
+		other := newTestParser(t, c).(stateCopier)
+		err = other.CopyState(src)
+		if !errors.Is(err, ErrIncompatibleConfig) {
+			t.Fatalf("%T: CopyState with different configuration"+
+				" returned %v; want %v", src, err,
+				ErrIncompatibleConfig)
+		}
+		err = dst.CopyState(newTestParser(t, &BHPConfig{}))
+		if !errors.Is(err, ErrIncompatibleConfig) {
+			t.Fatalf("%T: CopyState from other parser type"+
+				" returned %v; want %v", src, err,
+				ErrIncompatibleConfig)
+		}
+	}
+}
+
+func TestHashParserPreHash(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	dict := data[:64*kiB]
+	// The target shares half of its data with the dictionary.
+	target := data[32*kiB : 96*kiB]
+
+	cfg := &HPConfig{WindowSize: 128 * kiB, BlockSize: 16 * kiB,
+		BufferSize: 256 * kiB}
+	s := newTestParser(t, cfg)
+	x, ok := s.(interface{ PreHash(data []byte) error })
+	if !ok {
+		t.Fatalf("%T doesn't support PreHash", s)
+	}
+	if err = x.PreHash(dict); err != nil {
+		t.Fatalf("PreHash error %s", err)
+	}
+	if _, err = s.Write(target); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+
+	var b DecoderBuffer
+	err = b.Init(DecoderConfig{WindowSize: 128 * kiB,
+		BufferSize: 512 * kiB})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if err = b.PreloadDictionary(dict); err != nil {
+		t.Fatalf("b.PreloadDictionary error %s", err)
+	}
+	n := 0
+	dictRef := false
+	var blk Block
+	for {
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == ErrEmptyBuffer {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		p := n
+		for _, seq := range blk.Sequences {
+			p += int(seq.LitLen)
+			if int(seq.Offset) > p {
+				dictRef = true
+			}
+			p += int(seq.MatchLen)
+		}
+		n += int(blk.Len())
+		if _, _, _, err = b.WriteBlock(blk); err != nil {
+			t.Fatalf("b.WriteBlock error %s", err)
+		}
+	}
+	if n != len(target) {
+		t.Fatalf("parsed %d bytes; want %d", n, len(target))
+	}
+	if !dictRef {
+		t.Fatalf("no match references the dictionary")
+	}
+	g := make([]byte, len(target)+1)
+	k, _ := b.Read(g)
+	if !bytes.Equal(g[:k], target) {
+		t.Fatalf("decoded data differs from target")
+	}
+}
+
+func TestHPConfigBufferSizeLimit(t *testing.T) {
+	bs := int64(5) << 30
+	if int64(maxInt) < bs {
+		t.Skip("int cannot hold a buffer size of 5 GiB")
+	}
+	cfg := HPConfig{BufferSize: int(bs)}
+	cfg.SetDefaults()
+	if err := cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted BufferSize=%d; hash positions"+
+			" would overflow", bs)
+	}
+	cfg.BufferSize = maxUint32 - 7
+	if err := cfg.Verify(); err != nil {
+		t.Fatalf("Verify error %s for BufferSize=%d", err,
+			cfg.BufferSize)
+	}
+}
+
+func TestHashShiftOffsets(t *testing.T) {
+	var h hash
+	if err := h.init(4, 4); err != nil {
+		t.Fatalf("h.init error %s", err)
+	}
+	const delta = maxUint32 - 100
+	for i := range h.table {
+		h.table[i] = hashEntry{pos: delta - 8 + uint32(i), value: 1}
+	}
+	h.shiftOffsets(delta)
+	for i, e := range h.table {
+		if i < 8 {
+			if e != (hashEntry{}) {
+				t.Fatalf("entry %d is %+v; want cleared", i, e)
+			}
+			continue
+		}
+		if e.pos != uint32(i-8) {
+			t.Fatalf("entry %d has pos %d; want %d", i, e.pos, i-8)
+		}
+	}
+}
+
+// FuzzHashParserStalePositions verifies that hash entries with positions
+// near the end of the uint32 range don't produce invalid matches.
+func FuzzHashParserStalePositions(f *testing.F) {
+	f.Add(bytes.Repeat([]byte("abcd"), 50))
+	f.Add([]byte("The quick brown fox jumps over the lazy dog."))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg := HPConfig{WindowSize: 1024, InputLen: 3, HashBits: 8}
+		s := newTestParser(t, &cfg).(*hashParser)
+		for i := range s.table {
+			s.table[i].pos = math.MaxUint32 - 100 + uint32(i%200)
+		}
+		if _, err := s.Write(data); err != nil {
+			t.Skip()
+		}
+		var blk Block
+		if _, err := Flush(s, &blk); err != nil {
+			if len(data) == 0 && err == ErrEmptyBuffer {
+				return
+			}
+			t.Fatalf("s.Flush error %s", err)
+		}
+		g, err := blk.Decompress(0)
+		if err != nil {
+			t.Fatalf("blk.Decompress error %s", err)
+		}
+		if !bytes.Equal(g, data) {
+			t.Fatalf("decompressed data differs")
+		}
+	})
+}
+
+func TestParserSkip(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	const segLen = 4 * kiB
+	seg := data[:segLen]
+	data = append(seg[:segLen:segLen], seg...)
+
+	type skipper interface {
+		Parser
+		Skip(n int) (int, error)
+	}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB},
+		&BHPConfig{WindowSize: 32 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB},
+		&BDHPConfig{WindowSize: 32 * kiB},
+		&THPConfig{WindowSize: 32 * kiB},
+		&BUPConfig{WindowSize: 32 * kiB},
+		&BBUPConfig{WindowSize: 32 * kiB},
+	} {
+		s, ok := newTestParser(t, cfg).(skipper)
+		if !ok {
+			t.Fatalf("%T doesn't support Skip", cfg)
+		}
+		if _, err = s.Write(data); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		if n, err := s.Skip(0); n != 0 || err != nil {
+			t.Fatalf("%T.Skip(0) returned %d, %v; want 0, nil",
+				s, n, err)
+		}
+		if n, err := s.Skip(segLen); n != segLen || err != nil {
+			t.Fatalf("%T.Skip(%d) returned %d, %v; want %d, nil",
+				s, segLen, n, err, segLen)
+		}
+		var blk Block
+		if _, err = Flush(s, &blk); err != nil {
+			t.Fatalf("s.Flush error %s", err)
+		}
+		// The repetition of the skipped segment must be found.
+		var matched int64
+		for _, seq := range blk.Sequences {
+			if seq.Offset == segLen {
+				matched += int64(seq.MatchLen)
+			}
+		}
+		if matched < segLen/2 {
+			t.Fatalf("%T: only %d bytes matched in the skipped"+
+				" segment", s, matched)
+		}
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 32 * kiB})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		if _, err = d.Write(seg); err != nil {
+			t.Fatalf("d.Write error %s", err)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%T: decoded data differs", s)
+		}
+
+		if n, err := s.Skip(segLen); n != 0 || err != nil {
+			t.Fatalf("%T.Skip on empty buffer returned %d, %v;"+
+				" want 0, nil", s, n, err)
+		}
+		if _, err = s.Write(seg[:100]); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		if n, err := s.Skip(segLen); n != 100 || err != nil {
+			t.Fatalf("%T.Skip(%d) returned %d, %v; want 100, nil",
+				s, segLen, n, err)
+		}
+	}
+}
+
+func TestParserEvictOlderThan(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	const segLen = 4 * kiB
+	seg := data[:segLen]
+	// The second half repeats the first one at a distance of 4 KiB.
+	data = append(seg[:segLen:segLen], seg...)
+
+	type evicter interface {
+		Parser
+		Skip(n int) (int, error)
+		EvictOlderThan(maxOffset uint32)
+	}
+	const maxOffset = 1000
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB},
+		&THPConfig{WindowSize: 32 * kiB},
+	} {
+		s, ok := newTestParser(t, cfg).(evicter)
+		if !ok {
+			t.Fatalf("%T doesn't support EvictOlderThan", cfg)
+		}
+		if _, err = s.Write(data); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		if _, err = s.Skip(segLen); err != nil {
+			t.Fatalf("s.Skip error %s", err)
+		}
+		s.EvictOlderThan(maxOffset)
+		var blk Block
+		if _, err = Flush(s, &blk); err != nil {
+			t.Fatalf("s.Flush error %s", err)
+		}
+		// No match may reference data more than maxOffset bytes
+		// before the position of the eviction.
+		pos := int64(segLen)
+		for _, seq := range blk.Sequences {
+			pos += int64(seq.LitLen)
+			if pos-int64(seq.Offset) < segLen-maxOffset {
+				t.Fatalf("%T: sequence %+v at %d references"+
+					" evicted data", s, seq, pos)
+			}
+			pos += int64(seq.MatchLen)
+		}
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 32 * kiB})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		if _, err = d.Write(seg); err != nil {
+			t.Fatalf("d.Write error %s", err)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%T: decoded data differs", s)
+		}
+	}
+}
+
+func BenchmarkEvictOlderThan(b *testing.B) {
+	for _, hashBits := range []int{12, 16, 20} {
+		b.Run(fmt.Sprintf("HashBits-%d", hashBits), func(b *testing.B) {
+			cfg := HPConfig{WindowSize: 64 * kiB,
+				InputLen: 4, HashBits: hashBits}
+			s := newTestParser(b, &cfg).(*hashParser)
+			if _, err := s.Write(make([]byte, 64*kiB)); err != nil {
+				b.Fatalf("s.Write error %s", err)
+			}
+			if _, err := s.Skip(64 * kiB); err != nil {
+				b.Fatalf("s.Skip error %s", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.EvictOlderThan(1000)
+			}
+		})
+	}
+}
+
+func TestParserRollback(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+
+	type rollbacker interface {
+		Parser
+		Checkpoint() ParserCheckpoint
+		Rollback(cp ParserCheckpoint) error
+	}
+	parse := func(s Parser, blk *Block) {
+		t.Helper()
+		if _, err := s.Parse(blk, 0); err != nil {
+			t.Fatalf("%T.Parse error %s", s, err)
+		}
+	}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&BHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&BDHPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&THPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&BUPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&BBUPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&GSAPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&OSAPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+		&NPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+			BlockSize: 8 * kiB},
+	} {
+		s, ok := newTestParser(t, cfg).(rollbacker)
+		if !ok {
+			t.Fatalf("%T doesn't support Rollback", cfg)
+		}
+		control := newTestParser(t, cfg)
+		for _, p := range []Parser{s, control} {
+			if _, err = p.Write(data); err != nil {
+				t.Fatalf("%T.Write error %s", p, err)
+			}
+		}
+		var blk, want Block
+		parse(s, &blk)
+		parse(control, &want)
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 32 * kiB})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+
+		// A rollback without parsing in between changes nothing.
+		cp := s.Checkpoint()
+		if err = s.Rollback(cp); err != nil {
+			t.Fatalf("%T.Rollback error %s", s, err)
+		}
+		parse(s, &blk)
+		parse(control, &want)
+		if diff := cmp.Diff(want, blk); diff != "" {
+			t.Fatalf("%T: block after empty rollback differs:\n%s",
+				s, diff)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+
+		// Parse speculatively twice and keep the second block.
+		cp = s.Checkpoint()
+		parse(s, &blk)
+		parse(s, &blk)
+		ahead := s.Checkpoint()
+		if err = s.Rollback(cp); err != nil {
+			t.Fatalf("%T.Rollback error %s", s, err)
+		}
+		if g := s.Checkpoint(); g != cp {
+			t.Fatalf("%T: checkpoint after Rollback %+v; want %+v",
+				s, g, cp)
+		}
+		if err = s.Rollback(ahead); err == nil {
+			t.Fatalf("%T: Rollback to checkpoint ahead of the"+
+				" window head succeeded", s)
+		}
+		for {
+			if _, err = s.Parse(&blk, 0); err != nil {
+				if err == ErrEmptyBuffer {
+					break
+				}
+				t.Fatalf("%T.Parse error %s", s, err)
+			}
+			if _, _, _, err = d.WriteBlock(blk); err != nil {
+				t.Fatalf("d.WriteBlock error %s", err)
+			}
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%T: decoded data differs after Rollback", s)
+		}
+
+		// Checkpoints for discarded data are rejected.
+		cp = s.Checkpoint()
+		if err = s.Reset(data); err != nil {
+			t.Fatalf("%T.Reset error %s", s, err)
+		}
+		ahead = s.Checkpoint()
+		parse(s, &blk)
+		if err = s.Rollback(cp); err == nil {
+			t.Fatalf("%T: Rollback to checkpoint outside of the"+
+				" buffer succeeded", s)
+		}
+		if err = s.Rollback(ahead); err != nil {
+			t.Fatalf("%T.Rollback error %s", s, err)
+		}
+	}
+}
+
+func TestHashParserState(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+	const chunk = 8 * kiB
+	cfg := &HPConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+		BlockSize: chunk}
+
+	// parse writes the data in chunks into the parser and parses a block
+	// after every chunk.
+	parse := func(s *hashParser, data []byte) (blocks []Block) {
+		t.Helper()
+		for len(data) > 0 {
+			if s.Available() < chunk {
+				s.Shrink()
+			}
+			k, err := s.Write(data[:min(chunk, len(data))])
+			if err != nil {
+				t.Fatalf("s.Write error %s", err)
+			}
+			data = data[k:]
+			var blk Block
+			if _, err = s.Parse(&blk, 0); err != nil {
+				t.Fatalf("s.Parse error %s", err)
+			}
+			blocks = append(blocks, blk)
+		}
+		return blocks
+	}
+
+	const restart = 100 * kiB
+	a := newTestParser(t, cfg).(*hashParser)
+	blocks := parse(a, data[:restart])
+	state, err := a.MarshalState()
+	if err != nil {
+		t.Fatalf("a.MarshalState error %s", err)
+	}
+	maxSize := a.WindowSize + 8*len(a.table) + 64
+	if len(state) > maxSize {
+		t.Fatalf("len(state)=%d; want <= %d", len(state), maxSize)
+	}
+
+	b := newTestParser(t, cfg).(*hashParser)
+	if err = b.UnmarshalState(state); err != nil {
+		t.Fatalf("b.UnmarshalState error %s", err)
+	}
+	want := parse(a, data[restart:])
+	got := parse(b, data[restart:])
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("blocks after restart differ (-want +got):\n%s",
+			diff)
+	}
+
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: cfg.WindowSize})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	for _, blk := range append(blocks, got...) {
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+
+	c := newTestParser(t, &HPConfig{WindowSize: 16 * kiB,
+		BufferSize: 64 * kiB}).(*hashParser)
+	if err = c.UnmarshalState(state); !errors.Is(err,
+		ErrIncompatibleConfig) {
+		t.Fatalf("UnmarshalState with other WindowSize returned %v;"+
+			" want %v", err, ErrIncompatibleConfig)
+	}
+	if err = b.UnmarshalState(state[:len(state)-1]); !errors.Is(err,
+		ErrStateFormat) {
+		t.Fatalf("UnmarshalState of truncated state returned %v;"+
+			" want %v", err, ErrStateFormat)
+	}
+}
+
+// TestDoubleHashDictionaryValues checks that processSegment stores the values
+// masked to the input length of each table. Parse compares the masked value
+// of the current position with the stored value, so an unmasked value in the
+// first table can never be matched if its input length is less than 4.
+func TestDoubleHashDictionaryValues(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:16*kiB]
+	var f doubleHashDictionary
+	cfg := dhConfig{
+		H1: hashConfig{InputLen: 3, HashBits: 12},
+		H2: hashConfig{InputLen: 6, HashBits: 12},
+	}
+	bc := BufConfig{WindowSize: 32 * kiB, BufferSize: 32 * kiB}
+	if err = f.init(cfg, bc); err != nil {
+		t.Fatalf("f.init error %s", err)
+	}
+	if _, err = f.Write(data); err != nil {
+		t.Fatalf("f.Write error %s", err)
+	}
+	f.processSegment(0, len(data))
+	for _, h := range []*hash{&f.h1, &f.h2} {
+		for _, e := range h.table {
+			if e == (hashEntry{}) {
+				continue
+			}
+			x := _getLE64(f.Data[e.pos:e.pos+8]) & h.mask
+			if e.value != uint32(x) {
+				t.Fatalf("inputLen %d: value %#x at pos %d;"+
+					" want %#x", h.inputLen, e.value,
+					e.pos, x)
+			}
+		}
+	}
+}
+
+// BenchmarkDoubleHashFunctions compares the double hash parsers with the
+// default hash and with a hash function set by SetHashFunctions.
+func BenchmarkDoubleHashFunctions(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:4*miB]
+	type hashSetter interface {
+		SetHashFunctions(h1, h2 func(x uint64, shift uint) uint32)
+	}
+	hashes := []struct {
+		name string
+		h    func(x uint64, shift uint) uint32
+	}{
+		{"default", nil},
+		{"fnv", fnvHash},
+	}
+	for _, cfg := range []ParserConfig{
+		&DHPConfig{WindowSize: 8 * miB, BufferSize: 8 * miB},
+		&BDHPConfig{WindowSize: 8 * miB, BufferSize: 8 * miB},
+	} {
+		for _, hf := range hashes {
+			name := parserName(cfg) + "-" + hf.name
+			b.Run(name, func(b *testing.B) {
+				s := newTestParser(b, cfg)
+				s.(hashSetter).SetHashFunctions(hf.h, hf.h)
+				b.SetBytes(int64(len(data)))
+				var blk Block
+				for i := 0; i < b.N; i++ {
+					if err := s.Reset(data); err != nil {
+						b.Fatalf("s.Reset error %s", err)
+					}
+					for {
+						_, err := s.Parse(&blk, 0)
+						if err == ErrEmptyBuffer {
+							break
+						}
+						if err != nil {
+							b.Fatalf("s.Parse error %s",
+								err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -37,6 +37,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"slices"
 )
 
 // Kilobytes and Megabyte defined as the more precise kibibyte and mebibyte.
@@ -61,6 +62,12 @@ func (s Seq) Len() int64 {
 	return int64(s.MatchLen) + int64(s.LitLen)
 }
 
+// String returns a short description of the sequence in the form L3M5@12,
+// where 3 is the number of literals, 5 the match length and 12 the offset.
+func (s Seq) String() string {
+	return fmt.Sprintf("L%dM%d@%d", s.LitLen, s.MatchLen, s.Offset)
+}
+
 // Block stores sequences and literals. Note that the sequences stores in the
 // Sequences slice might not consume the whole Literals slice. They must be
 // added to the decoded text after all the sequences have been decoded and their
@@ -81,6 +88,616 @@ func (b *Block) Len() int64 {
 	return n
 }
 
+// Reset clears the block but keeps the capacity of the slices for reuse.
+func (b *Block) Reset() {
+	b.Sequences = b.Sequences[:0]
+	b.Literals = b.Literals[:0]
+}
+
+// AppendSeq appends the sequence and its literals to the block. The length of
+// lit must equal seq.LitLen and a match must have a non-zero offset;
+// otherwise an error is returned and the block is not modified.
+func (b *Block) AppendSeq(seq Seq, lit []byte) error {
+	if int64(len(lit)) != int64(seq.LitLen) {
+		return fmt.Errorf("%w: len(lit)=%d differs from LitLen=%d",
+			errLitLen, len(lit), seq.LitLen)
+	}
+	if seq.MatchLen > 0 && seq.Offset == 0 {
+		return fmt.Errorf("%w: match with offset 0", errOffset)
+	}
+	b.Sequences = append(b.Sequences, seq)
+	b.Literals = append(b.Literals, lit...)
+	return nil
+}
+
+// AppendLiterals appends trailing literals, which are not part of a sequence,
+// to the block. It should only be called after the last sequence has been
+// appended.
+func (b *Block) AppendLiterals(lit []byte) {
+	b.Literals = append(b.Literals, lit...)
+}
+
+// Defragment adds the literals of sequences without a match to the literal
+// length of the following sequence and removes the sequences without a match.
+// Literals of such sequences at the end of the block become trailing
+// literals. The decoded content of the block is not changed.
+func (b *Block) Defragment() {
+	seqs := b.Sequences[:0]
+	var lits uint32
+	for _, s := range b.Sequences {
+		if s.MatchLen == 0 {
+			lits += s.LitLen
+			continue
+		}
+		s.LitLen += lits
+		lits = 0
+		seqs = append(seqs, s)
+	}
+	b.Sequences = seqs
+}
+
+// Clone returns a deep copy of the block. The copy doesn't share memory with
+// the original.
+func (b *Block) Clone() *Block {
+	return &Block{
+		Sequences: slices.Clone(b.Sequences),
+		Literals:  slices.Clone(b.Literals),
+	}
+}
+
+// TrimToBytes splits the block into a head block covering exactly n bytes and
+// a tail block with the rest. A match crossing the split position is split
+// into two matches with the same offset; the tail must be decoded after the
+// head. The blocks don't share memory with the original block.
+func (b *Block) TrimToBytes(n int64) (head, tail *Block, err error) {
+	if !(0 <= n && n <= b.Len()) {
+		return nil, nil, fmt.Errorf(
+			"lz: n=%d out of range [0..%d]", n, b.Len())
+	}
+	var p, li int64
+	for i, s := range b.Sequences {
+		if n <= p+int64(s.LitLen) {
+			// The split position is in the literal run.
+			k := n - p
+			s.LitLen -= uint32(k)
+			head = &Block{
+				Sequences: slices.Clone(b.Sequences[:i]),
+				Literals:  slices.Clone(b.Literals[:li+k]),
+			}
+			tail = &Block{
+				Sequences: append([]Seq{s},
+					b.Sequences[i+1:]...),
+				Literals: slices.Clone(b.Literals[li+k:]),
+			}
+			return head, tail, nil
+		}
+		li += int64(s.LitLen)
+		if n < p+s.Len() {
+			// The split position is inside the match.
+			k := uint32(n - p - int64(s.LitLen))
+			hs := s
+			hs.MatchLen = k
+			head = &Block{
+				Sequences: append(slices.Clone(b.Sequences[:i]),
+					hs),
+				Literals: slices.Clone(b.Literals[:li]),
+			}
+			s.LitLen = 0
+			s.MatchLen -= k
+			tail = &Block{
+				Sequences: append([]Seq{s},
+					b.Sequences[i+1:]...),
+				Literals: slices.Clone(b.Literals[li:]),
+			}
+			return head, tail, nil
+		}
+		p += s.Len()
+	}
+	// The split position is in the trailing literals.
+	k := n - p
+	head = &Block{
+		Sequences: slices.Clone(b.Sequences),
+		Literals:  slices.Clone(b.Literals[:li+k]),
+	}
+	tail = &Block{Literals: slices.Clone(b.Literals[li+k:])}
+	return head, tail, nil
+}
+
+// LiteralRuns returns the literal lengths of all sequences in the block. The
+// last element of the slice contains the number of trailing literals.
+func (b *Block) LiteralRuns() []int {
+	runs := make([]int, len(b.Sequences)+1)
+	n := len(b.Literals)
+	for i, s := range b.Sequences {
+		runs[i] = int(s.LitLen)
+		n -= int(s.LitLen)
+	}
+	runs[len(b.Sequences)] = n
+	return runs
+}
+
+// MaxLiteralRun returns the maximum value of the slice returned by
+// [Block.LiteralRuns].
+func (b *Block) MaxLiteralRun() int {
+	m := len(b.Literals)
+	for _, s := range b.Sequences {
+		m -= int(s.LitLen)
+	}
+	for _, s := range b.Sequences {
+		if int(s.LitLen) > m {
+			m = int(s.LitLen)
+		}
+	}
+	return m
+}
+
+// MatchLens returns the match lengths of all sequences in the block.
+func (b *Block) MatchLens() []uint32 {
+	lens := make([]uint32, len(b.Sequences))
+	for i, s := range b.Sequences {
+		lens[i] = s.MatchLen
+	}
+	return lens
+}
+
+// CountMatches returns the number of sequences with a match. Sequences that
+// carry only literals are not counted.
+func (b *Block) CountMatches() int {
+	n := 0
+	for _, s := range b.Sequences {
+		if s.MatchLen > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// CountLiterals returns the number of literal bytes in the block.
+func (b *Block) CountLiterals() int64 {
+	return int64(len(b.Literals))
+}
+
+// MaxMatchOffset returns the maximum offset of all sequences in the block.
+func (b *Block) MaxMatchOffset() uint32 {
+	var m uint32
+	for _, s := range b.Sequences {
+		if s.Offset > m {
+			m = s.Offset
+		}
+	}
+	return m
+}
+
+// AverageMatchOffset returns the arithmetic mean of the offsets of the
+// matches in the block. Sequences without a match are ignored. If the block
+// has no matches 0 is returned.
+func (b *Block) AverageMatchOffset() float64 {
+	var sum, n int64
+	for _, s := range b.Sequences {
+		if s.MatchLen == 0 {
+			continue
+		}
+		sum += int64(s.Offset)
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+// OffsetHistogram sets buckets[k] to the number of matches with offsets from
+// 2^k to 2^(k+1)-1. The function panics if buckets has less than 32 elements.
+func (b *Block) OffsetHistogram(buckets []int64) {
+	if len(buckets) < 32 {
+		panic(fmt.Errorf("lz: len(buckets)=%d must be at least 32",
+			len(buckets)))
+	}
+	clear(buckets)
+	for _, s := range b.Sequences {
+		if s.MatchLen == 0 || s.Offset == 0 {
+			continue
+		}
+		buckets[log2Bucket(s.Offset, 32)]++
+	}
+}
+
+// Audit checks the block completely before it is decoded. It simulates the
+// growth of the window from the start of the block, whose size is limited by
+// maxOffset, and verifies that all match offsets are inside the window. It
+// also checks that matches have a non-zero offset and that the literal
+// lengths don't exceed the literals in the block. So the block must not
+// reference data preceding it.
+func (b *Block) Audit(maxOffset uint32) error {
+	var w, lits int64
+	for i, s := range b.Sequences {
+		lits += int64(s.LitLen)
+		if lits > int64(len(b.Literals)) {
+			return fmt.Errorf(
+				"%w: sequence %d exceeds the %d literals",
+				errLitLen, i, len(b.Literals))
+		}
+		w += int64(s.LitLen)
+		if s.MatchLen == 0 {
+			continue
+		}
+		if s.Offset == 0 {
+			return fmt.Errorf("%w: sequence %d has offset 0",
+				errOffset, i)
+		}
+		winLen := w
+		if winLen > int64(maxOffset) {
+			winLen = int64(maxOffset)
+		}
+		if int64(s.Offset) > winLen {
+			return fmt.Errorf(
+				"%w: sequence %d has offset %d outside of window"+
+					" with size %d",
+				errOffset, i, s.Offset, winLen)
+		}
+		w += int64(s.MatchLen)
+	}
+	return nil
+}
+
+// CostFunc gives the cost of a match with length m and offset o. The offset
+// zero requests the cost of m literals. [XZCost] is an example.
+type CostFunc = func(m, o uint32) uint64
+
+// Cost returns the cost of the block computed by the cost function. The
+// cost of each match is costFn(MatchLen, Offset) and the cost of all literals
+// is costFn(len(Literals), 0). A block without sequences costs only its
+// literals. The function [XZCost] can be used. Cost allows the evaluation of
+// a block without running a parser.
+func (b *Block) Cost(costFn CostFunc) int64 {
+	var c int64
+	for _, s := range b.Sequences {
+		if s.MatchLen > 0 {
+			c += int64(costFn(s.MatchLen, s.Offset))
+		}
+	}
+	return c + int64(costFn(uint32(len(b.Literals)), 0))
+}
+
+// optMaxLen limits the length of the matches tried for offsets of preceding
+// matches in [Block.Optimize].
+const optMaxLen = 273
+
+// optRecentOffsets is the number of offsets of preceding matches that
+// [Block.Optimize] tries at every position.
+const optRecentOffsets = 4
+
+// Optimize recombines the matches of the block using the shortest path
+// search of the optimizing suffix array parser. At every position the
+// suffixes of the match covering it and the offsets of the preceding matches
+// are considered, but no new offsets are searched. Matches are never shorter
+// than the shortest match of the block. Bytes copied from data preceding the
+// block are unknown, so they can only be covered by the original matches.
+//
+// The cost of literals is assumed to be costFn(1, 0) per byte. If the result
+// isn't cheaper than the block according to [Block.Cost], a copy of the
+// block is returned. The returned block decodes to the same data and
+// doesn't share memory with b.
+func (b *Block) Optimize(costFn CostFunc) (*Block, error) {
+	// Decode the block as far as possible and record the original
+	// matches for every position.
+	n64 := b.Len()
+	if n64 > maxInt {
+		return nil, fmt.Errorf("lz: block length %d too large", n64)
+	}
+	n := int(n64)
+	data := make([]byte, n)
+	known := make([]bool, n)
+	type match struct {
+		end int
+		o   uint32
+	}
+	cover := make([]match, n)
+	minLen := uint32(0)
+	lits := b.Literals
+	i := 0
+	for k, s := range b.Sequences {
+		if int64(s.LitLen) > int64(len(lits)) {
+			return nil, fmt.Errorf(
+				"%w: sequence %d exceeds the %d literals",
+				errLitLen, k, len(b.Literals))
+		}
+		i += copy(data[i:], lits[:s.LitLen])
+		for j := i - int(s.LitLen); j < i; j++ {
+			known[j] = true
+		}
+		lits = lits[s.LitLen:]
+		if s.MatchLen == 0 {
+			continue
+		}
+		if s.Offset == 0 {
+			return nil, fmt.Errorf("%w: sequence %d has offset 0",
+				errOffset, k)
+		}
+		if minLen == 0 || s.MatchLen < minLen {
+			minLen = s.MatchLen
+		}
+		end := i + int(s.MatchLen)
+		for ; i < end; i++ {
+			cover[i] = match{end: end, o: s.Offset}
+			if j := i - int(s.Offset); j >= 0 && known[j] {
+				data[i] = data[j]
+				known[i] = true
+			}
+		}
+	}
+	copy(data[i:], lits)
+	for ; i < n; i++ {
+		known[i] = true
+	}
+	if minLen == 0 {
+		// Without matches there is nothing to optimize.
+		return b.Clone(), nil
+	}
+
+	type opt struct {
+		m, o uint32
+		c    uint64
+	}
+	const inf = ^uint64(0)
+	d := make([]opt, n+1)
+	for i := 1; i <= n; i++ {
+		d[i].c = inf
+	}
+	litCost := costFn(1, 0)
+	relax := func(i, maxLen int, o uint32) {
+		ci := d[i].c
+		for m := int(minLen); m <= maxLen; m++ {
+			c := ci + costFn(uint32(m), o)
+			if c < d[i+m].c {
+				d[i+m] = opt{m: uint32(m), o: o, c: c}
+			}
+			if m >= optMaxLen && m < maxLen {
+				// Try the full length only.
+				m = maxLen - 1
+			}
+		}
+	}
+	var recent [optRecentOffsets]uint32
+	for i := 0; i < n; i++ {
+		if d[i].c == inf {
+			continue
+		}
+		if known[i] {
+			if c := d[i].c + litCost; c < d[i+1].c {
+				d[i+1] = opt{m: 1, o: 0, c: c}
+			}
+		}
+		cv := cover[i]
+		if cv.o != 0 {
+			relax(i, cv.end-i, cv.o)
+		}
+		for _, o := range recent {
+			if o == 0 || o == cv.o || int(o) > i {
+				continue
+			}
+			k := 0
+			for i+k < n && k < optMaxLen {
+				j := i + k
+				if !(known[j] && known[j-int(o)] &&
+					data[j] == data[j-int(o)]) {
+					break
+				}
+				k++
+			}
+			relax(i, k, o)
+		}
+		// Record the offset of a match starting at i.
+		if cv.o != 0 && (i == 0 || cover[i-1] != cv) {
+			if recent[0] != cv.o {
+				copy(recent[1:], recent[:])
+				recent[0] = cv.o
+			}
+		}
+	}
+
+	// Collect the path backwards and build the block.
+	var path []opt
+	for i := n; i > 0; i -= int(d[i].m) {
+		path = append(path, d[i])
+	}
+	r := &Block{}
+	litIndex, i := 0, 0
+	for k := len(path) - 1; k >= 0; k-- {
+		e := path[k]
+		if e.o == 0 {
+			i++
+			continue
+		}
+		r.Sequences = append(r.Sequences, Seq{
+			LitLen:   uint32(i - litIndex),
+			MatchLen: e.m,
+			Offset:   e.o,
+		})
+		r.Literals = append(r.Literals, data[litIndex:i]...)
+		i += int(e.m)
+		litIndex = i
+	}
+	r.Literals = append(r.Literals, data[litIndex:]...)
+	if r.Cost(costFn) >= b.Cost(costFn) {
+		return b.Clone(), nil
+	}
+	return r, nil
+}
+
+// seqJSON is the JSON representation of a sequence.
+type seqJSON struct {
+	LitLen   uint32 `json:"lit_len"`
+	MatchLen uint32 `json:"match_len"`
+	Offset   uint32 `json:"offset"`
+	Aux      uint32 `json:"aux,omitempty"`
+}
+
+// blockJSON is the JSON representation of a block. The literals are encoded
+// using standard base64 encoding.
+type blockJSON struct {
+	Sequences []seqJSON `json:"sequences"`
+	Literals  []byte    `json:"literals"`
+}
+
+// MarshalJSON encodes the block as JSON object with the properties sequences
+// and literals. The literals are encoded as base64 string. The
+// representation is intended for debugging.
+func (b *Block) MarshalJSON() ([]byte, error) {
+	v := blockJSON{
+		Sequences: make([]seqJSON, len(b.Sequences)),
+		Literals:  b.Literals,
+	}
+	for i, s := range b.Sequences {
+		v.Sequences[i] = seqJSON(s)
+	}
+	if v.Literals == nil {
+		v.Literals = []byte{}
+	}
+	return json.Marshal(&v)
+}
+
+// UnmarshalJSON decodes the JSON representation created by
+// [Block.MarshalJSON]. The memory of the block slices is reused.
+func (b *Block) UnmarshalJSON(p []byte) error {
+	var v blockJSON
+	if err := json.Unmarshal(p, &v); err != nil {
+		return err
+	}
+	b.Reset()
+	for _, s := range v.Sequences {
+		b.Sequences = append(b.Sequences, Seq(s))
+	}
+	b.Literals = append(b.Literals, v.Literals...)
+	return nil
+}
+
+// String returns the JSON representation of the block.
+func (b *Block) String() string {
+	p, err := b.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("lz.Block: %s", err)
+	}
+	return string(p)
+}
+
+// Format implements the [fmt.Formatter] interface. The verb %v writes one
+// sequence per line using [Seq.String] followed by a line for the trailing
+// literals. The flag + adds the literal bytes of each line in hexadecimal and
+// the flag # adds the Aux field of the sequences too. The verb %s writes the
+// JSON representation returned by [Block.String].
+func (b *Block) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 's':
+		io.WriteString(f, b.String())
+		return
+	case 'v':
+	default:
+		fmt.Fprintf(f, "%%!%c(*lz.Block)", verb)
+		return
+	}
+	if b == nil {
+		io.WriteString(f, "<nil>")
+		return
+	}
+	sharp := f.Flag('#')
+	plus := f.Flag('+') || sharp
+	var text []byte
+	lits := b.Literals
+	for i, s := range b.Sequences {
+		if i > 0 {
+			text = append(text, '\n')
+		}
+		text = append(text, s.String()...)
+		if sharp {
+			text = fmt.Appendf(text, " aux=%d", s.Aux)
+		}
+		k := min(int(s.LitLen), len(lits))
+		if plus && k > 0 {
+			text = fmt.Appendf(text, " %x", lits[:k])
+		}
+		lits = lits[k:]
+	}
+	if len(lits) > 0 {
+		if len(b.Sequences) > 0 {
+			text = append(text, '\n')
+		}
+		text = fmt.Appendf(text, "L%d", len(lits))
+		if plus {
+			text = fmt.Appendf(text, " %x", lits)
+		}
+	}
+	f.Write(text)
+}
+
+// appendTextBytes appends the bytes to the text. Printable ASCII characters
+// are kept, all other bytes are written as \xNN.
+func appendTextBytes(text, p []byte) []byte {
+	const hex = "0123456789abcdef"
+	for _, c := range p {
+		if 0x20 <= c && c <= 0x7e {
+			text = append(text, c)
+			continue
+		}
+		text = append(text, '\\', 'x', hex[c>>4], hex[c&0xf])
+	}
+	return text
+}
+
+// WriteText writes a human-readable description of the block to w. Each
+// sequence is written in a line of the form
+//
+//	[i] lit=l match=m offset=o <literals><copy m bytes from -o>
+//
+// followed by a final line for the trailing literals. Printable ASCII
+// characters are written as they are, all other literal bytes as \xNN.
+func (b *Block) WriteText(w io.Writer) error {
+	return b.WriteTextWithSource(w, nil)
+}
+
+// WriteTextWithSource writes the block like [Block.WriteText]. If source is
+// not nil, it must contain the data encoded by the block and the bytes
+// copied by each match are written after the match section.
+func (b *Block) WriteTextWithSource(w io.Writer, source []byte) error {
+	var text []byte
+	var lits, pos int64
+	for i, s := range b.Sequences {
+		if lits+int64(s.LitLen) > int64(len(b.Literals)) {
+			return fmt.Errorf(
+				"%w: sequence %d exceeds the %d literals",
+				errLitLen, i, len(b.Literals))
+		}
+		text = fmt.Appendf(text, "[%d] lit=%d match=%d offset=%d ",
+			i, s.LitLen, s.MatchLen, s.Offset)
+		q := b.Literals[lits : lits+int64(s.LitLen)]
+		text = appendTextBytes(text, q)
+		lits += int64(s.LitLen)
+		pos += int64(s.LitLen)
+		text = fmt.Appendf(text, "<copy %d bytes from -%d>",
+			s.MatchLen, s.Offset)
+		if source != nil {
+			end := pos + int64(s.MatchLen)
+			if end > int64(len(source)) {
+				return fmt.Errorf(
+					"lz: source too short for sequence %d", i)
+			}
+			text = append(text, " = "...)
+			text = appendTextBytes(text, source[pos:end])
+		}
+		pos += int64(s.MatchLen)
+		text = append(text, '\n')
+	}
+	q := b.Literals[lits:]
+	text = fmt.Appendf(text, "[trailing] lit=%d", len(q))
+	if len(q) > 0 {
+		text = append(text, ' ')
+		text = appendTextBytes(text, q)
+	}
+	text = append(text, '\n')
+	_, err := w.Write(text)
+	return err
+}
+
 // Flags for the sequence function stored in the block structure.
 const (
 	// NoTrailingLiterals tells a parser that trailing literals don't
@@ -96,6 +713,11 @@ var ErrEmptyBuffer = errors.New("lz: no more data in buffer")
 // Write and ReadFrom methods of the [Parser].
 var ErrFullBuffer = errors.New("lz: buffer is full")
 
+// ErrIncompatibleConfig indicates that the configurations of two parsers
+// don't match for an operation that requires them to be the same.
+var ErrIncompatibleConfig = errors.New(
+	"lz: incompatible configuration for reinitialization")
+
 // Parser provides the basic interface of a Parser. Most of the functions are
 // provided by the underlying [ParserBuffer].
 type Parser interface {
@@ -110,9 +732,48 @@ type Parser interface {
 	ByteAt(off int64) (c byte, err error)
 }
 
+// Flush parses all remaining data of the parser and combines the blocks into
+// the single block blk, ignoring the block size. The trailing literals of a
+// block are added to the literal length of the first sequence of the next
+// block. It returns [ErrEmptyBuffer] if no data is left.
+func Flush(s Parser, blk *Block) (n int, err error) {
+	blk.Reset()
+	var (
+		b Block
+		// number of trailing literals in blk
+		trailing uint32
+	)
+	for {
+		k, err := s.Parse(&b, 0)
+		if err != nil {
+			if err == ErrEmptyBuffer && n > 0 {
+				return n, nil
+			}
+			return n, err
+		}
+		n += k
+		litLen := uint32(len(b.Literals))
+		for i, seq := range b.Sequences {
+			litLen -= seq.LitLen
+			if i == 0 {
+				seq.LitLen += trailing
+				trailing = 0
+			}
+			blk.Sequences = append(blk.Sequences, seq)
+		}
+		trailing += litLen
+		blk.Literals = append(blk.Literals, b.Literals...)
+	}
+}
+
 // ParserConfig generates  new parser instances. Note that the parser doesn't
 // use ShrinkSize and BufferSize directly but we added it here, so it can be
 // used for the WriteParser which provides a WriteCloser interface.
+//
+// The Clone method returns an independent copy of the configuration. All
+// configuration types provided by the package contain only value fields, so a
+// copy of the structure is sufficient and clones can be used by different
+// goroutines.
 type ParserConfig interface {
 	NewParser() (s Parser, err error)
 	BufConfig() BufConfig
@@ -169,7 +830,8 @@ func setBufferConfig(x ParserConfig, bc BufConfig) {
 }
 
 // parserConfigUnion must contain all fields for all parsers. Fields with the
-// same name must have the same type.
+// same name must have the same type. Fields of the parser configurations
+// tagged with `json:"-"` are not part of the union.
 type parserConfigUnion struct {
 	Type        string
 	ShrinkSize  int    `json:",omitempty"`
@@ -182,10 +844,20 @@ type parserConfigUnion struct {
 	HashBits1   int    `json:",omitempty"`
 	InputLen2   int    `json:",omitempty"`
 	HashBits2   int    `json:",omitempty"`
+	InputLen3   int    `json:",omitempty"`
+	HashBits3   int    `json:",omitempty"`
 	MinMatchLen int    `json:",omitempty"`
 	MaxMatchLen int    `json:",omitempty"`
 	BucketSize  int    `json:",omitempty"`
 	Cost        string `json:",omitempty"`
+	CacheEdges  bool   `json:",omitempty"`
+	StartOffset int    `json:",omitempty"`
+
+	BackwardMatchMax int `json:",omitempty"`
+
+	WindowFraction float64 `json:",omitempty"`
+
+	Workers int `json:",omitempty"`
 }
 
 func unmarshalJSON(cfg ParserConfig, typ string, p []byte) error {
@@ -203,8 +875,11 @@ func unmarshalJSON(cfg ParserConfig, typ string, p []byte) error {
 	w := reflect.ValueOf(s)
 	n := v.NumField()
 	for i := 0; i < n; i++ {
-		name := vt.Field(i).Name
-		y := w.FieldByName(name)
+		f := vt.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		y := w.FieldByName(f.Name)
 		v.Field(i).Set(y)
 	}
 	return nil
@@ -218,8 +893,11 @@ func marshalJSON(cfg ParserConfig, typ string) (p []byte, err error) {
 	w := reflect.Indirect(reflect.ValueOf(&s))
 	n := v.NumField()
 	for i := 0; i < n; i++ {
-		name := vt.Field(i).Name
-		y := w.FieldByName(name)
+		f := vt.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		y := w.FieldByName(f.Name)
 		y.Set(v.Field(i))
 	}
 	p, err = json.Marshal(&s)
@@ -256,6 +934,35 @@ func (cfg *BufConfig) Verify() error {
 	return nil
 }
 
+// VerifyStrict checks the buffer configuration like [BufConfig.Verify] but
+// returns additionally advisory warnings for parameter combinations that are
+// valid but likely suboptimal. Warnings are only computed if the configuration
+// is valid.
+func (cfg *BufConfig) VerifyStrict() (warnings []string, err error) {
+	if err = cfg.Verify(); err != nil {
+		return nil, err
+	}
+	if cfg.ShrinkSize > cfg.WindowSize/2 {
+		warnings = append(warnings, fmt.Sprintf(
+			"ShrinkSize (%d) > WindowSize/2 (%d):"+
+				" may reduce compression ratio",
+			cfg.ShrinkSize, cfg.WindowSize/2))
+	}
+	if cfg.BlockSize > cfg.WindowSize {
+		warnings = append(warnings, fmt.Sprintf(
+			"BlockSize (%d) > WindowSize (%d):"+
+				" unusual and likely a misconfiguration",
+			cfg.BlockSize, cfg.WindowSize))
+	}
+	if int64(cfg.BufferSize) < 2*int64(cfg.WindowSize) {
+		warnings = append(warnings, fmt.Sprintf(
+			"BufferSize (%d) < 2*WindowSize (%d):"+
+				" may cause unnecessary thrashing",
+			cfg.BufferSize, 2*int64(cfg.WindowSize)))
+	}
+	return warnings, nil
+}
+
 // SetDefaults sets the defaults for the various size values. The defaults are
 // given below.
 //
@@ -290,6 +997,12 @@ func ParseJSON(p []byte) (s ParserConfig, err error) {
 	}
 
 	switch v.Type {
+	case "NP":
+		var npCfg NPConfig
+		if err = json.Unmarshal(p, &npCfg); err != nil {
+			return nil, err
+		}
+		return &npCfg, nil
 	case "HP":
 		var hpCfg HPConfig
 		if err = json.Unmarshal(p, &hpCfg); err != nil {
@@ -314,12 +1027,24 @@ func ParseJSON(p []byte) (s ParserConfig, err error) {
 			return nil, err
 		}
 		return &bdhpCfg, nil
+	case "THP":
+		var thpCfg THPConfig
+		if err = json.Unmarshal(p, &thpCfg); err != nil {
+			return nil, err
+		}
+		return &thpCfg, nil
 	case "BUP":
 		var buhpCfg BUPConfig
 		if err = json.Unmarshal(p, &buhpCfg); err != nil {
 			return nil, err
 		}
 		return &buhpCfg, nil
+	case "BBUP":
+		var bbupCfg BBUPConfig
+		if err = json.Unmarshal(p, &bbupCfg); err != nil {
+			return nil, err
+		}
+		return &bbupCfg, nil
 	case "GSAP":
 		var gsapCfg GSAPConfig
 		if err = json.Unmarshal(p, &gsapCfg); err != nil {
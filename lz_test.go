@@ -7,10 +7,17 @@ package lz
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"math/bits"
+	"math/rand"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -57,6 +64,59 @@ func testParser(t *testing.T, cfg ParserConfig, p []byte) {
 	if diff := cmp.Diff(p, q, cmpopts.EquateEmpty()); diff != "" {
 		t.Fatalf("decoded mismatch (+got -want):\n%s", diff)
 	}
+
+	// Parse a single block and flush the remaining data.
+	if err = seq.Reset(nil); err != nil {
+		t.Fatalf("seq.Reset error %s", err)
+	}
+	p = p[:min(len(p), bcfg.BufferSize)]
+	if _, err = seq.Write(p); err != nil {
+		t.Fatalf("seq.Write error %s", err)
+	}
+	buffer.Reset()
+	decoder.Reset(&buffer)
+	var blocks []Block
+	if _, err = seq.Parse(&blk, 0); err != nil {
+		if err != ErrEmptyBuffer {
+			t.Fatalf("seq.Parse error %s", err)
+		}
+	} else {
+		blocks = append(blocks, blk)
+	}
+	var fblk Block
+	n, err := Flush(seq, &fblk)
+	if err != nil {
+		if err != ErrEmptyBuffer {
+			t.Fatalf("seq.Flush error %s", err)
+		}
+	} else {
+		if int64(n) != fblk.Len() {
+			t.Fatalf("seq.Flush returned %d; want %d", n,
+				fblk.Len())
+		}
+		blocks = append(blocks, fblk)
+	}
+	if _, err = seq.Parse(&blk, 0); err != ErrEmptyBuffer {
+		t.Fatalf("seq.Parse after Flush returned %v; want %v",
+			err, ErrEmptyBuffer)
+	}
+	if _, err = Flush(seq, &blk); err != ErrEmptyBuffer {
+		t.Fatalf("seq.Flush after Flush returned %v; want %v",
+			err, ErrEmptyBuffer)
+	}
+	for _, b := range blocks {
+		if _, _, _, err := decoder.WriteBlock(b); err != nil {
+			t.Fatalf("decoder.WriteBlock error %s", err)
+		}
+	}
+	if err := decoder.Flush(); err != nil {
+		t.Fatalf("decoder.Flush error %s", err)
+	}
+	q = buffer.Bytes()
+	if diff := cmp.Diff(p, q, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("decoded mismatch after Flush (+got -want):\n%s",
+			diff)
+	}
 }
 
 func FuzzBHP(f *testing.F) {
@@ -110,6 +170,28 @@ func FuzzBDHP(f *testing.F) {
 	})
 }
 
+func FuzzTHP(f *testing.F) {
+	f.Add(3, 5, 5, 6, 8, 7, []byte("=====foofoobarfoobar bartender===="))
+	f.Fuzz(func(t *testing.T,
+		inputLen1, hashBits1 int,
+		inputLen2, hashBits2 int,
+		inputLen3, hashBits3 int,
+		p []byte) {
+
+		cfg := &THPConfig{
+			WindowSize: 1024,
+			BlockSize:  512,
+			InputLen1:  inputLen1,
+			HashBits1:  hashBits1,
+			InputLen2:  inputLen2,
+			HashBits2:  hashBits2,
+			InputLen3:  inputLen3,
+			HashBits3:  hashBits3,
+		}
+		testParser(t, cfg, p)
+	})
+}
+
 func FuzzBUP(f *testing.F) {
 	f.Add(3, 5, 8, []byte("=====foofoobarfoobar bartender===="))
 	f.Fuzz(func(t *testing.T,
@@ -132,6 +214,28 @@ func FuzzBUP(f *testing.F) {
 	})
 }
 
+func FuzzBBUP(f *testing.F) {
+	f.Add(3, 5, 8, []byte("=====foofoobarfoobar bartender===="))
+	f.Fuzz(func(t *testing.T,
+		inputLen, hashBits, bucketSize int,
+		p []byte) {
+
+		cfg := &BBUPConfig{
+			WindowSize: 1024,
+			BlockSize:  512,
+			InputLen:   inputLen,
+			HashBits:   hashBits,
+			BucketSize: bucketSize,
+		}
+		cfg.SetDefaults()
+		// We need to limit the memory consumption for Fuzzing.
+		if cfg.HashBits > 21 {
+			t.Skip()
+		}
+		testParser(t, cfg, p)
+	})
+}
+
 func FuzzGSAP(f *testing.F) {
 	f.Add([]byte("=====foofoobarfoobar bartender===="))
 	f.Fuzz(func(t *testing.T, p []byte) {
@@ -156,6 +260,43 @@ func FuzzOSAP(f *testing.F) {
 	})
 }
 
+func TestBUPBucketSize(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+	cost := func(bucketSize int) int64 {
+		s := newTestParser(t, &BUPConfig{
+			WindowSize: 256 * kiB,
+			BucketSize: bucketSize,
+		})
+		if err := s.Reset(data); err != nil {
+			t.Fatalf("s.Reset error %s", err)
+		}
+		var blk Block
+		if _, err := Flush(s, &blk); err != nil {
+			t.Fatalf("s.Flush error %s", err)
+		}
+		g, err := blk.Decompress(0)
+		if err != nil {
+			t.Fatalf("blk.Decompress error %s", err)
+		}
+		if !bytes.Equal(g, data) {
+			t.Fatalf("BucketSize=%d: decompressed data differs",
+				bucketSize)
+		}
+		return blk.Cost(XZCost)
+	}
+	c1, c16 := cost(1), cost(16)
+	t.Logf("cost BucketSize=1: %d bits; BucketSize=16: %d bits", c1, c16)
+	if c16 >= c1 {
+		t.Fatalf("BucketSize=16 cost %d bits; not less than %d bits"+
+			" for BucketSize=1", c16, c1)
+	}
+}
+
 func newTestParser(tb testing.TB, cfg ParserConfig) Parser {
 	s, err := cfg.NewParser()
 	if err != nil {
@@ -165,31 +306,6 @@ func newTestParser(tb testing.TB, cfg ParserConfig) Parser {
 	return s
 }
 
-// blockCost computes the cost of the block in bits.
-func blockCost(blk *Block) int64 {
-	c := int64(0)
-	for _, seq := range blk.Sequences {
-		l := int64(seq.MatchLen)
-		l -= 2
-		switch {
-		case l < 8:
-			c += 4
-		case l < 16:
-			c += 5
-		default:
-			c += 10
-		}
-		d := int64(seq.Offset) - 1
-		if d < 4 {
-			c += 4
-		} else {
-			c += 2 + int64(bits.Len64(uint64(d)))
-		}
-	}
-	c += 9 * int64(len(blk.Literals))
-	return c
-}
-
 func BenchmarkParsers(b *testing.B) {
 	const enwik7 = "testdata/enwik7"
 	benchmarks := []struct {
@@ -264,9 +380,30 @@ func BenchmarkParsers(b *testing.B) {
 			HashBits2:  18,
 			WindowSize: 8 << 20,
 		}},
+		{"TripleHashParser-3,5,8", &THPConfig{
+			InputLen1:  3,
+			HashBits1:  15,
+			InputLen2:  5,
+			HashBits2:  18,
+			InputLen3:  8,
+			HashBits3:  18,
+			WindowSize: 8 << 20,
+		}},
 		{"GSAParser", &GSAPConfig{
 			WindowSize: 8 << 20,
 		}},
+		{"BUParser-3-1", &BUPConfig{
+			InputLen:   3,
+			HashBits:   18,
+			BucketSize: 1,
+			WindowSize: 8 << 20,
+		}},
+		{"BUParser-3-16", &BUPConfig{
+			InputLen:   3,
+			HashBits:   18,
+			BucketSize: 16,
+			WindowSize: 8 << 20,
+		}},
 		{"BUParser-3-12", &BUPConfig{
 			InputLen:   3,
 			HashBits:   18,
@@ -279,6 +416,12 @@ func BenchmarkParsers(b *testing.B) {
 			BucketSize: 100,
 			WindowSize: 8 << 20,
 		}},
+		{"BBUParser-3-16", &BBUPConfig{
+			InputLen:   3,
+			HashBits:   18,
+			BucketSize: 16,
+			WindowSize: 8 << 20,
+		}},
 		{"OSAParser", &OSAPConfig{
 			MinMatchLen: 2,
 			MaxMatchLen: 273,
@@ -306,7 +449,7 @@ func BenchmarkParsers(b *testing.B) {
 				for {
 					_, err := r.Parse(&blk, 0)
 					b.StopTimer()
-					cost += blockCost(&blk)
+					cost += blk.Cost(XZCost)
 					b.StartTimer()
 					switch err {
 					case nil:
@@ -438,3 +581,1646 @@ func TestParseJSON(t *testing.T) {
 	}
 	t.Logf("cfg: %+v", cfg)
 }
+
+func TestBufConfigVerifyStrict(t *testing.T) {
+	tests := []struct {
+		cfg      BufConfig
+		warnings []string
+	}{
+		{BufConfig{
+			ShrinkSize: 1024,
+			BufferSize: 8192,
+			WindowSize: 4096,
+			BlockSize:  512,
+		}, nil},
+		{BufConfig{
+			ShrinkSize: 3000,
+			BufferSize: 8192,
+			WindowSize: 4096,
+			BlockSize:  512,
+		}, []string{"ShrinkSize (3000) > WindowSize/2 (2048)"}},
+		{BufConfig{
+			ShrinkSize: 1024,
+			BufferSize: 16384,
+			WindowSize: 4096,
+			BlockSize:  5000,
+		}, []string{"BlockSize (5000) > WindowSize (4096)"}},
+		{BufConfig{
+			ShrinkSize: 1024,
+			BufferSize: 6000,
+			WindowSize: 4096,
+			BlockSize:  512,
+		}, []string{"BufferSize (6000) < 2*WindowSize (8192)"}},
+	}
+	for _, tc := range tests {
+		warnings, err := tc.cfg.VerifyStrict()
+		if err != nil {
+			t.Fatalf("%+v.VerifyStrict() error %s", tc.cfg, err)
+		}
+		if len(warnings) != len(tc.warnings) {
+			t.Fatalf("%+v.VerifyStrict() returned warnings %q;"+
+				" want %q", tc.cfg, warnings, tc.warnings)
+		}
+		for i, w := range warnings {
+			if !strings.HasPrefix(w, tc.warnings[i]) {
+				t.Errorf("warning %q; want prefix %q",
+					w, tc.warnings[i])
+			}
+		}
+	}
+
+	cfg := BufConfig{BufferSize: 100, ShrinkSize: 200, BlockSize: 1}
+	if _, err := cfg.VerifyStrict(); err == nil {
+		t.Fatalf("%+v.VerifyStrict() returned no error", cfg)
+	}
+}
+
+func TestParserConfigJSON(t *testing.T) {
+	tests := []ParserConfig{
+		&HPConfig{WindowSize: 1024, InputLen: 4},
+		&BHPConfig{WindowSize: 1024, HashBits: 12},
+		&DHPConfig{InputLen1: 3, InputLen2: 6},
+		&BDHPConfig{BlockSize: 4096, HashBits2: 16},
+		&THPConfig{InputLen2: 4, HashBits3: 20},
+		&BUPConfig{BucketSize: 3},
+		&BBUPConfig{HashBits: 14, BucketSize: 4},
+		&GSAPConfig{MinMatchLen: 4},
+		&OSAPConfig{MaxMatchLen: 100, Cost: "XZCost", StartOffset: 8,
+			WindowFraction: 0.5},
+		&NPConfig{BlockSize: 4096},
+	}
+	for _, cfg := range tests {
+		p, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatalf("json.Marshal(%+v) error %s", cfg, err)
+		}
+		c, err := ParseJSON(p)
+		if err != nil {
+			t.Fatalf("ParseJSON(%s) error %s", p, err)
+		}
+		if reflect.TypeOf(c) != reflect.TypeOf(cfg) {
+			t.Fatalf("ParseJSON(%s) returned type %T; want %T",
+				p, c, cfg)
+		}
+		if !reflect.DeepEqual(c, cfg) {
+			t.Fatalf("ParseJSON(%s) returned %+v; want %+v",
+				p, c, cfg)
+		}
+	}
+}
+
+func testBlock(tb testing.TB) *Block {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		tb.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	s := newTestParser(tb, &HPConfig{WindowSize: 1 << 20})
+	if _, err = s.Write(data[:128*kiB]); err != nil {
+		tb.Fatalf("s.Write error %s", err)
+	}
+	var blk Block
+	if _, err = s.Parse(&blk, 0); err != nil {
+		tb.Fatalf("s.Parse error %s", err)
+	}
+	return &blk
+}
+
+func TestBlockLiteralRuns(t *testing.T) {
+	blk := testBlock(t)
+	runs := blk.LiteralRuns()
+	if len(runs) != len(blk.Sequences)+1 {
+		t.Fatalf("len(blk.LiteralRuns()) = %d; want %d", len(runs),
+			len(blk.Sequences)+1)
+	}
+	sum, max := 0, 0
+	for _, r := range runs {
+		sum += r
+		if r > max {
+			max = r
+		}
+	}
+	if sum != len(blk.Literals) {
+		t.Fatalf("sum(blk.LiteralRuns()) = %d; want %d", sum,
+			len(blk.Literals))
+	}
+	if m := blk.MaxLiteralRun(); m != max {
+		t.Fatalf("blk.MaxLiteralRun() = %d; want %d", m, max)
+	}
+	lens := blk.MatchLens()
+	if len(lens) != len(blk.Sequences) {
+		t.Fatalf("len(blk.MatchLens()) = %d; want %d", len(lens),
+			len(blk.Sequences))
+	}
+	n := int64(sum)
+	for _, m := range lens {
+		n += int64(m)
+	}
+	if n != blk.Len() {
+		t.Fatalf("sum of runs and match lengths %d; want %d", n,
+			blk.Len())
+	}
+}
+
+func BenchmarkBlockLiteralRuns(b *testing.B) {
+	blk := testBlock(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = blk.LiteralRuns()
+		_ = blk.MaxLiteralRun()
+		_ = blk.MatchLens()
+	}
+}
+
+func TestParserConfigClone(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	tests := []ParserConfig{
+		&HPConfig{WindowSize: 32 * kiB},
+		&BHPConfig{WindowSize: 32 * kiB},
+		&DHPConfig{WindowSize: 32 * kiB},
+		&BDHPConfig{WindowSize: 32 * kiB},
+		&THPConfig{WindowSize: 32 * kiB},
+		&BUPConfig{WindowSize: 32 * kiB},
+		&BBUPConfig{WindowSize: 32 * kiB},
+		&GSAPConfig{WindowSize: 32 * kiB},
+		&OSAPConfig{WindowSize: 32 * kiB},
+		&NPConfig{WindowSize: 32 * kiB},
+	}
+	for _, cfg := range tests {
+		cfg.SetDefaults()
+		c := cfg.Clone()
+		if !reflect.DeepEqual(c, cfg) {
+			t.Fatalf("%+v.Clone() returned %+v", cfg, c)
+		}
+		bc := c.BufConfig()
+		bc.BlockSize++
+		c.SetBufConfig(bc)
+		if cfg.BufConfig().BlockSize == bc.BlockSize {
+			t.Fatalf("modifying the clone of %T modified original",
+				cfg)
+		}
+		bc.BlockSize--
+		c.SetBufConfig(bc)
+
+		var blocks [2][]Block
+		for i, x := range []ParserConfig{cfg, c} {
+			s := Wrap(bytes.NewReader(data), newTestParser(t, x))
+			for {
+				var blk Block
+				if _, err := s.Parse(&blk, 0); err != nil {
+					if err == io.EOF {
+						break
+					}
+					t.Fatalf("s.Parse error %s", err)
+				}
+				blocks[i] = append(blocks[i], blk)
+			}
+		}
+		if diff := cmp.Diff(blocks[0], blocks[1]); diff != "" {
+			t.Fatalf("%T: blocks of clone differ:\n%s", cfg, diff)
+		}
+	}
+}
+
+func TestBlockBinary(t *testing.T) {
+	blocks := []*Block{
+		testBlock(t),
+		{},
+		{
+			Sequences: []Seq{{LitLen: 1, MatchLen: 3, Offset: 1,
+				Aux: 7}},
+			Literals: []byte("ab"),
+		},
+	}
+	var buf bytes.Buffer
+	for _, blk := range blocks {
+		n, err := blk.WriteBinary(&buf)
+		if err != nil {
+			t.Fatalf("blk.WriteBinary error %s", err)
+		}
+		t.Logf("%d sequences, %d literals: %d bytes",
+			len(blk.Sequences), len(blk.Literals), n)
+	}
+	data := buf.Bytes()
+
+	// The one-byte reader doesn't support io.ByteReader.
+	for _, r := range []io.Reader{
+		bytes.NewReader(data),
+		iotest.OneByteReader(bytes.NewReader(data)),
+	} {
+		for i, want := range blocks {
+			blk, err := ReadBlock(r)
+			if err != nil {
+				t.Fatalf("ReadBlock error %s", err)
+			}
+			if diff := cmp.Diff(want, blk,
+				cmpopts.EquateEmpty()); diff != "" {
+				t.Fatalf("block %d differs:\n%s", i, diff)
+			}
+		}
+		if _, err := ReadBlock(r); err != io.EOF {
+			t.Fatalf("ReadBlock at end returned %v; want %v",
+				err, io.EOF)
+		}
+	}
+
+	p := bytes.Clone(data)
+	p[0] = 'X'
+	if _, err := ReadBlock(bytes.NewReader(p)); !errors.Is(err,
+		ErrBlockFormat) {
+		t.Fatalf("ReadBlock with wrong magic returned %v; want %v",
+			err, ErrBlockFormat)
+	}
+	p = bytes.Clone(data)
+	p[3] = blockVersion + 1
+	if _, err := ReadBlock(bytes.NewReader(p)); !errors.Is(err,
+		ErrBlockFormat) {
+		t.Fatalf("ReadBlock with wrong version returned %v; want %v",
+			err, ErrBlockFormat)
+	}
+	r := bytes.NewReader(data[:len(data)-1])
+	var err error
+	for range blocks {
+		_, err = ReadBlock(r)
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadBlock of truncated block returned %v; want %v",
+			err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestParserFlush(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:100000]
+	bc := BufConfig{WindowSize: 128 * kiB, BlockSize: 4 * kiB,
+		BufferSize: 256 * kiB}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{}, &BHPConfig{}, &DHPConfig{}, &BDHPConfig{},
+		&THPConfig{}, &BUPConfig{}, &BBUPConfig{}, &GSAPConfig{},
+		&OSAPConfig{}, &NPConfig{},
+	} {
+		cfg.SetBufConfig(bc)
+		s := newTestParser(t, cfg)
+		if _, err = s.Write(data); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		n, err := Flush(s, &blk)
+		if err != nil {
+			t.Fatalf("%T.Flush error %s", s, err)
+		}
+		if n != len(data) {
+			t.Fatalf("%T.Flush returned %d; want %d", s, n,
+				len(data))
+		}
+		g, err := blk.Decompress(0)
+		if err != nil {
+			t.Fatalf("blk.Decompress error %s", err)
+		}
+		if !bytes.Equal(g, data) {
+			t.Fatalf("%T: flushed block differs from data", s)
+		}
+		if _, err = s.Parse(&blk, 0); err != ErrEmptyBuffer {
+			t.Fatalf("%T.Parse after Flush returned %v; want %v",
+				s, err, ErrEmptyBuffer)
+		}
+	}
+}
+
+func TestParseNil(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:100000]
+	const skipped = 3
+	bc := BufConfig{WindowSize: 128 * kiB, BlockSize: 4 * kiB,
+		BufferSize: 256 * kiB}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{}, &BHPConfig{}, &DHPConfig{}, &BDHPConfig{},
+		&THPConfig{}, &BUPConfig{}, &BBUPConfig{}, &GSAPConfig{},
+		&OSAPConfig{}, &NPConfig{},
+	} {
+		cfg.SetBufConfig(bc)
+		s := newTestParser(t, cfg)
+		if _, err = s.Write(data); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		wp := s.(interface{ WritePosition() int })
+		for i := 1; i <= skipped; i++ {
+			n, err := s.Parse(nil, 0)
+			if err != nil {
+				t.Fatalf("%T.Parse(nil, 0) error %s", s, err)
+			}
+			if n != bc.BlockSize {
+				t.Fatalf("%T.Parse(nil, 0) returned %d; want %d",
+					s, n, bc.BlockSize)
+			}
+			if w := wp.WritePosition(); w != i*bc.BlockSize {
+				t.Fatalf("%T: W=%d after Parse(nil, 0); want %d",
+					s, w, i*bc.BlockSize)
+			}
+		}
+		var blk Block
+		n, err := Flush(s, &blk)
+		if err != nil {
+			t.Fatalf("%T.Flush error %s", s, err)
+		}
+		if want := len(data) - skipped*bc.BlockSize; n != want {
+			t.Fatalf("%T.Flush returned %d; want %d", s, n, want)
+		}
+	}
+}
+
+func TestBlockAudit(t *testing.T) {
+	tests := []struct {
+		blk       Block
+		maxOffset uint32
+		ok        bool
+	}{
+		{Block{}, 0, true},
+		{Block{
+			Sequences: []Seq{{LitLen: 3, MatchLen: 4, Offset: 3}},
+			Literals:  []byte("abc"),
+		}, 3, true},
+		{Block{
+			Sequences: []Seq{{LitLen: 3, MatchLen: 4, Offset: 4}},
+			Literals:  []byte("abc"),
+		}, 8, false},
+		{Block{
+			Sequences: []Seq{{LitLen: 3, MatchLen: 4, Offset: 3}},
+			Literals:  []byte("abc"),
+		}, 2, false},
+		{Block{
+			Sequences: []Seq{
+				{LitLen: 2, MatchLen: 4, Offset: 2},
+				{LitLen: 1, MatchLen: 3, Offset: 7},
+			},
+			Literals: []byte("abcd"),
+		}, 7, true},
+		{Block{
+			Sequences: []Seq{
+				{LitLen: 2, MatchLen: 4, Offset: 2},
+				{LitLen: 1, MatchLen: 3, Offset: 8},
+			},
+			Literals: []byte("abcd"),
+		}, 8, false},
+		{Block{
+			Sequences: []Seq{{LitLen: 2, MatchLen: 4, Offset: 0}},
+			Literals:  []byte("ab"),
+		}, 8, false},
+		{Block{
+			Sequences: []Seq{{LitLen: 3, MatchLen: 4, Offset: 1}},
+			Literals:  []byte("ab"),
+		}, 8, false},
+	}
+	for i, tc := range tests {
+		err := tc.blk.Audit(tc.maxOffset)
+		if tc.ok && err != nil {
+			t.Errorf("test %d: Audit(%d) error %s", i,
+				tc.maxOffset, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("test %d: Audit(%d) returned no error", i,
+				tc.maxOffset)
+		}
+	}
+
+	blk := testBlock(t)
+	if err := blk.Audit(1 << 20); err != nil {
+		t.Fatalf("testBlock: Audit error %s", err)
+	}
+}
+
+func TestBlockAppendSeq(t *testing.T) {
+	want := testBlock(t)
+	var blk Block
+	lits := want.Literals
+	for _, s := range want.Sequences {
+		if err := blk.AppendSeq(s, lits[:s.LitLen]); err != nil {
+			t.Fatalf("blk.AppendSeq(%+v) error %s", s, err)
+		}
+		lits = lits[s.LitLen:]
+	}
+	blk.AppendLiterals(lits)
+	if !reflect.DeepEqual(&blk, want) {
+		t.Fatalf("blk built with AppendSeq differs from parsed block")
+	}
+	if err := blk.Audit(1 << 20); err != nil {
+		t.Fatalf("blk.Audit error %s", err)
+	}
+
+	tests := []struct {
+		seq Seq
+		lit string
+		err error
+	}{
+		{Seq{LitLen: 2, MatchLen: 3, Offset: 1}, "ab", nil},
+		{Seq{LitLen: 1}, "c", nil},
+		{Seq{LitLen: 3, MatchLen: 3, Offset: 1}, "ab", errLitLen},
+		{Seq{LitLen: 1, MatchLen: 3, Offset: 1}, "ab", errLitLen},
+		{Seq{LitLen: 1, MatchLen: 3}, "a", errOffset},
+	}
+	blk.Reset()
+	for _, tc := range tests {
+		n := len(blk.Sequences)
+		err := blk.AppendSeq(tc.seq, []byte(tc.lit))
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("blk.AppendSeq(%+v, %q) returned error %v;"+
+				" want %v", tc.seq, tc.lit, err, tc.err)
+		}
+		if err != nil && len(blk.Sequences) != n {
+			t.Fatalf("blk.AppendSeq modified block on error")
+		}
+	}
+	blk.AppendLiterals([]byte("xyz"))
+	want = &Block{
+		Sequences: []Seq{
+			{LitLen: 2, MatchLen: 3, Offset: 1},
+			{LitLen: 1},
+		},
+		Literals: []byte("abcxyz"),
+	}
+	if !reflect.DeepEqual(&blk, want) {
+		t.Fatalf("blk = %v; want %v", &blk, want)
+	}
+}
+
+func TestBlockCountMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		blk     Block
+		matches int
+	}{
+		{"none", Block{
+			Sequences: []Seq{{LitLen: 2}, {LitLen: 1}},
+			Literals:  []byte("abc"),
+		}, 0},
+		{"half", Block{
+			Sequences: []Seq{
+				{LitLen: 2, MatchLen: 3, Offset: 1},
+				{LitLen: 1},
+				{LitLen: 0, MatchLen: 4, Offset: 2},
+				{LitLen: 1},
+			},
+			Literals: []byte("abcd"),
+		}, 2},
+		{"all", Block{
+			Sequences: []Seq{
+				{LitLen: 2, MatchLen: 3, Offset: 1},
+				{LitLen: 0, MatchLen: 4, Offset: 2},
+			},
+			Literals: []byte("abcde"),
+		}, 2},
+		{"empty", Block{}, 0},
+	}
+	for _, tc := range tests {
+		if g := tc.blk.CountMatches(); g != tc.matches {
+			t.Errorf("%s: CountMatches() = %d; want %d",
+				tc.name, g, tc.matches)
+		}
+		if g := tc.blk.CountLiterals(); g != int64(len(tc.blk.Literals)) {
+			t.Errorf("%s: CountLiterals() = %d; want %d",
+				tc.name, g, len(tc.blk.Literals))
+		}
+	}
+
+	blk := testBlock(t)
+	allocs := testing.AllocsPerRun(10, func() {
+		blk.CountMatches()
+		blk.CountLiterals()
+	})
+	if allocs != 0 {
+		t.Fatalf("CountMatches and CountLiterals allocate %g times;"+
+			" want 0", allocs)
+	}
+}
+
+func BenchmarkBlockCountMatches(b *testing.B) {
+	blk := testBlock(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		blk.CountMatches()
+	}
+}
+
+func TestBlockOffsetMetrics(t *testing.T) {
+	blk := &Block{
+		Sequences: []Seq{
+			{LitLen: 2, MatchLen: 3, Offset: 1},
+			{LitLen: 0, MatchLen: 4, Offset: 3},
+			{LitLen: 1, MatchLen: 5, Offset: 4},
+			{LitLen: 3, MatchLen: 0, Offset: 0},
+			{LitLen: 0, MatchLen: 6, Offset: 1000},
+			{LitLen: 0, MatchLen: 7, Offset: 1 << 31},
+		},
+		Literals: []byte("abcdef"),
+	}
+	if g := blk.MaxMatchOffset(); g != 1<<31 {
+		t.Fatalf("MaxMatchOffset() = %d; want %d", g, 1<<31)
+	}
+	wantAvg := float64(1+3+4+1000+1<<31) / 5
+	if g := blk.AverageMatchOffset(); g != wantAvg {
+		t.Fatalf("AverageMatchOffset() = %g; want %g", g, wantAvg)
+	}
+	buckets := make([]int64, 32)
+	blk.OffsetHistogram(buckets)
+	want := make([]int64, 32)
+	want[0], want[1], want[2], want[9], want[31] = 1, 1, 1, 1, 1
+	if !reflect.DeepEqual(buckets, want) {
+		t.Fatalf("OffsetHistogram returned %v; want %v", buckets, want)
+	}
+
+	var empty Block
+	if g := empty.MaxMatchOffset(); g != 0 {
+		t.Fatalf("empty MaxMatchOffset() = %d; want 0", g)
+	}
+	if g := empty.AverageMatchOffset(); g != 0 {
+		t.Fatalf("empty AverageMatchOffset() = %g; want 0", g)
+	}
+	empty.OffsetHistogram(buckets)
+	if !reflect.DeepEqual(buckets, make([]int64, 32)) {
+		t.Fatalf("empty OffsetHistogram returned %v; want zeros",
+			buckets)
+	}
+
+	blk = testBlock(t)
+	allocs := testing.AllocsPerRun(10, func() {
+		blk.MaxMatchOffset()
+		blk.AverageMatchOffset()
+		blk.OffsetHistogram(buckets)
+	})
+	if allocs != 0 {
+		t.Fatalf("offset metrics allocate %g times; want 0", allocs)
+	}
+}
+
+func BenchmarkBlockOffsetMetrics(b *testing.B) {
+	blk := testBlock(b)
+	buckets := make([]int64, 32)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		blk.MaxMatchOffset()
+		blk.AverageMatchOffset()
+		blk.OffsetHistogram(buckets)
+	}
+}
+
+func TestBlockCloneReset(t *testing.T) {
+	blk := testBlock(t)
+	c := blk.Clone()
+	if !reflect.DeepEqual(blk, c) {
+		t.Fatalf("clone differs from original")
+	}
+	c.Sequences[0].MatchLen++
+	c.Literals[0]++
+	if reflect.DeepEqual(blk, c) {
+		t.Fatalf("modification of clone changed the original")
+	}
+
+	capSeqs, capLits := cap(blk.Sequences), cap(blk.Literals)
+	blk.Reset()
+	if len(blk.Sequences) != 0 || len(blk.Literals) != 0 {
+		t.Fatalf("block not empty after Reset")
+	}
+	if cap(blk.Sequences) != capSeqs || cap(blk.Literals) != capLits {
+		t.Fatalf("Reset didn't preserve capacity")
+	}
+}
+
+func FuzzBlockReuse(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="))
+	f.Add(bytes.Repeat([]byte("abc"), 100))
+	f.Fuzz(func(t *testing.T, p []byte) {
+		cfg := &HPConfig{WindowSize: 1024, BlockSize: 16}
+		s := newTestParser(t, cfg)
+		w := Wrap(bytes.NewReader(p), s)
+		var (
+			blk    Block
+			clones []*Block
+		)
+		for {
+			if _, err := w.Parse(&blk, 0); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("w.Parse error %s", err)
+			}
+			clones = append(clones, blk.Clone())
+		}
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 1024})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		for _, c := range clones {
+			if _, _, _, err = d.WriteBlock(*c); err != nil {
+				t.Fatalf("d.WriteBlock error %s", err)
+			}
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), p) {
+			t.Fatalf("decoded %q; want %q", buf.Bytes(), p)
+		}
+	})
+}
+
+// streamWindowSize returns the window size used by CompressTo for cfg.
+func streamWindowSize(tb testing.TB, cfg Config) int {
+	pc, err := cfg.ParserConfig(BufConfig{})
+	if err != nil {
+		tb.Fatalf("cfg.ParserConfig error %s", err)
+	}
+	pc.SetDefaults()
+	return pc.BufConfig().WindowSize
+}
+
+func TestCompressTo(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	cfg := Config{Effort: 3}
+	var buf bytes.Buffer
+	n, err := CompressTo(&buf, bytes.NewReader(data), cfg)
+	if err != nil {
+		t.Fatalf("CompressTo error %s", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("CompressTo returned %d; want %d", n, buf.Len())
+	}
+	t.Logf("compressed %d bytes to %d bytes", len(data), n)
+	compressed := buf.Bytes()
+
+	h := sha256.New()
+	windowSize := streamWindowSize(t, cfg)
+	n, err = DecompressFrom(h, bytes.NewReader(compressed), windowSize)
+	if err != nil {
+		t.Fatalf("DecompressFrom error %s", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("DecompressFrom returned %d; want %d", n, len(data))
+	}
+	want := sha256.Sum256(data)
+	if !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Fatalf("SHA-256 of decompressed data differs")
+	}
+
+	// A stream without end marker is truncated.
+	r := bytes.NewReader(compressed[:len(compressed)-4])
+	_, err = DecompressFrom(io.Discard, r, windowSize)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("DecompressFrom of truncated stream returned %v;"+
+			" want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	buf.Reset()
+	if _, err = CompressTo(&buf, strings.NewReader(""), cfg); err != nil {
+		t.Fatalf("CompressTo error %s", err)
+	}
+	var sb strings.Builder
+	n, err = DecompressFrom(&sb, &buf, windowSize)
+	if err != nil {
+		t.Fatalf("DecompressFrom error %s", err)
+	}
+	if n != 0 || sb.Len() != 0 {
+		t.Fatalf("DecompressFrom of empty stream returned %d bytes", n)
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	cfg := &HPConfig{WindowSize: 64 * kiB}
+	p := newTestParser(t, cfg)
+	var buf bytes.Buffer
+	in, out, err := EncodeStream(&buf, bytes.NewReader(data), p)
+	if err != nil {
+		t.Fatalf("EncodeStream error %s", err)
+	}
+	if in != int64(len(data)) {
+		t.Fatalf("EncodeStream read %d bytes; want %d", in, len(data))
+	}
+	if out != int64(buf.Len()) {
+		t.Fatalf("EncodeStream wrote %d bytes; want %d", out, buf.Len())
+	}
+	stream := buf.Len()
+	// Data following the stream must not be counted.
+	buf.WriteString("trailer")
+
+	h := sha256.New()
+	in, out, err = DecodeStream(h, &buf, cfg.WindowSize)
+	if err != nil {
+		t.Fatalf("DecodeStream error %s", err)
+	}
+	if in != int64(stream) {
+		t.Fatalf("DecodeStream consumed %d bytes; want %d", in, stream)
+	}
+	if out != int64(len(data)) {
+		t.Fatalf("DecodeStream wrote %d bytes; want %d", out, len(data))
+	}
+	want := sha256.Sum256(data)
+	if !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Fatalf("SHA-256 of decoded data differs")
+	}
+}
+
+func BenchmarkEncodeStream(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	p := newTestParser(b, &HPConfig{WindowSize: 64 * kiB})
+	b.Run("parse", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		var blk Block
+		for i := 0; i < b.N; i++ {
+			if err := p.Reset(nil); err != nil {
+				b.Fatalf("p.Reset error %s", err)
+			}
+			wp := Wrap(bytes.NewReader(data), p)
+			for {
+				if _, err := wp.Parse(&blk, 0); err != nil {
+					if err == io.EOF {
+						break
+					}
+					b.Fatalf("wp.Parse error %s", err)
+				}
+			}
+		}
+	})
+	b.Run("encode", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if err := p.Reset(nil); err != nil {
+				b.Fatalf("p.Reset error %s", err)
+			}
+			_, _, err := EncodeStream(io.Discard, bytes.NewReader(data),
+				p)
+			if err != nil {
+				b.Fatalf("EncodeStream error %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompressTo(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	cfg := Config{Effort: 3}
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		_, err = CompressTo(io.Discard, bytes.NewReader(data), cfg)
+		if err != nil {
+			b.Fatalf("CompressTo error %s", err)
+		}
+	}
+}
+
+func BenchmarkDecompressFrom(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	cfg := Config{Effort: 3}
+	var buf bytes.Buffer
+	if _, err = CompressTo(&buf, bytes.NewReader(data), cfg); err != nil {
+		b.Fatalf("CompressTo error %s", err)
+	}
+	compressed := buf.Bytes()
+	windowSize := streamWindowSize(b, cfg)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = DecompressFrom(io.Discard, bytes.NewReader(compressed),
+			windowSize)
+		if err != nil {
+			b.Fatalf("DecompressFrom error %s", err)
+		}
+	}
+}
+
+// fragment splits the literal lengths of the sequences into literal-only
+// sequences and inserts empty sequences as directed by the bytes of f.
+func fragment(blk *Block, f []byte) *Block {
+	frag := &Block{Literals: blk.Literals}
+	for i, s := range blk.Sequences {
+		var c byte
+		if len(f) > 0 {
+			c = f[i%len(f)]
+		}
+		if c&1 != 0 {
+			frag.Sequences = append(frag.Sequences, Seq{})
+		}
+		if c&2 != 0 && s.LitLen > 0 {
+			k := s.LitLen / 2
+			frag.Sequences = append(frag.Sequences,
+				Seq{LitLen: k})
+			s.LitLen -= k
+		}
+		frag.Sequences = append(frag.Sequences, s)
+	}
+	if len(f) > 0 && f[0]&4 != 0 {
+		runs := blk.LiteralRuns()
+		frag.Sequences = append(frag.Sequences,
+			Seq{LitLen: uint32(runs[len(runs)-1])})
+	}
+	return frag
+}
+
+func TestBlockDefragment(t *testing.T) {
+	blk := testBlock(t)
+	frag := fragment(blk, []byte{7, 2, 0, 3, 1})
+	n := frag.Len()
+	frag.Defragment()
+	if frag.Len() != n {
+		t.Fatalf("Len() = %d after Defragment; want %d", frag.Len(), n)
+	}
+	for i, s := range frag.Sequences {
+		if s.MatchLen == 0 {
+			t.Fatalf("sequence %d has no match after Defragment", i)
+		}
+	}
+	if diff := cmp.Diff(blk, frag); diff != "" {
+		t.Fatalf("defragmented block differs (-want +got):\n%s", diff)
+	}
+}
+
+func FuzzBlockDefragment(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="), []byte{7, 2})
+	f.Add([]byte("abcabcabcabc"), []byte{})
+	f.Fuzz(func(t *testing.T, p []byte, fr []byte) {
+		s := newTestParser(t, &HPConfig{WindowSize: 1024,
+			BlockSize: 512, BufferSize: 2048})
+		if len(p) > 2048 {
+			t.Skip()
+		}
+		if _, err := s.Write(p); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		if _, err := Flush(s, &blk); err != nil &&
+			err != ErrEmptyBuffer {
+			t.Fatalf("s.Flush error %s", err)
+		}
+		frag := fragment(&blk, fr)
+		want, err := frag.Decompress(1024)
+		if err != nil {
+			t.Fatalf("frag.Decompress error %s", err)
+		}
+		frag.Defragment()
+		got, err := frag.Decompress(1024)
+		if err != nil {
+			t.Fatalf("frag.Decompress after Defragment error %s",
+				err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("decoded %q after Defragment; want %q",
+				got, want)
+		}
+	})
+}
+
+// checkTrimToBytes splits the block at position n and verifies that head and
+// tail decode to the same data as the block.
+func checkTrimToBytes(t *testing.T, blk *Block, n int64, want []byte) {
+	head, tail, err := blk.TrimToBytes(n)
+	if err != nil {
+		t.Fatalf("blk.TrimToBytes(%d) error %s", n, err)
+	}
+	if head.Len() != n {
+		t.Fatalf("head.Len() = %d; want %d", head.Len(), n)
+	}
+	if head.Len()+tail.Len() != blk.Len() {
+		t.Fatalf("head.Len() + tail.Len() = %d; want %d",
+			head.Len()+tail.Len(), blk.Len())
+	}
+	var b DecoderBuffer
+	err = b.Init(DecoderConfig{WindowSize: len(want) + 1,
+		BufferSize: 2*len(want) + 2})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	for _, x := range []*Block{head, tail} {
+		if _, _, _, err = b.WriteBlock(*x); err != nil {
+			t.Fatalf("b.WriteBlock error %s", err)
+		}
+	}
+	if !bytes.Equal(b.Data[b.R:], want) {
+		t.Fatalf("TrimToBytes(%d): decoded %q; want %q", n,
+			b.Data[b.R:], want)
+	}
+}
+
+func TestBlockTrimToBytes(t *testing.T) {
+	const str = "=====foofoobarfoobar bartender====ab"
+	s := newTestParser(t, &HPConfig{WindowSize: 1024, InputLen: 3})
+	if _, err := s.Write([]byte(str)); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+	var blk Block
+	if _, err := s.Parse(&blk, 0); err != nil {
+		t.Fatalf("s.Parse error %s", err)
+	}
+	t.Logf("sequences %+v", blk.Sequences)
+	orig := blk.Clone()
+	for n := int64(0); n <= blk.Len(); n++ {
+		checkTrimToBytes(t, &blk, n, []byte(str))
+	}
+	if diff := cmp.Diff(orig, &blk); diff != "" {
+		t.Fatalf("TrimToBytes modified the block:\n%s", diff)
+	}
+	if _, _, err := blk.TrimToBytes(blk.Len() + 1); err == nil {
+		t.Fatalf("TrimToBytes beyond the block end returned no error")
+	}
+}
+
+func FuzzBlockTrimToBytes(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="), int64(7))
+	f.Fuzz(func(t *testing.T, p []byte, n int64) {
+		if len(p) > 1024 {
+			t.Skip()
+		}
+		s := newTestParser(t, &HPConfig{WindowSize: 1024,
+			BlockSize: 1024, BufferSize: 2048})
+		if _, err := s.Write(p); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		if _, err := s.Parse(&blk, 0); err != nil &&
+			err != ErrEmptyBuffer {
+			t.Fatalf("s.Parse error %s", err)
+		}
+		if n < 0 || n > blk.Len() {
+			if _, _, err := blk.TrimToBytes(n); err == nil {
+				t.Fatalf("TrimToBytes(%d) returned no error", n)
+			}
+		}
+		for k := int64(0); k <= blk.Len(); k++ {
+			checkTrimToBytes(t, &blk, k, p)
+		}
+	})
+}
+
+func TestBlockJSON(t *testing.T) {
+	blk := &Block{
+		Sequences: []Seq{
+			{LitLen: 3, MatchLen: 4, Offset: 3},
+			{LitLen: 1, MatchLen: 5, Offset: 8, Aux: 1},
+		},
+		Literals: []byte("abc\x00d"),
+	}
+	p, err := json.Marshal(blk)
+	if err != nil {
+		t.Fatalf("json.Marshal error %s", err)
+	}
+	if !json.Valid(p) {
+		t.Fatalf("json.Marshal returned invalid JSON %s", p)
+	}
+	const want = `{"sequences":[` +
+		`{"lit_len":3,"match_len":4,"offset":3},` +
+		`{"lit_len":1,"match_len":5,"offset":8,"aux":1}],` +
+		`"literals":"YWJjAGQ="}`
+	if string(p) != want {
+		t.Fatalf("json.Marshal returned %s; want %s", p, want)
+	}
+	if s := blk.String(); s != want {
+		t.Fatalf("blk.String() returned %s; want %s", s, want)
+	}
+
+	var g Block
+	if err = json.Unmarshal(p, &g); err != nil {
+		t.Fatalf("json.Unmarshal error %s", err)
+	}
+	if !reflect.DeepEqual(&g, blk) {
+		t.Fatalf("json.Unmarshal returned %+v; want %+v", g, *blk)
+	}
+
+	// The empty block
+	if s := (&Block{}).String(); s != `{"sequences":[],"literals":""}` {
+		t.Fatalf("String() of empty block returned %s", s)
+	}
+	if err = json.Unmarshal([]byte(`{"sequences":[],"literals":""}`),
+		&g); err != nil {
+		t.Fatalf("json.Unmarshal error %s", err)
+	}
+	if len(g.Sequences) != 0 || len(g.Literals) != 0 {
+		t.Fatalf("json.Unmarshal of empty block returned %+v", g)
+	}
+}
+
+func TestSeqString(t *testing.T) {
+	tests := []struct {
+		s    Seq
+		want string
+	}{
+		{Seq{}, "L0M0@0"},
+		{Seq{LitLen: 3, MatchLen: 5, Offset: 12}, "L3M5@12"},
+		{Seq{LitLen: 1, MatchLen: 2, Offset: 3, Aux: 4}, "L1M2@3"},
+	}
+	for _, tc := range tests {
+		if g := tc.s.String(); g != tc.want {
+			t.Errorf("%#v.String() returned %q; want %q",
+				tc.s, g, tc.want)
+		}
+	}
+}
+
+func TestBlockFormat(t *testing.T) {
+	blk := &Block{
+		Sequences: []Seq{
+			{LitLen: 3, MatchLen: 4, Offset: 3},
+			{LitLen: 0, MatchLen: 5, Offset: 8, Aux: 1},
+		},
+		Literals: []byte("abc\x00d"),
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "L3M4@3\nL0M5@8\nL2"},
+		{"%+v", "L3M4@3 616263\nL0M5@8\nL2 0064"},
+		{"%#v", "L3M4@3 aux=0 616263\nL0M5@8 aux=1\nL2 0064"},
+		{"%s", blk.String()},
+		{"%d", "%!d(*lz.Block)"},
+	}
+	for _, tc := range tests {
+		if g := fmt.Sprintf(tc.format, blk); g != tc.want {
+			t.Errorf("fmt.Sprintf(%q, blk) returned %q; want %q",
+				tc.format, g, tc.want)
+		}
+	}
+
+	var nilBlock *Block
+	if g := fmt.Sprintf("%v", nilBlock); g != "<nil>" {
+		t.Errorf("fmt.Sprintf(%q, nil) returned %q; want %q",
+			"%v", g, "<nil>")
+	}
+	empty := &Block{}
+	if g := fmt.Sprintf("%+v", empty); g != "" {
+		t.Errorf("fmt.Sprintf(%q, empty) returned %q; want %q",
+			"%+v", g, "")
+	}
+}
+
+func FuzzBlockJSON(f *testing.F) {
+	f.Add([]byte("abcabcabc"), uint32(3), uint32(6), uint32(3))
+	f.Fuzz(func(t *testing.T, lits []byte, litLen, matchLen,
+		offset uint32) {
+		blk := &Block{
+			Sequences: []Seq{{LitLen: litLen, MatchLen: matchLen,
+				Offset: offset}},
+			Literals: lits,
+		}
+		p, err := json.Marshal(blk)
+		if err != nil {
+			t.Fatalf("json.Marshal error %s", err)
+		}
+		var g Block
+		if err = json.Unmarshal(p, &g); err != nil {
+			t.Fatalf("json.Unmarshal error %s", err)
+		}
+		if !reflect.DeepEqual(g.Sequences, blk.Sequences) ||
+			!bytes.Equal(g.Literals, blk.Literals) {
+			t.Fatalf("round trip returned %+v; want %+v", g, *blk)
+		}
+	})
+}
+
+func TestBlockWriteText(t *testing.T) {
+	source := []byte("abcabcd\x00\xffabcabcd!")
+	blk := &Block{
+		Sequences: []Seq{
+			{LitLen: 3, MatchLen: 3, Offset: 3},
+			{LitLen: 3, MatchLen: 7, Offset: 9},
+		},
+		Literals: []byte("abcd\x00\xff!"),
+	}
+	g, err := blk.Decompress(0)
+	if err != nil {
+		t.Fatalf("blk.Decompress error %s", err)
+	}
+	if !bytes.Equal(g, source) {
+		t.Fatalf("blk.Decompress returned %q; want %q", g, source)
+	}
+
+	var sb strings.Builder
+	if err = blk.WriteText(&sb); err != nil {
+		t.Fatalf("blk.WriteText error %s", err)
+	}
+	const want = "[0] lit=3 match=3 offset=3 abc<copy 3 bytes from -3>\n" +
+		`[1] lit=3 match=7 offset=9 d\x00\xff<copy 7 bytes from -9>` +
+		"\n" +
+		"[trailing] lit=1 !\n"
+	if s := sb.String(); s != want {
+		t.Fatalf("blk.WriteText wrote\n%s\nwant\n%s", s, want)
+	}
+	if !utf8.ValidString(sb.String()) {
+		t.Fatalf("blk.WriteText wrote invalid text")
+	}
+
+	sb.Reset()
+	if err = blk.WriteTextWithSource(&sb, source); err != nil {
+		t.Fatalf("blk.WriteTextWithSource error %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	if len(lines) != len(blk.Sequences)+1 {
+		t.Fatalf("blk.WriteTextWithSource wrote %d lines; want %d",
+			len(lines), len(blk.Sequences)+1)
+	}
+	if !strings.HasSuffix(lines[0], "<copy 3 bytes from -3> = abc") {
+		t.Fatalf("line 0 %q doesn't show the matched bytes", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `= abcabcd`) {
+		t.Fatalf("line 1 %q doesn't show the matched bytes", lines[1])
+	}
+	if err = blk.WriteTextWithSource(&sb, source[:5]); err == nil {
+		t.Fatalf("blk.WriteTextWithSource accepted short source")
+	}
+
+	sb.Reset()
+	if err = (&Block{}).WriteText(&sb); err != nil {
+		t.Fatalf("WriteText of empty block error %s", err)
+	}
+	if s := sb.String(); s != "[trailing] lit=0\n" {
+		t.Fatalf("WriteText of empty block wrote %q", s)
+	}
+}
+
+func TestBlockCost(t *testing.T) {
+	tests := []struct {
+		blk  Block
+		want int64
+	}{
+		{Block{}, 0},
+		{Block{Literals: []byte("abc")}, 27},
+		{Block{
+			Sequences: []Seq{
+				{LitLen: 3, MatchLen: 4, Offset: 3},
+				{LitLen: 1, MatchLen: 20, Offset: 100},
+				{LitLen: 2},
+			},
+			Literals: []byte("abcdef"),
+		}, (4 + 4) + (10 + 2 + 7) + 9*6},
+	}
+	for _, tc := range tests {
+		if g := tc.blk.Cost(XZCost); g != tc.want {
+			t.Errorf("%+v.Cost(XZCost) = %d; want %d", tc.blk, g,
+				tc.want)
+		}
+	}
+
+	var f CostFunc = func(m, o uint32) uint64 {
+		if o == 0 {
+			return uint64(m)
+		}
+		return 100
+	}
+	if g := tests[2].blk.Cost(f); g != 206 {
+		t.Errorf("Cost with custom function = %d; want %d", g, 206)
+	}
+}
+
+func TestBlockOptimize(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	const windowSize = 256 * kiB
+	for _, cfg := range []ParserConfig{
+		&HPConfig{WindowSize: windowSize},
+		&DHPConfig{WindowSize: windowSize},
+		&BUPConfig{WindowSize: windowSize},
+	} {
+		s := Wrap(bytes.NewReader(data), newTestParser(t, cfg))
+		var buf bytes.Buffer
+		d, err := NewDecoder(&buf, DecoderConfig{WindowSize: windowSize})
+		if err != nil {
+			t.Fatalf("NewDecoder error %s", err)
+		}
+		var blk Block
+		var cost, optCost int64
+		for {
+			if _, err = s.Parse(&blk, 0); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("s.Parse error %s", err)
+			}
+			opt, err := blk.Optimize(XZCost)
+			if err != nil {
+				t.Fatalf("blk.Optimize error %s", err)
+			}
+			c, oc := blk.Cost(XZCost), opt.Cost(XZCost)
+			if oc > c {
+				t.Fatalf("%T: optimized cost %d; want <= %d",
+					cfg, oc, c)
+			}
+			if opt.Len() != blk.Len() {
+				t.Fatalf("%T: optimized block has length %d;"+
+					" want %d", cfg, opt.Len(), blk.Len())
+			}
+			cost += c
+			optCost += oc
+			if _, _, _, err = d.WriteBlock(*opt); err != nil {
+				t.Fatalf("d.WriteBlock error %s", err)
+			}
+		}
+		if err = d.Flush(); err != nil {
+			t.Fatalf("d.Flush error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%T: optimized blocks decode differently", cfg)
+		}
+		t.Logf("%T: cost %d; optimized %d", cfg, cost, optCost)
+	}
+
+	blk := Block{
+		Sequences: []Seq{{LitLen: 3, MatchLen: 2, Offset: 0}},
+		Literals:  []byte("abc"),
+	}
+	if _, err = blk.Optimize(XZCost); !errors.Is(err, errOffset) {
+		t.Fatalf("blk.Optimize returned error %v; want %v", err,
+			errOffset)
+	}
+}
+
+func FuzzBlockOptimize(f *testing.F) {
+	f.Add([]byte("=====foofoobarfoobar bartender===="), []byte{7, 2})
+	f.Add([]byte("abcabcabcabc"), []byte{})
+	f.Fuzz(func(t *testing.T, p []byte, fr []byte) {
+		s := newTestParser(t, &HPConfig{WindowSize: 1024,
+			BlockSize: 512, BufferSize: 2048, InputLen: 3})
+		if len(p) > 2048 {
+			t.Skip()
+		}
+		if _, err := s.Write(p); err != nil {
+			t.Fatalf("s.Write error %s", err)
+		}
+		var blk Block
+		if _, err := Flush(s, &blk); err != nil &&
+			err != ErrEmptyBuffer {
+			t.Fatalf("s.Flush error %s", err)
+		}
+		frag := fragment(&blk, fr)
+		opt, err := frag.Optimize(XZCost)
+		if err != nil {
+			t.Fatalf("frag.Optimize error %s", err)
+		}
+		if c, oc := frag.Cost(XZCost), opt.Cost(XZCost); oc > c {
+			t.Fatalf("optimized cost %d; want <= %d", oc, c)
+		}
+		got, err := opt.Decompress(1024)
+		if err != nil {
+			t.Fatalf("opt.Decompress error %s", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatalf("decoded %q after Optimize; want %q", got, p)
+		}
+	})
+}
+
+func TestDHPTuneForText(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 256*kiB)
+	r.Read(random)
+	low := make([]byte, len(random))
+	for i := range low {
+		low[i] = "ab"[r.Intn(2)]
+	}
+	cfg := &DHPConfig{WindowSize: 64 * kiB, BufferSize: 256 * kiB}
+	parse := func(s *doubleHashParser, data []byte) (blocks []Block) {
+		t.Helper()
+		for {
+			var blk Block
+			if _, err := s.Parse(&blk, 0); err != nil {
+				if err == ErrEmptyBuffer {
+					return blocks
+				}
+				t.Fatalf("s.Parse error %s", err)
+			}
+			blocks = append(blocks, blk)
+		}
+	}
+	tests := []struct {
+		name         string
+		data         []byte
+		bits1, bits2 int
+	}{
+		{"lowEntropy", low, 8, 8},
+		{"random", random, 18, 18},
+	}
+	for _, tc := range tests {
+		s := newTestParser(t, cfg).(*doubleHashParser)
+		tuned := newTestParser(t, cfg).(*doubleHashParser)
+		if err := tuned.TuneForText(tc.data); err != nil {
+			t.Fatalf("TuneForText error %s", err)
+		}
+		if n := len(tuned.h1.table); n != 1<<tc.bits1 {
+			t.Fatalf("%s: len(h1.table)=%d; want %d",
+				tc.name, n, 1<<tc.bits1)
+		}
+		if n := len(tuned.h2.table); n != 1<<tc.bits2 {
+			t.Fatalf("%s: len(h2.table)=%d; want %d",
+				tc.name, n, 1<<tc.bits2)
+		}
+		var hits [2]int64
+		for i, p := range []*doubleHashParser{s, tuned} {
+			if err := p.Reset(tc.data); err != nil {
+				t.Fatalf("p.Reset error %s", err)
+			}
+			blocks := parse(p, tc.data)
+			var buf bytes.Buffer
+			d, err := NewDecoder(&buf, DecoderConfig{
+				WindowSize: cfg.WindowSize})
+			if err != nil {
+				t.Fatalf("NewDecoder error %s", err)
+			}
+			for _, blk := range blocks {
+				if _, _, _, err = d.WriteBlock(blk); err != nil {
+					t.Fatalf("d.WriteBlock error %s", err)
+				}
+			}
+			if err = d.Flush(); err != nil {
+				t.Fatalf("d.Flush error %s", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tc.data) {
+				t.Fatalf("%s: decoded data differs", tc.name)
+			}
+			hits[i] = p.Metrics()["hash_hits"]
+		}
+		t.Logf("%s: hash hits %d; tuned %d", tc.name, hits[0], hits[1])
+		if hits[1]*100 < hits[0]*99 {
+			t.Fatalf("%s: tuned parser has %d hash hits; want"+
+				" at least 99%% of %d", tc.name, hits[1], hits[0])
+		}
+	}
+
+	s := newTestParser(t, cfg).(*doubleHashParser)
+	if err := s.TuneForText([]byte("abc")); err == nil {
+		t.Fatalf("TuneForText accepted a sample shorter than InputLen2")
+	}
+}
+
+func TestBDHPBackwardMatchMax(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	r := rand.New(rand.NewSource(1))
+	x := make([]byte, 32)
+	r.Read(x)
+	p := append(append([]byte{}, x...), x...)
+	tests := []struct {
+		max         int
+		i, litIndex int
+		want        int
+	}{
+		{0, 52, 32, 20},
+		{4, 52, 32, 4},
+		{0, 52, 48, 4},
+		{8, 52, 48, 4},
+		{4, 52, 52, 0},
+	}
+	for _, tc := range tests {
+		s := &bdhp{BDHPConfig: BDHPConfig{BackwardMatchMax: tc.max}}
+		m := s.backwardLen(p, tc.i, tc.i-len(x), tc.litIndex)
+		if m != tc.want {
+			t.Errorf("BackwardMatchMax=%d: backwardLen(p, %d, %d, %d)"+
+				" returned %d; want %d", tc.max, tc.i,
+				tc.i-len(x), tc.litIndex, m, tc.want)
+		}
+	}
+
+	cfg := &BDHPConfig{BackwardMatchMax: -1}
+	cfg.SetDefaults()
+	if err := cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted BackwardMatchMax=-1")
+	}
+
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	cfg = &BDHPConfig{WindowSize: 64 * kiB, BackwardMatchMax: 4}
+	s := Wrap(bytes.NewReader(data), newTestParser(t, cfg))
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: cfg.WindowSize})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	var blk Block
+	for {
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+}
+
+func BenchmarkBDHPBackwardMatchMax(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	for _, max := range []int{0, 8} {
+		b.Run(fmt.Sprintf("max=%d", max), func(b *testing.B) {
+			cfg := &BDHPConfig{
+				WindowSize:       8 * miB,
+				BackwardMatchMax: max,
+			}
+			p := newTestParser(b, cfg)
+			b.SetBytes(int64(len(data)))
+			var cost int64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.Reset(nil); err != nil {
+					b.Fatalf("p.Reset error %s", err)
+				}
+				s := Wrap(bytes.NewReader(data), p)
+				cost = 0
+				var blk Block
+				for {
+					if _, err := s.Parse(&blk, 0); err != nil {
+						if err == io.EOF {
+							break
+						}
+						b.Fatalf("s.Parse error %s", err)
+					}
+					b.StopTimer()
+					cost += blk.Cost(XZCost)
+					b.StartTimer()
+				}
+			}
+			b.ReportMetric(float64(8*len(data))/float64(cost),
+				"ratio")
+		})
+	}
+}
+
+func TestTHPCompression(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	dhp := &DHPConfig{WindowSize: 256 * kiB, InputLen1: 3, HashBits1: 18,
+		InputLen2: 6, HashBits2: 18}
+	thp := &THPConfig{WindowSize: 256 * kiB, InputLen1: 3, HashBits1: 18,
+		InputLen2: 5, HashBits2: 18, InputLen3: 8, HashBits3: 18}
+	var cost [2]int64
+	for i, cfg := range []ParserConfig{dhp, thp} {
+		blocks := parseAll(t, newTestParser(t, cfg), data)
+		if g := decodeBlocks(t, blocks, 256*kiB); !bytes.Equal(g, data) {
+			t.Fatalf("%T: decoded data differs from input", cfg)
+		}
+		for j := range blocks {
+			cost[i] += blocks[j].Cost(XZCost)
+		}
+	}
+	t.Logf("cost DHP %d; THP %d", cost[0], cost[1])
+	if cost[1] >= cost[0] {
+		t.Fatalf("THP cost %d not less than DHP cost %d", cost[1], cost[0])
+	}
+
+	cfg := &THPConfig{InputLen1: 4, InputLen2: 4}
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err == nil {
+		t.Fatalf("%+v.Verify() returned no error", cfg)
+	}
+}
+
+func BenchmarkParallelOSAP(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:2*miB]
+	for _, workers := range []int{0, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			cfg := &OSAPConfig{WindowSize: miB, BufferSize: 2 * miB,
+				BlockSize: 64 * kiB, Workers: workers}
+			p := newTestParser(b, cfg)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err = p.Reset(nil); err != nil {
+					b.Fatalf("p.Reset error %s", err)
+				}
+				parseWrapped(b, p, bytes.NewReader(data))
+			}
+		})
+	}
+}
+
+func TestBBUPBackward(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	const windowSize = 256 * kiB
+	bup := &BUPConfig{WindowSize: windowSize, HashBits: 16,
+		BucketSize: 8}
+	bbup := &BBUPConfig{WindowSize: windowSize, HashBits: 16,
+		BucketSize: 8}
+	var cost [2]int64
+	for i, cfg := range []ParserConfig{bup, bbup} {
+		blocks := parseAll(t, newTestParser(t, cfg), data)
+		if g := decodeBlocks(t, blocks, windowSize); !bytes.Equal(g, data) {
+			t.Fatalf("%T: decoded data differs from input", cfg)
+		}
+		for j := range blocks {
+			cost[i] += blocks[j].Cost(XZCost)
+		}
+	}
+	t.Logf("cost BUP %d; BBUP %d", cost[0], cost[1])
+	if cost[1] > cost[0] {
+		t.Fatalf("BBUP cost %d larger than BUP cost %d", cost[1],
+			cost[0])
+	}
+}
+
+func TestTHPTuneForText(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	low := make([]byte, 128*kiB)
+	for i := range low {
+		low[i] = "ab"[r.Intn(2)]
+	}
+	cfg := &THPConfig{WindowSize: 64 * kiB, BufferSize: 128 * kiB,
+		BlockSize: 16 * kiB}
+	s := newTestParser(t, cfg).(*tripleHashParser)
+	if _, err := s.Write(low); err != nil {
+		t.Fatalf("s.Write error %s", err)
+	}
+	var blk Block
+	if _, err := s.Parse(&blk, 0); err != nil {
+		t.Fatalf("s.Parse error %s", err)
+	}
+	blocks := []Block{blk}
+	if err := s.TuneForText(low); err != nil {
+		t.Fatalf("TuneForText error %s", err)
+	}
+	// The 8-byte inputs of the third table have 256 distinct values.
+	bits := []int{8, 8, 9}
+	for i, h := range []*hash{&s.h1, &s.h2, &s.h3} {
+		if n := len(h.table); n != 1<<bits[i] {
+			t.Fatalf("len(h%d.table)=%d; want %d", i+1, n,
+				1<<bits[i])
+		}
+	}
+	for {
+		var blk Block
+		if _, err := s.Parse(&blk, 0); err != nil {
+			if err == ErrEmptyBuffer {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	if g := decodeBlocks(t, blocks, cfg.WindowSize); !bytes.Equal(g, low) {
+		t.Fatalf("decoded data differs")
+	}
+
+	if err := s.TuneForText([]byte("abcde")); err == nil {
+		t.Fatalf("TuneForText accepted a sample shorter than InputLen3")
+	}
+}
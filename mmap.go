@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errReadOnly is returned by the methods of [MmapParser] that would modify
+// the mapped file.
+var errReadOnly = errors.New("lz: memory-mapped parser is read-only")
+
+// mmapMargin is the number of bytes at the end of the file that are not
+// parsed by the hash parser, because it reads 8 bytes at every position. The
+// mapping has no margin behind the file data, so these bytes are emitted as
+// literals.
+const mmapMargin = 7
+
+// MmapParser parses a file that is mapped into memory. The operating system
+// reads only the pages of the file that are actually accessed, so the whole
+// file can be used as buffer without reading it into memory first. Matches
+// are still limited by the window size. The parser uses the hash parser and
+// supports files up to 4 GiB. The parser must be closed after use to release
+// the mapping.
+type MmapParser struct {
+	hp hashParser
+
+	// data is the mapped file; nil for an empty file
+	data []byte
+	// tailDone is set when the last bytes of the file have been returned
+	tailDone bool
+}
+
+// NewMmapParser maps the file at path into memory and creates a hash parser
+// for it. The buffer size of the configuration is set to the file size. The
+// other parameters are handled as by [HPConfig.NewParser].
+func NewMmapParser(path string, cfg HPConfig) (p *MmapParser, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping stays valid after the file has been closed.
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size > int64(maxUint32)-8 || size > int64(maxInt)-8 {
+		return nil, fmt.Errorf("lz: file %s too large for mapping"+
+			" (%d bytes)", path, size)
+	}
+	cfg.BufferSize = max(int(size), 1)
+	p = new(MmapParser)
+	if err = p.hp.init(cfg); err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		if p.data, err = mmapFile(f, int(size)); err != nil {
+			return nil, fmt.Errorf("lz: can't map file %s: %w",
+				path, err)
+		}
+	}
+	p.rewind()
+	return p, nil
+}
+
+// rewind puts the parser into its initial state.
+func (p *MmapParser) rewind() {
+	n := max(len(p.data)-mmapMargin, 0)
+	p.hp.Data = p.data[:n:len(p.data)]
+	p.hp.W = 0
+	p.hp.Off = 0
+	p.hp.R = 0
+	p.hp.hash.reset()
+	p.hp.seqHistograms.reset()
+	p.tailDone = false
+}
+
+// Close releases the mapping of the file. The parser cannot be used anymore.
+func (p *MmapParser) Close() error {
+	data := p.data
+	p.data = nil
+	p.hp.Data = nil
+	if data == nil {
+		return nil
+	}
+	return munmap(data)
+}
+
+// Parse computes the LZ77 sequences for the next block of the file. It
+// returns the number of bytes parsed and [ErrEmptyBuffer] at the end of the
+// file. The last bytes of the file are always provided as literals.
+func (p *MmapParser) Parse(blk *Block, flags int) (n int, err error) {
+	n, err = p.hp.Parse(blk, flags)
+	if p.tailDone {
+		return n, err
+	}
+	tail := p.data[len(p.hp.Data):]
+	switch err {
+	case nil:
+		if p.hp.W < len(p.hp.Data) || n+len(tail) > p.hp.BlockSize {
+			return n, nil
+		}
+		if blk != nil && flags&NoTrailingLiterals != 0 &&
+			len(blk.Sequences) > 0 {
+			return n, nil
+		}
+	case ErrEmptyBuffer:
+		if len(tail) == 0 {
+			p.tailDone = true
+			return n, err
+		}
+		if blk != nil {
+			blk.Reset()
+		}
+		n = 0
+	default:
+		return n, err
+	}
+	if blk != nil {
+		blk.Literals = append(blk.Literals, tail...)
+	}
+	p.tailDone = true
+	return n + len(tail), nil
+}
+
+// Reset restarts parsing at the beginning of the file if data is nil. The
+// data of the parser cannot be replaced, so an error is returned otherwise.
+func (p *MmapParser) Reset(data []byte) error {
+	if data != nil {
+		return errReadOnly
+	}
+	p.rewind()
+	return nil
+}
+
+// Shrink does nothing, because the whole file is mapped. It returns 0.
+func (p *MmapParser) Shrink() int { return 0 }
+
+// ParserConfig returns the configuration of the hash parser.
+func (p *MmapParser) ParserConfig() ParserConfig {
+	return p.hp.ParserConfig()
+}
+
+// BufferConfig returns the buffer configuration. The buffer size is the size
+// of the file.
+func (p *MmapParser) BufferConfig() BufConfig {
+	return p.hp.BufferConfig()
+}
+
+// Write returns an error, because the mapped file cannot be modified.
+func (p *MmapParser) Write(q []byte) (n int, err error) {
+	return 0, errReadOnly
+}
+
+// ReadFrom returns an error, because the mapped file cannot be modified.
+func (p *MmapParser) ReadFrom(r io.Reader) (n int64, err error) {
+	return 0, errReadOnly
+}
+
+// ReadAt reads file data at offset off. See [ParserBuffer.ReadAt].
+func (p *MmapParser) ReadAt(q []byte, off int64) (n int, err error) {
+	b := ParserBuffer{Data: p.data}
+	return b.ReadAt(q, off)
+}
+
+// ByteAt returns the byte of the file at offset off. [ErrEndOfBuffer] is
+// returned for the file size and [ErrOutOfBuffer] for all other offsets
+// outside of the file.
+func (p *MmapParser) ByteAt(off int64) (c byte, err error) {
+	if !(0 <= off && off < int64(len(p.data))) {
+		if off == int64(len(p.data)) {
+			return 0, ErrEndOfBuffer
+		}
+		return 0, ErrOutOfBuffer
+	}
+	return p.data[off], nil
+}
@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix && !windows
+
+package lz
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile returns an error, since memory mapping is not supported on this
+// platform.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory mapping not supported")
+}
+
+// munmap does nothing on this platform.
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTempFile writes data into a file in a temporary directory and returns
+// its path.
+func writeTempFile(tb testing.TB, data []byte) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		tb.Fatalf("os.WriteFile error %s", err)
+	}
+	return path
+}
+
+func TestMmapParser(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	cfg := HPConfig{WindowSize: 64 * kiB, BlockSize: 32 * kiB}
+	for _, n := range []int{0, 3, 7, 8, 4096, 100*kiB + 5, miB} {
+		path := writeTempFile(t, data[:n])
+		p, err := NewMmapParser(path, cfg)
+		if err != nil {
+			t.Fatalf("NewMmapParser error %s", err)
+		}
+		if bs := p.BufferConfig().BufferSize; bs != max(n, 1) {
+			t.Fatalf("n=%d: BufferSize=%d; want %d", n, bs, max(n, 1))
+		}
+		for k := 0; k < 2; k++ {
+			g := decodeBuffered(t, p)
+			if !bytes.Equal(g, data[:n]) {
+				t.Fatalf("n=%d: decoded data differs from file", n)
+			}
+			if err = p.Reset(nil); err != nil {
+				t.Fatalf("p.Reset(nil) error %s", err)
+			}
+		}
+		if n > 0 {
+			c, err := p.ByteAt(int64(n - 1))
+			if err != nil {
+				t.Fatalf("p.ByteAt error %s", err)
+			}
+			if c != data[n-1] {
+				t.Fatalf("p.ByteAt(%d) returned %#02x; want %#02x",
+					n-1, c, data[n-1])
+			}
+		}
+		if _, err = p.ByteAt(int64(n)); err != ErrEndOfBuffer {
+			t.Fatalf("p.ByteAt(%d) error %v; want %v", n, err,
+				ErrEndOfBuffer)
+		}
+		if _, err = p.Write([]byte("a")); err != errReadOnly {
+			t.Fatalf("p.Write error %v; want %v", err, errReadOnly)
+		}
+		if err = p.Reset([]byte("a")); err != errReadOnly {
+			t.Fatalf("p.Reset error %v; want %v", err, errReadOnly)
+		}
+		if err = p.Close(); err != nil {
+			t.Fatalf("p.Close error %s", err)
+		}
+	}
+}
+
+func BenchmarkMmapParser(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	path := writeTempFile(b, data)
+	cfg := HPConfig{WindowSize: 8 * miB}
+	parse := func(b *testing.B, p Parser) {
+		var blk Block
+		for {
+			if _, err := p.Parse(&blk, 0); err != nil {
+				if err == ErrEmptyBuffer {
+					return
+				}
+				b.Fatalf("p.Parse error %s", err)
+			}
+		}
+	}
+	b.Run("mmap", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			p, err := NewMmapParser(path, cfg)
+			if err != nil {
+				b.Fatalf("NewMmapParser error %s", err)
+			}
+			parse(b, p)
+			if err = p.Close(); err != nil {
+				b.Fatalf("p.Close error %s", err)
+			}
+		}
+	})
+	b.Run("memory", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		cfg := cfg
+		cfg.BufferSize = len(data)
+		for i := 0; i < b.N; i++ {
+			p := newTestParser(b, &cfg)
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				b.Fatalf("os.ReadFile error %s", err)
+			}
+			if err = p.Reset(buf); err != nil {
+				b.Fatalf("p.Reset error %s", err)
+			}
+			parse(b, p)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows
+
+package lz
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mmapFile maps the first size bytes of the file read-only into memory.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	h, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil,
+		syscall.PAGE_READONLY, uint32(uint64(size)>>32), uint32(size),
+		nil)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	// The view keeps the mapping object alive.
+	defer syscall.CloseHandle(h)
+	addr, err := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, 0, 0,
+		uintptr(size))
+	if err != nil {
+		return nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+	// The address is memory outside of the Go heap; the indirection
+	// avoids the conversion of an uintptr to an unsafe.Pointer.
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+	return unsafe.Slice((*byte)(ptr), size), nil
+}
+
+// munmap releases a mapping created by mmapFile.
+func munmap(data []byte) error {
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(data)))
+	return os.NewSyscallError("UnmapViewOfFile",
+		syscall.UnmapViewOfFile(addr))
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+// NPConfig provides the configuration parameters for the null parser. The null
+// parser doesn't search for matches and returns all data as literals. It can
+// be used for data that cannot be compressed.
+type NPConfig struct {
+	ShrinkSize int
+	BufferSize int
+	WindowSize int
+	BlockSize  int
+}
+
+// Clone creates a copy of the configuration.
+func (cfg *NPConfig) Clone() ParserConfig {
+	x := *cfg
+	return &x
+}
+
+// UnmarshalJSON parses the JSON value and sets the fields of NPConfig.
+func (cfg *NPConfig) UnmarshalJSON(p []byte) error {
+	*cfg = NPConfig{}
+	return unmarshalJSON(cfg, "NP", p)
+}
+
+// MarshalJSON creates the JSON string for the configuration. Note that it adds
+// a property Type with value "NP" to the structure.
+func (cfg *NPConfig) MarshalJSON() (p []byte, err error) {
+	return marshalJSON(cfg, "NP")
+}
+
+// BufConfig returns the [BufConfig] value containing the buffer parameters.
+func (cfg *NPConfig) BufConfig() BufConfig {
+	return bufferConfig(cfg)
+}
+
+// SetBufConfig sets the buffer configuration parameters of the parser
+// configuration.
+func (cfg *NPConfig) SetBufConfig(bc BufConfig) {
+	setBufferConfig(cfg, bc)
+}
+
+// Verify checks the configuration for errors.
+func (cfg *NPConfig) Verify() error {
+	bc := bufferConfig(cfg)
+	return bc.Verify()
+}
+
+// SetDefaults sets the defaults for the buffer parameters that are zero.
+func (cfg *NPConfig) SetDefaults() {
+	bc := bufferConfig(cfg)
+	bc.SetDefaults()
+	setBufferConfig(cfg, bc)
+}
+
+// NewParser creates a new null parser.
+func (cfg NPConfig) NewParser() (s Parser, err error) {
+	nps := new(nullParser)
+	if err = nps.init(cfg); err != nil {
+		return nil, err
+	}
+	return nps, nil
+}
+
+// nullParser returns the buffered data as literals without any matches.
+type nullParser struct {
+	ParserBuffer
+	statsAccumulator
+
+	NPConfig
+}
+
+// init initializes the null parser.
+func (s *nullParser) init(cfg NPConfig) error {
+	cfg.SetDefaults()
+	var err error
+	if err = cfg.Verify(); err != nil {
+		return err
+	}
+	if err = s.ParserBuffer.Init(bufferConfig(&cfg)); err != nil {
+		return err
+	}
+	s.NPConfig = cfg
+	return nil
+}
+
+// ParserConfig returns [NPConfig].
+func (s *nullParser) ParserConfig() ParserConfig {
+	return &s.NPConfig
+}
+
+// Parse puts the next BlockSize bytes of the buffer as literals into the
+// block. The block will have no sequences. It returns ErrEmptyBuffer if no
+// data is available.
+func (s *nullParser) Parse(blk *Block, flags int) (n int, err error) {
+	n = min(len(s.Data)-s.W, s.BlockSize)
+	if blk != nil {
+		blk.Reset()
+	}
+	if n == 0 {
+		return 0, ErrEmptyBuffer
+	}
+	if blk != nil {
+		blk.Literals = append(blk.Literals, s.Data[s.W:s.W+n]...)
+		s.statsAccumulator.addBlock(n, blk)
+	}
+	s.W += n
+	return n, nil
+}
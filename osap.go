@@ -10,6 +10,7 @@ import (
 	"math/bits"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ulikunitz/lz/suffix"
 	"golang.org/x/exp/slices"
@@ -40,6 +41,41 @@ func XZCost(m, o uint32) uint64 {
 	return c
 }
 
+// costFuncs is the registry of the cost functions that can be selected by
+// the Cost field of [OSAPConfig].
+var costFuncs = struct {
+	sync.RWMutex
+	m map[string]CostFunc
+}{m: map[string]CostFunc{"XZCost": XZCost}}
+
+// RegisterCostFunc registers the cost function fn under name, so it can be
+// selected by the Cost field of [OSAPConfig] and survives the JSON round
+// trip of the configuration. [XZCost] is registered as "XZCost". The
+// function panics if name is empty, fn is nil or the name is already
+// registered. It is safe for concurrent use.
+func RegisterCostFunc(name string, fn CostFunc) {
+	if name == "" {
+		panic("lz: RegisterCostFunc with empty name")
+	}
+	if fn == nil {
+		panic("lz: RegisterCostFunc with nil function")
+	}
+	costFuncs.Lock()
+	defer costFuncs.Unlock()
+	if _, ok := costFuncs.m[name]; ok {
+		panic(fmt.Sprintf("lz: cost function %q registered twice", name))
+	}
+	costFuncs.m[name] = fn
+}
+
+// LookupCostFunc returns the cost function registered under name.
+func LookupCostFunc(name string) (fn CostFunc, ok bool) {
+	costFuncs.RLock()
+	defer costFuncs.RUnlock()
+	fn, ok = costFuncs.m[name]
+	return fn, ok
+}
+
 // OSAPConfig provides the configuration parameters for the Optimizing Suffix
 // Array Parser (OSAP).
 type OSAPConfig struct {
@@ -51,7 +87,44 @@ type OSAPConfig struct {
 	MinMatchLen int
 	MaxMatchLen int
 
+	// Cost is the name of the cost function used to find the shortest
+	// path. It must have been registered with [RegisterCostFunc]. The
+	// default is "XZCost".
 	Cost string
+
+	// CacheEdges requests that the edge table is extended for newly
+	// buffered data instead of being rebuilt from scratch. The parser
+	// output is not affected by the option.
+	CacheEdges bool
+
+	// StartOffset gives the number of bytes at the start of the data that
+	// are skipped by the parser after initialization or reset. No
+	// sequences are generated for them, but they are part of the window
+	// and can be referenced by matches.
+	StartOffset int
+
+	// MatchFilter restricts the matches generated by the parser. If it is
+	// not nil, only matches with length m and offset o for which the
+	// function returns true are used. The filter cannot be part of the
+	// JSON representation of the configuration; MarshalJSON returns an
+	// error if it is set.
+	MatchFilter func(m, o uint32) bool `json:"-"`
+
+	// WindowFraction limits the search for matches to the most recent
+	// WindowFraction * WindowSize bytes of the window. The window itself
+	// keeps its size. Values less than 1.0 reduce the time and memory
+	// required for the suffix array at the expense of the compression
+	// ratio. The default is 1.0.
+	WindowFraction float64
+
+	// Workers gives the number of goroutines computing the shortest paths
+	// for the blocks. If it is larger than one, Parse computes the paths
+	// for the next Workers blocks concurrently and uses them in the
+	// following calls. The goroutines terminate before Parse returns and
+	// the output of the parser is not affected. A MatchFilter must be
+	// safe for concurrent use. The values 0 and 1 select the sequential
+	// computation.
+	Workers int
 }
 
 // Clone creates a copy of the configuration.
@@ -63,12 +136,26 @@ func (cfg *OSAPConfig) Clone() ParserConfig {
 // UnmarshalJSON parses the JSON value and sets the fields of OSAPConfig.
 func (cfg *OSAPConfig) UnmarshalJSON(p []byte) error {
 	*cfg = OSAPConfig{}
-	return unmarshalJSON(cfg, "OSAP", p)
+	if err := unmarshalJSON(cfg, "OSAP", p); err != nil {
+		return err
+	}
+	if cfg.Cost != "" {
+		if _, ok := LookupCostFunc(cfg.Cost); !ok {
+			return fmt.Errorf("lz: cost function %q not registered",
+				cfg.Cost)
+		}
+	}
+	return nil
 }
 
 // MarshalJSON creates the JSON string for the configuration. Note that it adds
-// a property Type with value "OSAP" to the structure.
+// a property Type with value "OSAP" to the structure. A configuration with a
+// MatchFilter cannot be marshalled.
 func (cfg *OSAPConfig) MarshalJSON() (p []byte, err error) {
+	if cfg.MatchFilter != nil {
+		return nil, fmt.Errorf(
+			"lz: OSAPConfig with MatchFilter cannot be marshalled")
+	}
 	return marshalJSON(cfg, "OSAP")
 }
 
@@ -105,6 +192,10 @@ func (cfg *OSAPConfig) SetDefaults() {
 	if cfg.Cost == "" {
 		cfg.Cost = "XZCost"
 	}
+
+	if cfg.WindowFraction == 0 {
+		cfg.WindowFraction = 1.0
+	}
 }
 
 // Verify verifies the configuration for the Optimizing Suffix Array Parser.
@@ -120,16 +211,65 @@ func (cfg *OSAPConfig) Verify() error {
 			cfg.MinMatchLen, 2, cfg.MaxMatchLen)
 	}
 
-	switch cfg.Cost {
-	case "XZCost":
-		break
-	default:
+	if cfg.StartOffset < 0 {
+		return fmt.Errorf("lz: StartOffset=%d must not be negative",
+			cfg.StartOffset)
+	}
+
+	if cfg.Workers < 0 {
+		return fmt.Errorf("lz: Workers=%d must not be negative",
+			cfg.Workers)
+	}
+
+	if !(0 < cfg.WindowFraction && cfg.WindowFraction <= 1) {
+		return fmt.Errorf(
+			"lz: WindowFraction=%g must be in range (0..1]",
+			cfg.WindowFraction)
+	}
+
+	if cfg.Cost == "" {
 		return fmt.Errorf("lz.OSAPConfig: Cost string must not be empty")
 	}
+	if _, ok := LookupCostFunc(cfg.Cost); !ok {
+		return fmt.Errorf("lz: cost function %q not registered",
+			cfg.Cost)
+	}
+
+	if cfg.MatchFilter != nil && !cfg.filterAcceptsAny() {
+		return fmt.Errorf(
+			"lz: MatchFilter rejects all matches with lengths in"+
+				" range [%d..%d]",
+			cfg.MinMatchLen, cfg.MaxMatchLen)
+	}
 
 	return nil
 }
 
+// searchWindowSize returns the size of the part of the window that is searched
+// for matches.
+func (cfg *OSAPConfig) searchWindowSize() int {
+	if !(0 < cfg.WindowFraction && cfg.WindowFraction < 1) {
+		return cfg.WindowSize
+	}
+	return int(float64(cfg.WindowSize) * cfg.WindowFraction)
+}
+
+// filterAcceptsAny checks whether the match filter accepts any match with a
+// supported length and an offset inside the search window. The offsets are
+// scanned in increasing order and the scan stops at the first accepted match,
+// so only filters rejecting nearly all matches require a long scan.
+func (cfg *OSAPConfig) filterAcceptsAny() bool {
+	maxOff := uint32(cfg.searchWindowSize())
+	for o := uint32(1); o <= maxOff; o++ {
+		for m := uint32(cfg.MinMatchLen); m <= uint32(cfg.MaxMatchLen); m++ {
+			if cfg.MatchFilter(m, o) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // NewParser returns the Optimizing Parser Array Parser.
 func (cfg *OSAPConfig) NewParser() (s Parser, err error) {
 	osas := new(optSuffixArrayParser)
@@ -144,6 +284,13 @@ type edge struct {
 	o uint32
 }
 
+// osapPath is the shortest path for the block of n bytes at position w
+// computed in advance.
+type osapPath struct {
+	w, n int
+	p    []edge
+}
+
 type optSuffixArrayParser struct {
 	ParserBuffer
 
@@ -152,9 +299,23 @@ type optSuffixArrayParser struct {
 	start   int
 	nEdges  int
 
+	// skip is the number of bytes still to be skipped by Parse.
+	skip int
+
 	tmp []edge
 
-	cost func(m, o uint32) uint64
+	// paths contains the shortest paths computed concurrently if Workers
+	// is larger than one. The paths before pathIndex have been used.
+	paths     []osapPath
+	pathIndex int
+
+	cost CostFunc
+
+	statsAccumulator
+	// edgesTotal, edgesUsed and saSorts are reported by Metrics.
+	edgesTotal int64
+	edgesUsed  int64
+	saSorts    int64
 
 	OSAPConfig
 }
@@ -176,12 +337,10 @@ func (s *optSuffixArrayParser) init(cfg OSAPConfig) error {
 
 	s.resetEdges()
 
-	switch cfg.Cost {
-	case "XZCost":
-		s.cost = XZCost
-	}
+	s.cost, _ = LookupCostFunc(cfg.Cost)
 
 	s.OSAPConfig = cfg
+	s.skip = cfg.StartOffset
 	return nil
 }
 
@@ -192,17 +351,78 @@ func (s *optSuffixArrayParser) Reset(data []byte) error {
 	}
 
 	s.resetEdges()
+	s.skip = s.StartOffset
 	return nil
 }
 
+// WriteAt patches buffered data like [ParserBuffer.WriteAt]. The edge table
+// covers all buffered data, including the data after W, so it is discarded
+// and will be recomputed by the next call to Parse.
+func (s *optSuffixArrayParser) WriteAt(p []byte, off int64) (n int, err error) {
+	n, err = s.ParserBuffer.WriteAt(p, off)
+	if n > 0 {
+		s.resetEdges()
+	}
+	return n, err
+}
+
 func (s *optSuffixArrayParser) Shrink() int {
 	delta := s.ParserBuffer.Shrink()
 	if delta > 0 {
-		s.resetEdges()
+		s.resetPaths()
+		if s.CacheEdges {
+			s.shiftEdges(delta)
+		} else {
+			s.resetEdges()
+		}
 	}
 	return delta
 }
 
+// shiftEdges adapts the edge table to a buffer that has been shrunk by delta
+// bytes. Edges for positions that have been discarded are removed. The edges
+// of the last MaxMatchLen positions are removed as well, because data might
+// have been added to the buffer after they have been computed.
+func (s *optSuffixArrayParser) shiftEdges(delta int) {
+	k := len(s.edges) - s.MaxMatchLen
+	if k <= 0 {
+		s.resetEdges()
+		return
+	}
+	for _, e := range s.edges[k:] {
+		s.nEdges -= len(e)
+	}
+	s.edges = s.edges[:k]
+
+	s.start -= delta
+	if s.start < 0 {
+		k = -s.start
+		if k >= len(s.edges) {
+			s.resetEdges()
+			return
+		}
+		for _, e := range s.edges[:k] {
+			s.nEdges -= len(e)
+		}
+		s.edges = s.edges[k:]
+		s.start = 0
+	}
+
+	// Remove the edges that point to discarded data. The offsets are
+	// decreasing, so we only have to check the first edges.
+	for k, e := range s.edges {
+		i := uint32(s.start + k)
+		j := 0
+		for j < len(e) && e[j].o > i {
+			j++
+		}
+		if j > 0 {
+			s.edges[k] = e[j:]
+			s.nEdges -= j
+		}
+	}
+}
+
 /* TODO: remove
 func reverse[T any](s []T) {
 	i, j := 0, len(s)-1
@@ -244,6 +464,13 @@ func (s *optSuffixArrayParser) resetEdges() {
 	s.start = 0
 	s.nEdges = 0
 	s.tmp = s.tmp[:0]
+	s.resetPaths()
+}
+
+// resetPaths discards the shortest paths computed in advance.
+func (s *optSuffixArrayParser) resetPaths() {
+	s.paths = s.paths[:0]
+	s.pathIndex = 0
 }
 
 func (s *optSuffixArrayParser) computeEdges() {
@@ -251,6 +478,21 @@ func (s *optSuffixArrayParser) computeEdges() {
 	if len(data) > math.MaxInt32 {
 		panic(fmt.Errorf("lz: len(data)=%d too large", len(data)))
 	}
+	s.resetPaths()
+
+	// The edges of positions that are more than MaxMatchLen bytes before
+	// the end of the old edge table cannot change, so we keep them, if
+	// CacheEdges is set.
+	s.tmp = s.tmp[:0]
+	var keep []int
+	if s.CacheEdges && s.start <= s.W {
+		end := s.start + len(s.edges) - s.MaxMatchLen
+		for i := s.W; i < end; i++ {
+			e := s.edges[i-s.start]
+			s.tmp = append(s.tmp, e...)
+			keep = append(keep, len(e))
+		}
+	}
 
 	// Right size edges slice of slice and clean it.
 	s.start = s.W
@@ -276,17 +518,30 @@ func (s *optSuffixArrayParser) computeEdges() {
 	}
 	s.nEdges = 0
 
+	// Restore the kept edges.
+	tmp := s.tmp
+	for i, n := range keep {
+		s.edges[i] = append(s.edges[i], tmp[:n]...)
+		tmp = tmp[n:]
+		s.nEdges += n
+	}
+	s.tmp = s.tmp[:0]
+
 	if len(data) == 0 {
 		return
 	}
 
-	winStart := doz(s.W, s.WindowSize)
+	// from is the first position for which edges have to be computed.
+	from := s.start + len(keep)
+	searchSize := s.searchWindowSize()
+	winStart := doz(from, searchSize)
 
 	// Compute suffix array sa, inverse suffix array sainv and the lcp
 	// table.
 	t := data[winStart:]
 	sa := make([]int32, len(t))
 	suffix.Sort(t, sa)
+	s.saSorts++
 	lcp := make([]int32, len(sa))
 	suffix.LCP(t, sa, nil, lcp)
 
@@ -303,6 +558,8 @@ func (s *optSuffixArrayParser) computeEdges() {
 
 	// index offset to convert suffix indexes into edges indexes
 	w := int32(winStart - s.start)
+	// kFrom is the first edges index to compute
+	kFrom := int32(len(keep))
 
 	// f is called for each segment of common prefixes. We sort the segment
 	// and fill the edges entries using the predecessors. Note we never
@@ -324,11 +581,14 @@ func (s *optSuffixArrayParser) computeEdges() {
 			// k is the index into the edges slice. If it is too
 			// small we can stop.
 			k := i + w
-			if k < 0 {
+			if k < kFrom {
 				break
 			}
 			o := uint32(i - seg[j-1])
-			if o > uint32(s.WindowSize) {
+			if o > uint32(searchSize) {
+				continue
+			}
+			if s.MatchFilter != nil && !s.filterAcceptsLen(uint32(m), o) {
 				continue
 			}
 			p := &s.edges[k]
@@ -341,7 +601,9 @@ func (s *optSuffixArrayParser) computeEdges() {
 			*p = append(*p, edge{m: uint32(m), o: o})
 		}
 	}
+	nKept := s.nEdges
 	suffix.Segments(sa, lcp, s.MinMatchLen, int(maxLen), f)
+	s.edgesTotal += int64(s.nEdges - nKept)
 
 	if edgeStats {
 		fmt.Println(computeEdgeStats(s.edges))
@@ -360,9 +622,23 @@ func (s *optSuffixArrayParser) computeEdges() {
 	*/
 }
 
-// shortestPath appends the shortest path in reversed order
-func (s *optSuffixArrayParser) shortestPath(p []edge, n int) []edge {
-	k := s.W - s.start
+// filterAcceptsLen reports whether the match filter accepts a match with
+// offset o and any length in the range [MinMatchLen..m]. The edge stores only
+// the maximum length; the individual lengths are filtered by shortestPath.
+func (s *optSuffixArrayParser) filterAcceptsLen(m, o uint32) bool {
+	for k := uint32(s.MinMatchLen); k <= m; k++ {
+		if s.MatchFilter(k, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// shortestPath appends the shortest path for the n bytes at position w in
+// reversed order. It doesn't modify the parser and can be called
+// concurrently.
+func (s *optSuffixArrayParser) shortestPath(p []edge, w, n int) []edge {
+	k := w - s.start
 	edges := s.edges[k : k+n]
 
 	type opt struct {
@@ -388,6 +664,9 @@ func (s *optSuffixArrayParser) shortestPath(p []edge, n int) []edge {
 			}
 			o := q[k].o
 			for m := uint32(s.MinMatchLen); m <= max; m++ {
+				if s.MatchFilter != nil && !s.MatchFilter(m, o) {
+					continue
+				}
 				c := ci + s.cost(m, o)
 				j := i + int(m)
 				if c < d[j].c {
@@ -406,7 +685,95 @@ func (s *optSuffixArrayParser) shortestPath(p []edge, n int) []edge {
 	return p
 }
 
+// parallelPath returns the shortest path for the block of n bytes at the
+// window head. If it hasn't been computed in advance, the paths for the next
+// Workers blocks covered by the edge table are computed concurrently.
+func (s *optSuffixArrayParser) parallelPath(n int) []edge {
+	if s.pathIndex < len(s.paths) {
+		q := &s.paths[s.pathIndex]
+		if q.w == s.W && q.n == n {
+			s.pathIndex++
+			return q.p
+		}
+	}
+
+	end := s.start + len(s.edges)
+	k := 0
+	for w := s.W; k < s.Workers && w < end; k++ {
+		m := min(s.BlockSize, end-w)
+		if k == 0 {
+			m = n
+		}
+		if k < len(s.paths) {
+			s.paths[k].w, s.paths[k].n = w, m
+		} else {
+			s.paths = append(s.paths, osapPath{w: w, n: m})
+		}
+		w += m
+	}
+	s.paths = s.paths[:k]
+
+	var wg sync.WaitGroup
+	wg.Add(k)
+	for i := range s.paths {
+		go func(q *osapPath) {
+			defer wg.Done()
+			q.p = s.shortestPath(q.p[:0], q.w, q.n)
+		}(&s.paths[i])
+	}
+	wg.Wait()
+	s.pathIndex = 1
+	return s.paths[0].p
+}
+
+// Metrics returns the performance data of the parser. In addition to the keys
+// of all parsers it provides edges_total for the number of edges computed,
+// edges_used for the number of edges on the shortest paths and sa_sorts for
+// the number of suffix array computations.
+func (s *optSuffixArrayParser) Metrics() map[string]int64 {
+	m := s.statsAccumulator.Metrics()
+	m["edges_total"] = s.edgesTotal
+	m["edges_used"] = s.edgesUsed
+	m["sa_sorts"] = s.saSorts
+	return m
+}
+
+// ResetMetrics clears the counters reported by Metrics.
+func (s *optSuffixArrayParser) ResetMetrics() {
+	s.statsAccumulator.ResetMetrics()
+	s.edgesTotal, s.edgesUsed, s.saSorts = 0, 0, 0
+}
+
+// Rollback moves the window head back to the checkpoint. The edges are
+// discarded if the checkpoint precedes the positions they have been computed
+// for. See [ParserBuffer.Rollback].
+func (s *optSuffixArrayParser) Rollback(cp ParserCheckpoint) error {
+	var err error
+	if err = s.ParserBuffer.Rollback(cp); err != nil {
+		return err
+	}
+	if s.W < s.start {
+		s.resetEdges()
+	}
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse as
+// configured by MinMatchLen.
+func (s *optSuffixArrayParser) MatchLen() int {
+	return s.MinMatchLen
+}
+
+// Parse computes the sequences for the next block. Bytes that have to be
+// skipped according to StartOffset are passed over first; they are not
+// included in the block and not counted in n.
 func (s *optSuffixArrayParser) Parse(blk *Block, flags int) (n int, err error) {
+	if s.skip > 0 {
+		k := min(s.skip, len(s.Data)-s.W)
+		s.W += k
+		s.skip -= k
+	}
+
 	n = len(s.Data) - s.W
 	if n > s.BlockSize {
 		n = s.BlockSize
@@ -416,11 +783,11 @@ func (s *optSuffixArrayParser) Parse(blk *Block, flags int) (n int, err error) {
 		if n == 0 {
 			return 0, ErrEmptyBuffer
 		}
+		s.W += n
 		return n, nil
 	}
 
-	blk.Sequences = blk.Sequences[:0]
-	blk.Literals = blk.Literals[:0]
+	blk.Reset()
 
 	if n == 0 {
 		return 0, ErrEmptyBuffer
@@ -434,10 +801,16 @@ func (s *optSuffixArrayParser) Parse(blk *Block, flags int) (n int, err error) {
 		w := s.W
 		s.W += n
 		blk.Literals = append(blk.Literals, s.Data[w:s.W]...)
+		s.statsAccumulator.addBlock(n, blk)
 		return n, nil
 	}
 
-	sp := s.shortestPath(s.tmp[:0], n)
+	var sp []edge
+	if s.Workers > 1 {
+		sp = s.parallelPath(n)
+	} else {
+		sp = s.shortestPath(s.tmp[:0], s.W, n)
+	}
 	i := uint32(s.W)
 	litIndex := i
 	p := s.Data[:s.W+n]
@@ -458,6 +831,7 @@ func (s *optSuffixArrayParser) Parse(blk *Block, flags int) (n int, err error) {
 		i += e.m
 		litIndex = i
 	}
+	s.edgesUsed += int64(len(blk.Sequences))
 	if flags&NoTrailingLiterals != 0 && len(blk.Sequences) > 0 {
 		i = litIndex
 	} else {
@@ -466,5 +840,6 @@ func (s *optSuffixArrayParser) Parse(blk *Block, flags int) (n int, err error) {
 	}
 	n = int(i) - s.W
 	s.W = int(i)
+	s.statsAccumulator.addBlock(n, blk)
 	return n, nil
 }
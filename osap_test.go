@@ -0,0 +1,456 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// parseChunks writes the data in chunks into the parser and parses a single
+// block after each chunk. So the parser will always find new data in the
+// buffer. It returns all blocks generated.
+func parseChunks(t *testing.T, s Parser, data []byte, chunkSize int) []Block {
+	var blocks []Block
+	for {
+		s.Shrink()
+		k := min(chunkSize, len(data))
+		n, err := s.Write(data[:k])
+		if err != nil && err != ErrFullBuffer {
+			t.Fatalf("s.Write error %s", err)
+		}
+		data = data[n:]
+		var blk Block
+		if _, err = s.Parse(&blk, 0); err != nil {
+			if err == ErrEmptyBuffer {
+				break
+			}
+			t.Fatalf("s.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks
+}
+
+func TestOSAPCacheEdges(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:30000]
+
+	cfg := OSAPConfig{
+		ShrinkSize: 8192,
+		BufferSize: 32768,
+		WindowSize: 16384,
+		BlockSize:  1000,
+	}
+	s1, err := cfg.NewParser()
+	if err != nil {
+		t.Fatalf("cfg.NewParser() error %s", err)
+	}
+	cfg.CacheEdges = true
+	s2, err := cfg.NewParser()
+	if err != nil {
+		t.Fatalf("cfg.NewParser() error %s", err)
+	}
+
+	const chunkSize = 1500
+	blocks1 := parseChunks(t, s1, data, chunkSize)
+	blocks2 := parseChunks(t, s2, data, chunkSize)
+	if diff := cmp.Diff(blocks1, blocks2, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("blocks mismatch (-CacheEdges=false +CacheEdges=true):\n%s",
+			diff)
+	}
+}
+
+func TestOSAPWorkers(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+
+	for _, cacheEdges := range []bool{false, true} {
+		cfg := OSAPConfig{
+			ShrinkSize: 8192,
+			BufferSize: 32768,
+			WindowSize: 16384,
+			BlockSize:  1000,
+			CacheEdges: cacheEdges,
+		}
+		serial := newTestParser(t, &cfg)
+		cfg.Workers = 4
+		parallel := newTestParser(t, &cfg)
+
+		const chunkSize = 1500
+		blocks1 := parseChunks(t, serial, data[:30000], chunkSize)
+		blocks2 := parseChunks(t, parallel, data[:30000], chunkSize)
+		if diff := cmp.Diff(blocks1, blocks2,
+			cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("CacheEdges=%t: chunks mismatch"+
+				" (-Workers=0 +Workers=4):\n%s", cacheEdges, diff)
+		}
+
+		for _, p := range []Parser{serial, parallel} {
+			if err = p.Reset(nil); err != nil {
+				t.Fatalf("p.Reset error %s", err)
+			}
+		}
+		blocks1 = parseWrapped(t, serial, bytes.NewReader(data))
+		blocks2 = parseWrapped(t, parallel, bytes.NewReader(data))
+		if diff := cmp.Diff(blocks1, blocks2,
+			cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("CacheEdges=%t: blocks mismatch"+
+				" (-Workers=0 +Workers=4):\n%s", cacheEdges, diff)
+		}
+	}
+
+	cfg := OSAPConfig{Workers: -1}
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted Workers=%d", cfg.Workers)
+	}
+}
+
+func TestOSAPStartOffset(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:30000]
+
+	const startOffset = 10000
+	cfg := OSAPConfig{
+		WindowSize:  32768,
+		BlockSize:   4096,
+		StartOffset: startOffset,
+	}
+	s := newTestParser(t, &cfg)
+	blocks := parseAll(t, s, data)
+
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: 32768})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	if _, err = d.Write(data[:startOffset]); err != nil {
+		t.Fatalf("d.Write error %s", err)
+	}
+	// pos tracks the position of the sequences in data.
+	pos := int64(startOffset)
+	prefixRef := false
+	for _, blk := range blocks {
+		p := pos
+		for _, seq := range blk.Sequences {
+			p += int64(seq.LitLen)
+			if p-int64(seq.Offset) < startOffset {
+				prefixRef = true
+			}
+			p += int64(seq.MatchLen)
+		}
+		pos += blk.Len()
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+	if !prefixRef {
+		t.Fatalf("no match references the skipped data")
+	}
+}
+
+func TestOSAPMatchFilter(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+
+	const maxOffset = 32768
+	cfg := OSAPConfig{
+		WindowSize: 256 * kiB,
+		BlockSize:  64 * kiB,
+		MatchFilter: func(m, o uint32) bool {
+			return o <= maxOffset
+		},
+	}
+	blocks := parseAll(t, newTestParser(t, &cfg), data)
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: maxOffset})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	for _, blk := range blocks {
+		for _, seq := range blk.Sequences {
+			if seq.Offset > maxOffset {
+				t.Fatalf("sequence %+v has offset larger than"+
+					" %d", seq, maxOffset)
+			}
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+
+	if _, err = json.Marshal(&cfg); err == nil {
+		t.Fatalf("json.Marshal accepted configuration with MatchFilter")
+	}
+
+	cfg.MatchFilter = func(m, o uint32) bool { return false }
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted filter rejecting all matches")
+	}
+	for _, f := range []func(m, o uint32) bool{
+		func(m, o uint32) bool { return m == 258 && o == 1 },
+		func(m, o uint32) bool { return o%6 == 0 },
+		func(m, o uint32) bool { return o == 12345 },
+	} {
+		cfg.MatchFilter = f
+		if err = cfg.Verify(); err != nil {
+			t.Fatalf("Verify error %s", err)
+		}
+	}
+}
+
+func TestOSAPMatchFilterLength(t *testing.T) {
+	// The repeats are longer than MaxMatchLen, so nearly all edges have
+	// the maximum length, which the filter rejects.
+	pattern := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(pattern)
+	data := bytes.Repeat(pattern, 64)
+
+	const maxLen = 258
+	cfg := OSAPConfig{
+		WindowSize: 64 * kiB,
+		BlockSize:  16 * kiB,
+		MatchFilter: func(m, o uint32) bool {
+			return m <= maxLen
+		},
+	}
+	blocks := parseAll(t, newTestParser(t, &cfg), data)
+	matched := 0
+	for _, blk := range blocks {
+		for _, seq := range blk.Sequences {
+			if seq.MatchLen > maxLen {
+				t.Fatalf("sequence %+v has match length larger"+
+					" than %d", seq, maxLen)
+			}
+			matched += int(seq.MatchLen)
+		}
+	}
+	if matched < len(data)*9/10 {
+		t.Fatalf("only %d of %d bytes are covered by matches",
+			matched, len(data))
+	}
+	if got := decodeBlocks(t, blocks, cfg.WindowSize); !bytes.Equal(got,
+		data) {
+		t.Fatalf("decoded data differs from input")
+	}
+}
+
+func TestOSAPWindowFraction(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:256*kiB]
+
+	const windowSize = 64 * kiB
+	cfg := OSAPConfig{
+		WindowSize:     windowSize,
+		BufferSize:     256 * kiB,
+		BlockSize:      16 * kiB,
+		WindowFraction: 0.5,
+	}
+	blocks := parseAll(t, newTestParser(t, &cfg), data)
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: windowSize})
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	for _, blk := range blocks {
+		for _, seq := range blk.Sequences {
+			if seq.Offset > windowSize/2 {
+				t.Fatalf("sequence %+v has offset larger than"+
+					" %d", seq, windowSize/2)
+			}
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			t.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("decoded data differs from input")
+	}
+
+	for _, f := range []float64{-0.5, 1.5} {
+		cfg.WindowFraction = f
+		if err = cfg.Verify(); err == nil {
+			t.Fatalf("Verify accepted WindowFraction=%g", f)
+		}
+	}
+}
+
+func TestXZCost(t *testing.T) {
+	// The match length is encoded by LZMA with a choice bit and 3 bits
+	// for lengths 2..9, two choice bits and 3 bits for lengths 10..17
+	// and two choice bits and 8 bits for longer matches. The distance
+	// costs are modelled by the bit length of the distance o-1 plus two;
+	// distances below 4 cost 4 bits.
+	tests := []struct {
+		m, o uint32
+		want uint64
+	}{
+		{2, 0, 18}, {3, 0, 27}, {7, 0, 63}, {8, 0, 72},
+		{9, 0, 81}, {15, 0, 135}, {16, 0, 144}, {17, 0, 153},
+
+		{2, 1, 8}, {3, 1, 8}, {7, 1, 8}, {8, 1, 8},
+		{9, 1, 8}, {15, 1, 9}, {16, 1, 9}, {17, 1, 9},
+
+		{2, 2, 8}, {9, 2, 8}, {17, 2, 9},
+		{2, 3, 8}, {9, 3, 8}, {17, 3, 9},
+		{2, 4, 8}, {9, 4, 8}, {17, 4, 9},
+
+		{2, 100, 13}, {3, 100, 13}, {7, 100, 13}, {8, 100, 13},
+		{9, 100, 13}, {15, 100, 14}, {16, 100, 14}, {17, 100, 14},
+
+		{2, 1000, 16}, {9, 1000, 16}, {15, 1000, 17}, {17, 1000, 17},
+
+		{2, 32767, 21}, {3, 32767, 21}, {7, 32767, 21}, {8, 32767, 21},
+		{9, 32767, 21}, {15, 32767, 22}, {16, 32767, 22},
+		{17, 32767, 22},
+
+		{18, 1, 14}, {273, 32767, 27},
+	}
+	for _, tc := range tests {
+		got := XZCost(tc.m, tc.o)
+		if got != tc.want {
+			t.Errorf("XZCost(%d, %d) = %d; want %d",
+				tc.m, tc.o, got, tc.want)
+		}
+	}
+
+	for m := uint32(2); m < 273; m++ {
+		if a, b := XZCost(m, 0), XZCost(m+1, 0); a >= b {
+			t.Errorf("XZCost(%d, 0) = %d; must be less than"+
+				" XZCost(%d, 0) = %d", m, a, m+1, b)
+		}
+	}
+	for m := uint32(2); m <= 273; m++ {
+		for o := uint32(1); o < 1<<16; o++ {
+			a, b := XZCost(m, o), XZCost(m, o+1)
+			if a > b {
+				t.Fatalf("XZCost(%d, %d) = %d; must not exceed"+
+					" XZCost(%d, %d) = %d",
+					m, o, a, m, o+1, b)
+			}
+		}
+	}
+}
+
+func TestOSAPCostFuncRegistry(t *testing.T) {
+	const name = "testLiteralCost"
+	// Literals are cheap, so the parser prefers long matches only.
+	literalCost := func(m, o uint32) uint64 {
+		if o == 0 {
+			return 4 * uint64(m)
+		}
+		return XZCost(m, o)
+	}
+	if _, ok := LookupCostFunc(name); !ok {
+		RegisterCostFunc(name, literalCost)
+	}
+	if _, ok := LookupCostFunc("XZCost"); !ok {
+		t.Fatalf("XZCost is not registered")
+	}
+
+	cfg := &OSAPConfig{WindowSize: 32 * kiB, Cost: name}
+	p, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("json.Marshal error %s", err)
+	}
+	pc, err := ParseJSON(p)
+	if err != nil {
+		t.Fatalf("ParseJSON(%s) error %s", p, err)
+	}
+	if diff := cmp.Diff(cfg, pc); diff != "" {
+		t.Fatalf("JSON round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	var seqs [2]int
+	for i, c := range []string{"XZCost", name} {
+		cfg := pc.Clone().(*OSAPConfig)
+		cfg.Cost = c
+		for _, blk := range parseAll(t, newTestParser(t, cfg), data) {
+			seqs[i] += len(blk.Sequences)
+		}
+	}
+	if seqs[1] >= seqs[0] {
+		t.Fatalf("%s generated %d sequences; not less than %d for"+
+			" XZCost", name, seqs[1], seqs[0])
+	}
+
+	p = []byte(`{"Type":"OSAP","Cost":"unknownCost"}`)
+	if _, err = ParseJSON(p); err == nil {
+		t.Fatalf("ParseJSON(%s) returned no error", p)
+	}
+	cfg = &OSAPConfig{Cost: "unknownCost"}
+	cfg.SetDefaults()
+	if err = cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted Cost=%q", cfg.Cost)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   CostFunc
+	}{
+		{"", XZCost},
+		{"nilCost", nil},
+		{"XZCost", XZCost},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterCostFunc(%q) didn't panic",
+						tc.name)
+				}
+			}()
+			RegisterCostFunc(tc.name, tc.fn)
+		}()
+	}
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// parallelJob describes a chunk of data to be parsed by a worker of the
+// [ParallelParser].
+type parallelJob struct {
+	// data contains the window prefix followed by the chunk.
+	data []byte
+	// start is the length of the window prefix.
+	start int
+
+	blocks []Block
+	err    error
+	// done will be closed after the worker has parsed the chunk.
+	done chan struct{}
+}
+
+// ParallelParser parses the data read from a reader using multiple worker
+// goroutines. The input is divided into chunks, which are parsed
+// independently. Each chunk is preceded by at least the last WindowSize bytes
+// of the previous chunk, so matches can reference data of the previous chunk.
+// The blocks are returned in order by the Parse method.
+//
+// The Close method must be called if the parser is not read until io.EOF. The
+// methods of the parser must not be called concurrently.
+type ParallelParser struct {
+	// queue provides the jobs in input order.
+	queue chan *parallelJob
+	// jobs distributes the jobs to the workers.
+	jobs chan *parallelJob
+	// quit is closed by Close.
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	cur *parallelJob
+	i   int
+	err error
+}
+
+// NewParallelParser creates a parallel parser for the reader r. The parser
+// of each worker is selected by the configuration as by [Config.NewParser].
+// The window and block sizes of bc are used; the chunk size is a multiple of
+// the block size of at least four times the window size. Buffer and shrink
+// size are ignored. If the memory budget of the configuration is not zero, it
+// must cover the parsers of all workers and the queued chunks.
+func (cfg Config) NewParallelParser(r io.Reader, bc BufConfig, workers int,
+) (*ParallelParser, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("lz: workers=%d must be positive",
+			workers)
+	}
+	pc, err := cfg.ParserConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+	bc, prefixLen, chunkSize := parallelBufConfig(pc.BufConfig())
+	pc.SetBufConfig(bc)
+	pc.SetDefaults()
+	if err = pc.Verify(); err != nil {
+		return nil, err
+	}
+	m := parallelMemSize(pc, workers)
+	if cfg.MemoryBudget != 0 && m > int64(cfg.MemoryBudget) {
+		return nil, fmt.Errorf(
+			"lz: parallel parser with %d workers requires %d bytes;"+
+				" more than MemoryBudget=%d",
+			workers, m, cfg.MemoryBudget)
+	}
+
+	pp := &ParallelParser{
+		queue: make(chan *parallelJob, 2*workers),
+		jobs:  make(chan *parallelJob, workers),
+		quit:  make(chan struct{}),
+	}
+	parsers := make([]Parser, workers)
+	for i := range parsers {
+		if parsers[i], err = pc.NewParser(); err != nil {
+			return nil, err
+		}
+		parsers[i] = cfg.labelParser(parsers[i], pc)
+	}
+	pp.wg.Add(workers + 1)
+	for _, p := range parsers {
+		go pp.work(p)
+	}
+	go pp.read(r, prefixLen, chunkSize)
+	return pp, nil
+}
+
+// parallelBufConfig returns the buffer configuration for the parsers of the
+// workers together with the length of the window prefix and the chunk size.
+// The window prefix is rounded up to a multiple of the block size.
+func parallelBufConfig(bc BufConfig) (pbc BufConfig, prefixLen, chunkSize int) {
+	n := max(8, (4*bc.WindowSize+bc.BlockSize-1)/bc.BlockSize)
+	chunkSize = n * bc.BlockSize
+	prefixLen = (bc.WindowSize + bc.BlockSize - 1) / bc.BlockSize *
+		bc.BlockSize
+	pbc = bc
+	pbc.BufferSize = bc.WindowSize + bc.BlockSize + chunkSize
+	pbc.ShrinkSize = 0
+	return pbc, prefixLen, chunkSize
+}
+
+// parallelMemSize estimates the memory required by a parallel parser using
+// workers parsers created from pc. The parsers use the data of the chunks as
+// buffers, so the buffers are counted only for the chunks. The queue holds at
+// most 2*workers chunks; one more chunk is read and another one is returned
+// by Parse.
+func parallelMemSize(pc ParserConfig, workers int) int64 {
+	chunk := int64(pc.BufConfig().BufferSize) + 7
+	return int64(workers)*(memSize(pc)-chunk) +
+		int64(2*workers+2)*chunk
+}
+
+// read reads the chunks from r and sends them to the queue and to the
+// workers. The last prefixLen bytes of a chunk are used as window prefix of
+// the next chunk.
+func (pp *ParallelParser) read(r io.Reader, prefixLen, chunkSize int) {
+	defer pp.wg.Done()
+	defer close(pp.jobs)
+	defer close(pp.queue)
+	var prefix []byte
+	for {
+		// The data slice requires a margin of 7 bytes for the hash
+		// parsers.
+		m := len(prefix) + chunkSize
+		data := make([]byte, m, m+7)
+		copy(data, prefix)
+		k, err := io.ReadFull(r, data[len(prefix):])
+		job := &parallelJob{
+			data:  data[:len(prefix)+k],
+			start: len(prefix),
+			done:  make(chan struct{}),
+		}
+		switch err {
+		case nil, io.ErrUnexpectedEOF:
+		case io.EOF:
+			return
+		default:
+			// The error will be reported after all previous
+			// blocks have been returned.
+			job.err = err
+			close(job.done)
+			select {
+			case pp.queue <- job:
+			case <-pp.quit:
+			}
+			return
+		}
+		select {
+		case pp.queue <- job:
+		case <-pp.quit:
+			return
+		}
+		select {
+		case pp.jobs <- job:
+		case <-pp.quit:
+			return
+		}
+		if err == io.ErrUnexpectedEOF {
+			return
+		}
+		prefix = job.data[doz(len(job.data), prefixLen):]
+	}
+}
+
+// work parses the jobs using parser p.
+func (pp *ParallelParser) work(p Parser) {
+	defer pp.wg.Done()
+	for job := range pp.jobs {
+		select {
+		case <-pp.quit:
+			job.err = errParserClosed
+		default:
+			job.blocks, job.err = parseChunk(p, job.data,
+				job.start)
+		}
+		close(job.done)
+	}
+}
+
+// parseChunk parses data[start:] using data[:start] as window prefix. The
+// start must be a multiple of the block size of the parser.
+func parseChunk(p Parser, data []byte, start int) (blocks []Block, err error) {
+	if err = p.Reset(data); err != nil {
+		return nil, err
+	}
+	for start > 0 {
+		n, err := p.Parse(nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		start -= n
+	}
+	for {
+		var blk Block
+		if _, err = p.Parse(&blk, 0); err != nil {
+			if err == ErrEmptyBuffer {
+				return blocks, nil
+			}
+			return nil, err
+		}
+		blocks = append(blocks, blk)
+	}
+}
+
+// errParserClosed is returned by Parse after Close has been called.
+var errParserClosed = errors.New("lz: parallel parser is closed")
+
+// Parse returns the next block in input order. It returns the number of bytes
+// covered by the block and io.EOF if all data has been parsed. The flags are
+// ignored, because the chunks are parsed in advance.
+func (pp *ParallelParser) Parse(blk *Block, flags int) (n int, err error) {
+	if pp.err != nil {
+		return 0, pp.err
+	}
+	for pp.cur == nil || pp.i >= len(pp.cur.blocks) {
+		job, ok := <-pp.queue
+		if !ok {
+			pp.err = io.EOF
+			return 0, pp.err
+		}
+		<-job.done
+		if job.err != nil {
+			pp.err = job.err
+			pp.Close()
+			return 0, pp.err
+		}
+		pp.cur, pp.i = job, 0
+	}
+	*blk = pp.cur.blocks[pp.i]
+	pp.cur.blocks[pp.i] = Block{}
+	pp.i++
+	return int(blk.Len()), nil
+}
+
+// Close stops the worker goroutines and waits for their termination.
+func (pp *ParallelParser) Close() error {
+	pp.closeOnce.Do(func() { close(pp.quit) })
+	if pp.err == nil {
+		pp.err = errParserClosed
+	}
+	// Drain the queue, so the reader goroutine can't block.
+	go func() {
+		for range pp.queue {
+		}
+	}()
+	pp.wg.Wait()
+	return nil
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+// decodeParallel parses and decodes all data provided by the parallel
+// parser.
+func decodeParallel(tb testing.TB, pp *ParallelParser, windowSize int,
+) []byte {
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{WindowSize: windowSize})
+	if err != nil {
+		tb.Fatalf("NewDecoder error %s", err)
+	}
+	var blk Block
+	for {
+		n, err := pp.Parse(&blk, 0)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			tb.Fatalf("pp.Parse error %s", err)
+		}
+		if int64(n) != blk.Len() {
+			tb.Fatalf("pp.Parse returned %d; want %d", n, blk.Len())
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			tb.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		tb.Fatalf("d.Flush error %s", err)
+	}
+	if err = pp.Close(); err != nil {
+		tb.Fatalf("pp.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParallelParser(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	bc := BufConfig{WindowSize: 32 * kiB, BlockSize: 16 * kiB}
+	for effort := 1; effort <= 10; effort++ {
+		cfg := Config{Effort: effort}
+		pp, err := cfg.NewParallelParser(bytes.NewReader(data), bc, 4)
+		if err != nil {
+			t.Fatalf("NewParallelParser error %s", err)
+		}
+		g := decodeParallel(t, pp, bc.WindowSize)
+		if !bytes.Equal(g, data) {
+			t.Fatalf("effort %d: decoded data differs from input",
+				effort)
+		}
+	}
+}
+
+func TestParallelParserClose(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	bc := BufConfig{WindowSize: 32 * kiB, BlockSize: 16 * kiB}
+	cfg := Config{Effort: 3}
+	pp, err := cfg.NewParallelParser(bytes.NewReader(data), bc, 2)
+	if err != nil {
+		t.Fatalf("NewParallelParser error %s", err)
+	}
+	var blk Block
+	if _, err = pp.Parse(&blk, 0); err != nil {
+		t.Fatalf("pp.Parse error %s", err)
+	}
+	if err = pp.Close(); err != nil {
+		t.Fatalf("pp.Close error %s", err)
+	}
+	if _, err = pp.Parse(&blk, 0); err == nil {
+		t.Fatalf("pp.Parse after Close returned no error")
+	}
+
+	errTest := errors.New("test error")
+	r := io.MultiReader(bytes.NewReader(data[:300*kiB]),
+		iotest.ErrReader(errTest))
+	pp, err = cfg.NewParallelParser(r, bc, 2)
+	if err != nil {
+		t.Fatalf("NewParallelParser error %s", err)
+	}
+	var n int64
+	for {
+		k, err := pp.Parse(&blk, 0)
+		if err != nil {
+			if err != errTest {
+				t.Fatalf("pp.Parse returned error %v; want %v",
+					err, errTest)
+			}
+			break
+		}
+		n += int64(k)
+	}
+	t.Logf("%d bytes parsed before error", n)
+	if err = pp.Close(); err != nil {
+		t.Fatalf("pp.Close error %s", err)
+	}
+}
+
+func TestParallelParserMemoryBudget(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	bc := BufConfig{WindowSize: 32 * kiB, BlockSize: 16 * kiB}
+	const workers = 4
+	cfg := Config{Effort: 10}
+	pc, err := cfg.ParserConfig(bc)
+	if err != nil {
+		t.Fatalf("cfg.ParserConfig error %s", err)
+	}
+	serial := memSize(pc)
+	pbc, _, _ := parallelBufConfig(pc.BufConfig())
+	pc.SetBufConfig(pbc)
+	pc.SetDefaults()
+	parallel := parallelMemSize(pc, workers)
+	if parallel <= workers*serial {
+		t.Fatalf("parallel parser requires %d bytes; want more than"+
+			" %d workers * %d bytes", parallel, workers, serial)
+	}
+
+	// The budget covers the serial parser but not the parallel parser.
+	cfg.MemoryBudget = int(parallel - 1)
+	if _, err = cfg.NewParallelParser(bytes.NewReader(data), bc,
+		workers); err == nil {
+		t.Fatalf("NewParallelParser with MemoryBudget=%d returned"+
+			" no error", cfg.MemoryBudget)
+	}
+
+	cfg.MemoryBudget = int(parallel)
+	pp, err := cfg.NewParallelParser(bytes.NewReader(data), bc, workers)
+	if err != nil {
+		t.Fatalf("NewParallelParser error %s", err)
+	}
+	if g := decodeParallel(t, pp, bc.WindowSize); !bytes.Equal(g, data) {
+		t.Fatalf("decoded data differs from input")
+	}
+}
+
+func FuzzParallelParser(f *testing.F) {
+	f.Add(3, []byte("=====foofoobarfoobar bartender===="))
+	f.Add(10, bytes.Repeat([]byte("abcd"), 300))
+	f.Fuzz(func(t *testing.T, effort int, p []byte) {
+		if !(1 <= effort && effort <= 10) {
+			t.Skip()
+		}
+		bc := BufConfig{WindowSize: 64, BlockSize: 16}
+		cfg := Config{Effort: effort}
+		pp, err := cfg.NewParallelParser(bytes.NewReader(p), bc, 3)
+		if err != nil {
+			t.Fatalf("NewParallelParser error %s", err)
+		}
+		g := decodeParallel(t, pp, bc.WindowSize)
+		if !bytes.Equal(g, p) {
+			t.Fatalf("decoded %q; want %q", g, p)
+		}
+	})
+}
+
+func BenchmarkParallelParser(b *testing.B) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	// about 100 MB of input
+	data = bytes.Repeat(data, 10)
+	bc := BufConfig{WindowSize: miB}
+	cfg := Config{Effort: 5}
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				pp, err := cfg.NewParallelParser(
+					bytes.NewReader(data), bc, workers)
+				if err != nil {
+					b.Fatalf("NewParallelParser error %s",
+						err)
+				}
+				var blk Block
+				for {
+					_, err = pp.Parse(&blk, 0)
+					if err != nil {
+						break
+					}
+				}
+				if err != io.EOF {
+					b.Fatalf("pp.Parse error %s", err)
+				}
+				pp.Close()
+			}
+		})
+	}
+}
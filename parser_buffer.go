@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // ParserBuffer provides a base for Parser implementation. Since the package
@@ -23,6 +24,10 @@ type ParserBuffer struct {
 	// from the buffer.
 	Off int64
 
+	// R is the read position of ReadByte in data. It is independent of
+	// W.
+	R int
+
 	BufConfig
 }
 
@@ -53,6 +58,7 @@ func (b *ParserBuffer) Reset(data []byte) error {
 
 	b.W = 0
 	b.Off = 0
+	b.R = 0
 
 	if len(data) == 0 {
 		b.Data = b.Data[:0]
@@ -75,6 +81,71 @@ func (b *ParserBuffer) Reset(data []byte) error {
 	return nil
 }
 
+// Clone returns a deep copy of the buffer. The data slice of the copy has the
+// same capacity as the original including the margin for the hash parsers.
+func (b *ParserBuffer) Clone() *ParserBuffer {
+	c := *b
+	c.Data = make([]byte, len(b.Data), cap(b.Data))
+	copy(c.Data[:cap(c.Data)], b.Data[:cap(b.Data)])
+	return &c
+}
+
+// MoveBuffer transfers the content of src to dst without copying the data.
+// The buffer and window sizes of both buffers must be equal; otherwise an
+// error wrapping [ErrIncompatibleConfig] is returned. All other fields of dst
+// are overwritten. Afterwards src keeps only its configuration and is empty.
+//
+// MoveBuffer is meant for bare ParserBuffer values. It is not a method, so
+// that it isn't promoted onto the parsers embedding a ParserBuffer. Moving
+// the buffer of a parser leaves the hash tables, edges or suffix arrays of
+// the source and the destination parser stale.
+func MoveBuffer(dst, src *ParserBuffer) error {
+	if src.BufferSize != dst.BufferSize || src.WindowSize != dst.WindowSize {
+		return fmt.Errorf(
+			"%w: BufferSize=%d and WindowSize=%d differ from"+
+				" BufferSize=%d and WindowSize=%d",
+			ErrIncompatibleConfig, src.BufferSize, src.WindowSize,
+			dst.BufferSize, dst.WindowSize)
+	}
+	*dst = *src
+	*src = ParserBuffer{BufConfig: src.BufConfig}
+	return nil
+}
+
+// Diff describes the differences between the buffer and other. It reports
+// the differing fields, the lengths of the data slices and the position of
+// the first differing byte. An empty string is returned if the buffers are
+// equal.
+func (b *ParserBuffer) Diff(other *ParserBuffer) string {
+	var d []string
+	if b.W != other.W {
+		d = append(d, fmt.Sprintf("W: %d != %d", b.W, other.W))
+	}
+	if b.Off != other.Off {
+		d = append(d, fmt.Sprintf("Off: %d != %d", b.Off, other.Off))
+	}
+	if b.R != other.R {
+		d = append(d, fmt.Sprintf("R: %d != %d", b.R, other.R))
+	}
+	if b.BufConfig != other.BufConfig {
+		d = append(d, fmt.Sprintf("BufConfig: %+v != %+v",
+			b.BufConfig, other.BufConfig))
+	}
+	if len(b.Data) != len(other.Data) {
+		d = append(d, fmt.Sprintf("len(Data): %d != %d",
+			len(b.Data), len(other.Data)))
+	}
+	n := min(len(b.Data), len(other.Data))
+	for i, c := range b.Data[:n] {
+		if c != other.Data[i] {
+			d = append(d, fmt.Sprintf("Data[%d]: %#02x != %#02x",
+				i, c, other.Data[i]))
+			break
+		}
+	}
+	return strings.Join(d, "; ")
+}
+
 // Shrink will move the window head to the shrink size if it is larger. The
 // amount of data discarded from the buffer, named delta, will be returned.
 func (b *ParserBuffer) Shrink() int {
@@ -86,9 +157,80 @@ func (b *ParserBuffer) Shrink() int {
 	b.Data = b.Data[:n]
 	b.W = b.ShrinkSize
 	b.Off += int64(delta)
+	b.R = max(b.R-delta, 0)
 	return delta
 }
 
+// Available returns the number of bytes that can be written into the buffer
+// before it is full.
+func (b *ParserBuffer) Available() int {
+	return b.BufferSize - len(b.Data)
+}
+
+// NeedsShrink reports whether the free space in the buffer has dropped to
+// ShrinkSize bytes or less and a call of Shrink would free space. It allows
+// to shrink the buffer before a write fails with [ErrFullBuffer].
+func (b *ParserBuffer) NeedsShrink() bool {
+	return b.Available() <= b.ShrinkSize && b.W > b.ShrinkSize
+}
+
+// ReadByte reads the byte at the read position R and advances it. It returns
+// io.EOF if all data of the buffer has been read. The read position is
+// independent of the window head W.
+func (b *ParserBuffer) ReadByte() (c byte, err error) {
+	if b.R >= len(b.Data) {
+		return 0, io.EOF
+	}
+	c = b.Data[b.R]
+	b.R++
+	return c, nil
+}
+
+// UnreadByte moves the read position back by one byte.
+func (b *ParserBuffer) UnreadByte() error {
+	if b.R <= 0 {
+		return errors.New("lz: UnreadByte at start of buffer")
+	}
+	b.R--
+	return nil
+}
+
+// ExtendWindow increases the window size by extra bytes. The window size
+// cannot become larger than the buffer size. Parsers embedding the buffer must
+// update their own window size.
+func (b *ParserBuffer) ExtendWindow(extra int) error {
+	if extra < 0 {
+		return fmt.Errorf("lz: extra=%d must not be negative", extra)
+	}
+	if int64(b.WindowSize)+int64(extra) > int64(b.BufferSize) {
+		return fmt.Errorf(
+			"lz: WindowSize=%d + extra=%d exceeds BufferSize=%d",
+			b.WindowSize, extra, b.BufferSize)
+	}
+	b.WindowSize += extra
+	return nil
+}
+
+// skip moves the write position n bytes forward and calls processSegment to
+// add the skipped bytes to the search structures of the parser. Since a
+// hash covers inputLen bytes, processing starts inputLen-1 bytes before the
+// old write position. The number of skipped bytes is limited by the buffered
+// data. The function provides the implementation of the Skip methods of the
+// dictionaries.
+func (b *ParserBuffer) skip(n, inputLen int, processSegment func(a, b int)) (int, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("lz: Skip argument %d is negative", n)
+	}
+	n = min(n, len(b.Data)-b.W)
+	if n == 0 {
+		return 0, nil
+	}
+	t := b.W + n
+	processSegment(b.W-inputLen+1, t)
+	b.W = t
+	return n, nil
+}
+
 // grow will allocate more buffer data that will have enough space for t bytes
 // or BufferSize bytes plus 7 bytes margin to support the hash parsers.
 // Usually the size allocate will roughly more than twice the requested size to
@@ -131,6 +273,50 @@ func (b *ParserBuffer) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteString writes the string into the buffer like Write without
+// converting it into a byte slice first. If not the complete string can be
+// copied into the buffer, WriteString will return [ErrFullBuffer].
+func (b *ParserBuffer) WriteString(s string) (n int, err error) {
+	available := b.BufferSize - len(b.Data)
+	if available < len(s) {
+		s = s[:available]
+		err = ErrFullBuffer
+	}
+	n = len(s)
+
+	t := len(b.Data) + n
+	if t+7 > cap(b.Data) {
+		b.grow(t)
+	}
+	b.Data = append(b.Data, s...)
+	return n, err
+}
+
+// WriteAt overwrites the buffered data at total offset off with p. It allows
+// the patching of data that has been written but not parsed yet. Writing at
+// the end of the buffer extends the data as Write does. Data before the window
+// head W cannot be modified, because it has already been parsed and the
+// decoder would not see the modification; WriteAt returns [ErrOutOfBuffer]
+// in that case or if off is behind the end of the buffer. If not the complete
+// p slice fits into the buffer, [ErrFullBuffer] will be returned.
+//
+// Parsers that keep information about the data after W must override WriteAt
+// to discard it.
+func (b *ParserBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	i := off - b.Off
+	if !(int64(b.W) <= i && i <= int64(len(b.Data))) {
+		return 0, ErrOutOfBuffer
+	}
+	k := int(i)
+	n = copy(b.Data[k:], p)
+	if n < len(p) {
+		var m int
+		m, err = b.Write(p[n:])
+		n += m
+	}
+	return n, err
+}
+
 // ReadFrom reads the data from reader into the buffer. If there is an error it
 // will be reported. If the buffer is full, [ErrFullBuffer] will be reported.
 func (b *ParserBuffer) ReadFrom(r io.Reader) (n int64, err error) {
@@ -201,3 +387,53 @@ func (b *ParserBuffer) ByteAt(off int64) (c byte, err error) {
 	}
 	return b.Data[i], nil
 }
+
+// ParserCheckpoint records the position of the window head of a
+// [ParserBuffer]. It is created by [ParserBuffer.Checkpoint] and used by
+// Rollback.
+type ParserCheckpoint struct {
+	// off is the total offset of the window head.
+	off int64
+}
+
+// Checkpoint captures the current position of the window head. It supports
+// speculative parsing: after parsing, Rollback returns to the checkpoint
+// and the same data can be parsed again.
+func (b *ParserBuffer) Checkpoint() ParserCheckpoint {
+	return ParserCheckpoint{off: b.Off + int64(b.W)}
+}
+
+// Rollback moves the window head back to the checkpoint. The data in the
+// buffer is not changed. An error is returned if the checkpoint is ahead of
+// the window head or its position has been discarded by Shrink or Reset.
+// Statistics of the parser are not rolled back.
+func (b *ParserBuffer) Rollback(cp ParserCheckpoint) error {
+	w := cp.off - b.Off
+	if !(0 <= w && w <= int64(b.W)) {
+		return fmt.Errorf(
+			"lz: checkpoint at offset %d outside of range [%d..%d]",
+			cp.off, b.Off, b.Off+int64(b.W))
+	}
+	b.W = int(w)
+	return nil
+}
+
+// DataSlice returns the internal data slice of the buffer without copying
+// it. It allows match finders outside of the package to work directly on the
+// buffer. The caller must not modify the slice. The slice is only valid
+// until the next call of Write, ReadFrom, Reset or Shrink.
+func (b *ParserBuffer) DataSlice() []byte {
+	return b.Data
+}
+
+// WritePosition returns the position of the window head W in the slice
+// returned by [ParserBuffer.DataSlice].
+func (b *ParserBuffer) WritePosition() int {
+	return b.W
+}
+
+// WindowStart returns the position of the first byte of the dictionary
+// window in the slice returned by [ParserBuffer.DataSlice].
+func (b *ParserBuffer) WindowStart() int {
+	return doz(b.W, b.WindowSize)
+}
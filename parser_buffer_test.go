@@ -6,8 +6,12 @@ package lz
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
 func TestWindow_Write(t *testing.T) {
@@ -124,3 +128,453 @@ func TestWindow_shrink(t *testing.T) {
 		t.Fatalf("w.Off is %d; want %d", w.Off, wantOff)
 	}
 }
+
+func TestParserBufferClone(t *testing.T) {
+	var b ParserBuffer
+	if err := b.Init(BufConfig{WindowSize: 1024}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	const str = "foobarfoobar"
+	if _, err := b.Write([]byte(str)); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	b.W = 6
+
+	c := b.Clone()
+	if d := b.Diff(c); d != "" {
+		t.Fatalf("b.Diff(c) = %q; want empty string", d)
+	}
+	if c.W != b.W {
+		t.Fatalf("c.W = %d; want %d", c.W, b.W)
+	}
+	if cap(c.Data) != cap(b.Data) {
+		t.Fatalf("cap(c.Data) = %d; want %d", cap(c.Data), cap(b.Data))
+	}
+
+	c.Data[0] = 'g'
+	if string(b.Data) != str {
+		t.Fatalf("b.Data = %q after modifying clone; want %q",
+			b.Data, str)
+	}
+	t.Logf("b.Diff(c) = %q", b.Diff(c))
+	if d := b.Diff(c); !strings.Contains(d, "Data[0]") {
+		t.Fatalf("b.Diff(c) = %q; want Data[0] difference", d)
+	}
+
+	if _, err := c.Write([]byte("baz")); err != nil {
+		t.Fatalf("c.Write error %s", err)
+	}
+	if string(b.Data) != str {
+		t.Fatalf("b.Data = %q after writing to clone; want %q",
+			b.Data, str)
+	}
+	if d := b.Diff(c); !strings.Contains(d, "len(Data)") {
+		t.Fatalf("b.Diff(c) = %q; want len(Data) difference", d)
+	}
+}
+
+func TestMoveBuffer(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+
+	bc := BufConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB,
+		BlockSize: 4 * kiB}
+	var src, dst, x ParserBuffer
+	if err = src.Init(bc); err != nil {
+		t.Fatalf("src.Init error %s", err)
+	}
+	if err = dst.Init(bc); err != nil {
+		t.Fatalf("dst.Init error %s", err)
+	}
+	if _, err = src.Write(data); err != nil {
+		t.Fatalf("src.Write error %s", err)
+	}
+	src.W = 16 * kiB
+	control := src.Clone()
+
+	other := bc
+	other.WindowSize = 16 * kiB
+	if err = x.Init(other); err != nil {
+		t.Fatalf("x.Init error %s", err)
+	}
+	if err = MoveBuffer(&x, &src); !errors.Is(err, ErrIncompatibleConfig) {
+		t.Fatalf("MoveBuffer with different window size returned %v;"+
+			" want %v", err, ErrIncompatibleConfig)
+	}
+
+	p := &src.Data[0]
+	if err = MoveBuffer(&dst, &src); err != nil {
+		t.Fatalf("MoveBuffer error %s", err)
+	}
+	if &dst.Data[0] != p {
+		t.Fatalf("MoveBuffer copied the data")
+	}
+	if d := dst.Diff(control); d != "" {
+		t.Fatalf("dst differs from control: %s", d)
+	}
+	if src.Data != nil || src.W != 0 || src.Off != 0 || src.R != 0 {
+		t.Fatalf("src not empty after MoveBuffer: %+v", src)
+	}
+
+	// The parsers must not get a method moving only their buffer.
+	for _, cfg := range []ParserConfig{
+		&HPConfig{}, &BHPConfig{}, &DHPConfig{}, &BDHPConfig{},
+		&THPConfig{}, &BUPConfig{}, &BBUPConfig{}, &GSAPConfig{},
+		&OSAPConfig{}, &NPConfig{},
+	} {
+		cfg.SetBufConfig(bc)
+		p := newTestParser(t, cfg)
+		if _, ok := p.(interface{ MoveTo(*ParserBuffer) error }); ok {
+			t.Errorf("parser %T has a MoveTo method", p)
+		}
+	}
+}
+
+func BenchmarkMoveBuffer(b *testing.B) {
+	var buf, dst ParserBuffer
+	if err := buf.Init(BufConfig{BufferSize: 8 * miB}); err != nil {
+		b.Fatalf("buf.Init error %s", err)
+	}
+	if err := dst.Init(buf.BufConfig); err != nil {
+		b.Fatalf("dst.Init error %s", err)
+	}
+	if _, err := buf.Write(make([]byte, 8*miB)); err != nil {
+		b.Fatalf("buf.Write error %s", err)
+	}
+	b.Run("MoveBuffer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := MoveBuffer(&dst, &buf); err != nil {
+				b.Fatalf("MoveBuffer(&dst, &buf) error %s", err)
+			}
+			if err := MoveBuffer(&buf, &dst); err != nil {
+				b.Fatalf("MoveBuffer(&buf, &dst) error %s", err)
+			}
+		}
+	})
+	b.Run("Clone", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dst = *buf.Clone()
+		}
+	})
+}
+
+func TestParserBufferReadByte(t *testing.T) {
+	var b ParserBuffer
+	if err := b.Init(BufConfig{WindowSize: 1024}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	const str = "foobarfoobar"
+	if _, err := b.Write([]byte(str)); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	if err := b.UnreadByte(); err == nil {
+		t.Fatalf("b.UnreadByte at position 0 returned no error")
+	}
+	var sb strings.Builder
+	for {
+		c, err := b.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("b.ReadByte error %s", err)
+			}
+			break
+		}
+		sb.WriteByte(c)
+	}
+	if g := sb.String(); g != str {
+		t.Fatalf("ReadByte returned %q; want %q", g, str)
+	}
+	if b.W != 0 {
+		t.Fatalf("b.W = %d after ReadByte; want 0", b.W)
+	}
+
+	if err := b.Reset([]byte(str)); err != nil {
+		t.Fatalf("b.Reset error %s", err)
+	}
+	if b.R != 0 {
+		t.Fatalf("b.R = %d after Reset; want 0", b.R)
+	}
+	for i := 0; i < len(str); i++ {
+		c, err := b.ReadByte()
+		if err != nil {
+			t.Fatalf("b.ReadByte error %s", err)
+		}
+		if err = b.UnreadByte(); err != nil {
+			t.Fatalf("b.UnreadByte error %s", err)
+		}
+		d, err := b.ReadByte()
+		if err != nil {
+			t.Fatalf("b.ReadByte error %s", err)
+		}
+		if c != d || c != str[i] {
+			t.Fatalf("ReadByte after UnreadByte returned %q;"+
+				" want %q", d, str[i])
+		}
+	}
+}
+
+func TestParserBufferNeedsShrink(t *testing.T) {
+	const file = "testdata/enwik7"
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", file, err)
+	}
+	var b ParserBuffer
+	cfg := BufConfig{
+		BufferSize: 4096,
+		WindowSize: 2048,
+		ShrinkSize: 1024,
+		BlockSize:  512,
+	}
+	if err = b.Init(cfg); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if g := b.Available(); g != cfg.BufferSize {
+		t.Fatalf("b.Available() = %d; want %d", g, cfg.BufferSize)
+	}
+	const chunk = 256
+	limit := cfg.BufferSize - cfg.ShrinkSize
+	for n := 0; n < cfg.BufferSize; n += chunk {
+		if g := b.Available() + len(b.Data); g != b.BufferSize {
+			t.Fatalf("Available() + len(Data) = %d; want %d",
+				g, b.BufferSize)
+		}
+		if b.NeedsShrink() != (n >= limit) {
+			t.Fatalf("NeedsShrink() = %t after %d bytes",
+				b.NeedsShrink(), n)
+		}
+		if _, err = b.Write(data[n : n+chunk]); err != nil {
+			t.Fatalf("b.Write error %s", err)
+		}
+		// simulate parsing
+		b.W = len(b.Data)
+	}
+	if b.Available() != 0 {
+		t.Fatalf("b.Available() = %d; want 0", b.Available())
+	}
+	b.Shrink()
+	if b.NeedsShrink() {
+		t.Fatalf("NeedsShrink() returns true after Shrink")
+	}
+	if g := b.Available(); g != cfg.BufferSize-cfg.ShrinkSize {
+		t.Fatalf("b.Available() = %d after Shrink; want %d", g,
+			cfg.BufferSize-cfg.ShrinkSize)
+	}
+}
+
+func TestParserBufferWriteString(t *testing.T) {
+	var b ParserBuffer
+	if err := b.Init(BufConfig{BufferSize: 16, WindowSize: 8}); err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	const str = "foobarfoobar"
+	n, err := b.WriteString(str)
+	if err != nil {
+		t.Fatalf("b.WriteString error %s", err)
+	}
+	if n != len(str) || !bytes.Equal(b.Data, []byte(str)) {
+		t.Fatalf("b.WriteString wrote %q; want %q", b.Data, str)
+	}
+	n, err = b.WriteString(str)
+	if err != ErrFullBuffer {
+		t.Fatalf("b.WriteString returned error %v; want %v", err,
+			ErrFullBuffer)
+	}
+	if want := str + str[:n]; string(b.Data) != want {
+		t.Fatalf("b.Data is %q; want %q", b.Data, want)
+	}
+}
+
+func BenchmarkParserBufferWriteString(b *testing.B) {
+	const str = "The quick brown fox jumps over the lazy dog."
+	var buf ParserBuffer
+	if err := buf.Init(BufConfig{BufferSize: 64 * kiB}); err != nil {
+		b.Fatalf("buf.Init error %s", err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(str)))
+	for i := 0; i < b.N; i++ {
+		if _, err := buf.WriteString(str); err != nil {
+			buf.Reset(nil)
+		}
+	}
+}
+
+func TestParserBufferDataSlice(t *testing.T) {
+	var b ParserBuffer
+	err := b.Init(BufConfig{WindowSize: 1024, BufferSize: 4096,
+		ShrinkSize: 1024, BlockSize: 512})
+	if err != nil {
+		t.Fatalf("b.Init error %s", err)
+	}
+	if p := b.DataSlice(); len(p) != 0 {
+		t.Fatalf("b.DataSlice() has length %d; want 0", len(p))
+	}
+	data := bytes.Repeat([]byte("abcdefgh"), 400)
+	if _, err = b.Write(data); err != nil {
+		t.Fatalf("b.Write error %s", err)
+	}
+	p := b.DataSlice()
+	if unsafe.SliceData(p) != unsafe.SliceData(b.Data) {
+		t.Fatalf("b.DataSlice() doesn't share memory with b.Data")
+	}
+	if len(p) != len(b.Data) {
+		t.Fatalf("len(b.DataSlice())=%d; want %d", len(p), len(b.Data))
+	}
+	tests := []struct{ w, start int }{
+		{0, 0},
+		{500, 0},
+		{1024, 0},
+		{3000, 3000 - 1024},
+	}
+	for _, tc := range tests {
+		b.W = tc.w
+		if g := b.WritePosition(); g != tc.w {
+			t.Fatalf("b.WritePosition()=%d; want %d", g, tc.w)
+		}
+		if g := b.WindowStart(); g != tc.start {
+			t.Fatalf("W=%d: b.WindowStart()=%d; want %d",
+				tc.w, g, tc.start)
+		}
+	}
+}
+
+func TestParserBufferWriteAt(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	cfg := &HPConfig{WindowSize: 32 * kiB, BufferSize: 96 * kiB,
+		BlockSize: 16 * kiB}
+	p := newTestParser(t, cfg)
+	wa, ok := p.(io.WriterAt)
+	if !ok {
+		t.Fatalf("parser %T doesn't support WriteAt", p)
+	}
+	if _, err = p.Write(data); err != nil {
+		t.Fatalf("p.Write error %s", err)
+	}
+	var blk Block
+	n, err := p.Parse(&blk, 0)
+	if err != nil {
+		t.Fatalf("p.Parse error %s", err)
+	}
+	blocks := []Block{blk}
+
+	want := bytes.Clone(data)
+	patch := []byte("The patched part of the buffer. The patched part.")
+	off := int64(n + 1000)
+	copy(want[off:], patch)
+	if k, err := wa.WriteAt(patch, off); err != nil || k != len(patch) {
+		t.Fatalf("WriteAt(patch, %d) returned %d, %v; want %d, nil",
+			off, k, err, len(patch))
+	}
+	if _, err = wa.WriteAt(patch, int64(n-1)); err != ErrOutOfBuffer {
+		t.Fatalf("WriteAt before window head error %v; want %v",
+			err, ErrOutOfBuffer)
+	}
+	if _, err = wa.WriteAt(patch, int64(len(data)+1)); err != ErrOutOfBuffer {
+		t.Fatalf("WriteAt behind buffer end error %v; want %v",
+			err, ErrOutOfBuffer)
+	}
+	if k, err := wa.WriteAt(nil, int64(len(data))); err != nil || k != 0 {
+		t.Fatalf("WriteAt(nil, end) returned %d, %v; want 0, nil",
+			k, err)
+	}
+	// Overlap the end of the buffer to extend it.
+	off = int64(len(data) - 10)
+	copy(want[off:], patch)
+	want = append(want, patch[10:]...)
+	if k, err := wa.WriteAt(patch, off); err != nil || k != len(patch) {
+		t.Fatalf("WriteAt(patch, %d) returned %d, %v; want %d, nil",
+			off, k, err, len(patch))
+	}
+
+	for {
+		var blk Block
+		if _, err = p.Parse(&blk, 0); err != nil {
+			if err == ErrEmptyBuffer {
+				break
+			}
+			t.Fatalf("p.Parse error %s", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	if g := decodeBlocks(t, blocks, cfg.WindowSize); !bytes.Equal(g, want) {
+		t.Fatalf("decoded data differs from patched data")
+	}
+
+	big := make([]byte, cfg.BufferSize)
+	k, err := wa.WriteAt(big, int64(len(want)))
+	if err != ErrFullBuffer {
+		t.Fatalf("WriteAt beyond BufferSize error %v; want %v",
+			err, ErrFullBuffer)
+	}
+	if k != cfg.BufferSize-len(want) {
+		t.Fatalf("WriteAt wrote %d bytes; want %d", k,
+			cfg.BufferSize-len(want))
+	}
+}
+
+func TestParserWriteAt(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	bc := BufConfig{WindowSize: 64 * kiB, BlockSize: 8 * kiB,
+		BufferSize: 64 * kiB}
+	for _, cfg := range []ParserConfig{
+		&HPConfig{}, &BHPConfig{}, &DHPConfig{}, &BDHPConfig{},
+		&THPConfig{}, &BUPConfig{}, &BBUPConfig{}, &GSAPConfig{},
+		&OSAPConfig{}, &NPConfig{},
+	} {
+		cfg.SetBufConfig(bc)
+		p := newTestParser(t, cfg)
+		wa, ok := p.(io.WriterAt)
+		if !ok {
+			t.Fatalf("parser %T doesn't support WriteAt", p)
+		}
+		if _, err = p.Write(data); err != nil {
+			t.Fatalf("%T.Write error %s", p, err)
+		}
+		var blk Block
+		if _, err = p.Parse(&blk, 0); err != nil {
+			t.Fatalf("%T.Parse error %s", p, err)
+		}
+		blocks := []Block{blk}
+
+		// Patch the buffer with data from the start of the file,
+		// which creates matches different from the original ones.
+		want := bytes.Clone(data)
+		const off = 40 * kiB
+		patch := data[:4*kiB]
+		copy(want[off:], patch)
+		if _, err = wa.WriteAt(patch, off); err != nil {
+			t.Fatalf("%T.WriteAt error %s", p, err)
+		}
+
+		for {
+			var blk Block
+			if _, err = p.Parse(&blk, 0); err != nil {
+				if err == ErrEmptyBuffer {
+					break
+				}
+				t.Fatalf("%T.Parse error %s", p, err)
+			}
+			blocks = append(blocks, blk)
+		}
+		g := decodeBlocks(t, blocks, bc.WindowSize)
+		if !bytes.Equal(g, want) {
+			t.Fatalf("%T: decoded data differs from patched data",
+				p)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"context"
+	"io"
+)
+
+// Pipeline connects a parser with a decoder. The data read from the source is
+// parsed into blocks, which are then decoded and written to the destination.
+// The pipeline is useful for testing and for applications that want to
+// encapsulate the full encode-decode cycle.
+type Pipeline struct {
+	parser  *WrappedParser
+	decoder Decoder
+
+	n      int64
+	blocks int64
+}
+
+// NewPipeline creates a new pipeline reading from src and writing to dst. The
+// parser is created from the parser configuration and the decoder uses the
+// window size of the parser configuration.
+func NewPipeline(dst io.Writer, src io.Reader, cfg ParserConfig) (*Pipeline,
+	error) {
+	cfg = cfg.Clone()
+	cfg.SetDefaults()
+	var err error
+	if err = cfg.Verify(); err != nil {
+		return nil, err
+	}
+	p, err := cfg.NewParser()
+	if err != nil {
+		return nil, err
+	}
+	pl := &Pipeline{parser: Wrap(src, p)}
+	dcfg := DecoderConfig{WindowSize: cfg.BufConfig().WindowSize}
+	if err = pl.decoder.Init(dst, dcfg); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// Run parses the source until io.EOF is reached and writes all blocks to the
+// decoder. At the end the decoder will be flushed. The context is checked
+// before each block is parsed.
+func (pl *Pipeline) Run(ctx context.Context) error {
+	var blk Block
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := pl.parser.Parse(&blk, 0)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if _, _, _, err = pl.decoder.WriteBlock(blk); err != nil {
+			return err
+		}
+		pl.n += int64(n)
+		pl.blocks++
+	}
+	return pl.decoder.Flush()
+}
+
+// Bytes returns the total number of bytes processed by the pipeline.
+func (pl *Pipeline) Bytes() int64 { return pl.n }
+
+// BlocksProcessed returns the number of blocks processed by the pipeline.
+func (pl *Pipeline) BlocksProcessed() int64 { return pl.blocks }
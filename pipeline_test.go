@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	h := sha256.New()
+	cfg := &HPConfig{WindowSize: 1 << 20, InputLen: 3}
+	pl, err := NewPipeline(h, bytes.NewReader(data), cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline error %s", err)
+	}
+	if err = pl.Run(context.Background()); err != nil {
+		t.Fatalf("pl.Run error %s", err)
+	}
+	if pl.Bytes() != int64(len(data)) {
+		t.Fatalf("pl.Bytes() returned %d; want %d", pl.Bytes(),
+			len(data))
+	}
+	if pl.BlocksProcessed() == 0 {
+		t.Fatalf("pl.BlocksProcessed() returned 0")
+	}
+	sum := sha256.Sum256(data)
+	if got := h.Sum(nil); !bytes.Equal(got, sum[:]) {
+		t.Fatalf("decoded hash %x; want %x", got, sum)
+	}
+}
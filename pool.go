@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ParserPool keeps parsers for reuse. It avoids the allocation of the buffer
+// and the search structures for applications creating many short-lived
+// parsers, for instance for the compression of requests in a web server. The
+// pool is backed by [sync.Pool], so unused parsers may be freed by the
+// garbage collector. The methods can be called concurrently.
+type ParserPool struct {
+	pool sync.Pool
+	pc   ParserConfig
+	cfg  Config
+}
+
+// pooledParser keeps a parser in the pool. The generation gen is
+// incremented by Get and by the release function, so it is odd while the
+// parser is in use. Each release function stores the generation of its Get
+// call and has only an effect as long as the generation hasn't changed.
+type pooledParser struct {
+	p   Parser
+	gen atomic.Uint64
+}
+
+// NewParserPool creates a pool of parsers selected by the configuration as
+// by [Config.NewParser]. A first parser is created to check the
+// configuration and put into the pool.
+func (cfg Config) NewParserPool(bc BufConfig) (*ParserPool, error) {
+	pc, err := cfg.ParserConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+	pp := &ParserPool{pc: pc, cfg: cfg}
+	q, err := pp.newParser()
+	if err != nil {
+		return nil, err
+	}
+	pp.pool.Put(q)
+	return pp, nil
+}
+
+// newParser creates a new pooled parser.
+func (pp *ParserPool) newParser() (*pooledParser, error) {
+	p, err := pp.pc.NewParser()
+	if err != nil {
+		return nil, err
+	}
+	return &pooledParser{p: pp.cfg.labelParser(p, pp.pc)}, nil
+}
+
+// Get returns a parser from the pool or creates a new one. The parser is in
+// its initial state. The release function resets the parser and returns it
+// to the pool; the parser must not be used after release has been called.
+// Calling release more than once has no effect, even if the parser has been
+// returned by another call of Get in the meantime.
+func (pp *ParserPool) Get() (p Parser, release func()) {
+	q, ok := pp.pool.Get().(*pooledParser)
+	if !ok {
+		var err error
+		if q, err = pp.newParser(); err != nil {
+			// The configuration has been checked by NewParserPool.
+			panic(fmt.Errorf("lz: can't create pooled parser: %w",
+				err))
+		}
+	}
+	g := q.gen.Add(1)
+	release = func() {
+		if !q.gen.CompareAndSwap(g, g+1) {
+			return
+		}
+		// Reset with a nil slice cannot fail.
+		q.p.Reset(nil)
+		pp.pool.Put(q)
+	}
+	return q.p, release
+}
+
+// ParserConfig returns the configuration of the parsers in the pool.
+func (pp *ParserPool) ParserConfig() ParserConfig {
+	return pp.pc.Clone()
+}
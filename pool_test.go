@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+)
+
+// decodeBuffered parses the data buffered by the parser and decodes it.
+func decodeBuffered(tb testing.TB, p Parser) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	d, err := NewDecoder(&buf, DecoderConfig{
+		WindowSize: p.BufferConfig().WindowSize})
+	if err != nil {
+		tb.Fatalf("NewDecoder error %s", err)
+	}
+	var blk Block
+	for {
+		_, err := p.Parse(&blk, 0)
+		if err != nil {
+			if err == ErrEmptyBuffer {
+				break
+			}
+			tb.Fatalf("p.Parse error %s", err)
+		}
+		if _, _, _, err = d.WriteBlock(blk); err != nil {
+			tb.Fatalf("d.WriteBlock error %s", err)
+		}
+	}
+	if err = d.Flush(); err != nil {
+		tb.Fatalf("d.Flush error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParserPool(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:100*kiB]
+	bc := BufConfig{WindowSize: 32 * kiB, BufferSize: 128 * kiB}
+	pp, err := Config{}.NewParserPool(bc)
+	if err != nil {
+		t.Fatalf("NewParserPool error %s", err)
+	}
+
+	// The sync.Pool may drop parsers at any time, particularly with
+	// the race detector enabled; so we try a few times.
+	reused := false
+	for i := 0; i < 20; i++ {
+		p, release1 := pp.Get()
+		if _, err = p.Write(data); err != nil {
+			t.Fatalf("p.Write error %s", err)
+		}
+		if g := decodeBuffered(t, p); !bytes.Equal(g, data) {
+			t.Fatalf("decoded data differs from input")
+		}
+		release1()
+		// second release must have no effect
+		release1()
+
+		q, release2 := pp.Get()
+		if g := decodeBuffered(t, q); len(g) != 0 {
+			t.Fatalf("parser from pool has %d bytes buffered",
+				len(g))
+		}
+		if q == p {
+			reused = true
+			// A stale release must not reset the parser of
+			// the new Get call.
+			if _, err = q.Write(data); err != nil {
+				t.Fatalf("q.Write error %s", err)
+			}
+			release1()
+			if g := decodeBuffered(t, q); !bytes.Equal(g, data) {
+				t.Fatalf("stale release reset the parser")
+			}
+		}
+		release2()
+		if reused {
+			break
+		}
+	}
+	if !reused {
+		t.Errorf("parser has never been reused")
+	}
+}
+
+func BenchmarkParserPool(b *testing.B) {
+	const goroutines = 8
+	data := bytes.Repeat([]byte("To be, or not to be, that is the question. "),
+		100)
+	bc := BufConfig{WindowSize: 32 * kiB, BufferSize: 64 * kiB}
+	pp, err := Config{Effort: 3}.NewParserPool(bc)
+	if err != nil {
+		b.Fatalf("NewParserPool error %s", err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		n := b.N / goroutines
+		if g < b.N%goroutines {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var blk Block
+			for i := 0; i < n; i++ {
+				p, release := pp.Get()
+				if _, err := p.Write(data); err != nil {
+					panic(err)
+				}
+				for {
+					if _, err := p.Parse(&blk, 0); err != nil {
+						if err == ErrEmptyBuffer {
+							break
+						}
+						panic(err)
+					}
+				}
+				release()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
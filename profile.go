@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"context"
+	"reflect"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+)
+
+// labeledParser executes Parse of the wrapped parser with pprof labels
+// attached, so that CPU profiles can be filtered by parser type and block
+// size. [Flush] calls Parse and is covered as well. The labeled parser
+// provides only the methods of the [Parser] interface; the optional methods of
+// the wrapped parser are available through Unwrap.
+type labeledParser struct {
+	Parser
+	labels pprof.LabelSet
+}
+
+// Unwrap returns the wrapped parser.
+func (p *labeledParser) Unwrap() Parser {
+	return p.Parser
+}
+
+// unwrapParser removes all wrappers providing an Unwrap method from p.
+func unwrapParser(p Parser) Parser {
+	for {
+		u, ok := p.(interface{ Unwrap() Parser })
+		if !ok {
+			return p
+		}
+		p = u.Unwrap()
+	}
+}
+
+// parserLabels returns the labels for parsers created by the parser
+// configuration. The parser label is the name of the configuration type
+// without the Config suffix, for instance "HP" or "OSAP".
+func parserLabels(pc ParserConfig) pprof.LabelSet {
+	t := reflect.TypeOf(pc)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := strings.TrimSuffix(t.Name(), "Config")
+	bs := strconv.Itoa(pc.BufConfig().BlockSize)
+	return pprof.Labels("parser", name, "block_size", bs)
+}
+
+// labelParser wraps p into a labeledParser if ProfilerLabels is set.
+// Otherwise p is returned unchanged.
+func (cfg Config) labelParser(p Parser, pc ParserConfig) Parser {
+	if !cfg.ProfilerLabels {
+		return p
+	}
+	return &labeledParser{Parser: p, labels: parserLabels(pc)}
+}
+
+// Parse calls Parse of the wrapped parser with the labels attached.
+func (p *labeledParser) Parse(blk *Block, flags int) (n int, err error) {
+	pprof.Do(context.Background(), p.labels, func(context.Context) {
+		n, err = p.Parser.Parse(blk, flags)
+	})
+	return n, err
+}
@@ -0,0 +1,260 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+// protoField is a field of a protocol buffer message. The value v is set
+// for the varint and fixed wire types, b for length-delimited fields.
+type protoField struct {
+	num  int
+	wire int
+	v    uint64
+	b    []byte
+}
+
+// protoFields splits the protocol buffer message p into its fields.
+func protoFields(p []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(p) > 0 {
+		key, k := binary.Uvarint(p)
+		if k <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		p = p[k:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.v, k = binary.Uvarint(p)
+			if k <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			p = p[k:]
+		case 1:
+			if len(p) < 8 {
+				return nil, errors.New("fixed64 truncated")
+			}
+			f.v, p = binary.LittleEndian.Uint64(p), p[8:]
+		case 2:
+			n, k := binary.Uvarint(p)
+			if k <= 0 || n > uint64(len(p)-k) {
+				return nil, errors.New("invalid length")
+			}
+			f.b, p = p[k:k+int(n)], p[k+int(n):]
+		case 5:
+			if len(p) < 4 {
+				return nil, errors.New("fixed32 truncated")
+			}
+			f.v = uint64(binary.LittleEndian.Uint32(p))
+			p = p[4:]
+		default:
+			return nil, errors.New("unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// sampleLabels decodes the gzipped profile in the profile.proto format and
+// returns the string labels of every sample. Only the fields required for
+// the labels are decoded.
+func sampleLabels(prof []byte) ([]map[string]string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(prof))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := protoFields(raw)
+	if err != nil {
+		return nil, err
+	}
+	// The string table might follow the samples, so we store the
+	// indexes of key and value first.
+	var (
+		strs    []string
+		samples [][][2]uint64
+	)
+	for _, f := range fields {
+		switch f.num {
+		case 2: // sample
+			sf, err := protoFields(f.b)
+			if err != nil {
+				return nil, err
+			}
+			var labels [][2]uint64
+			for _, g := range sf {
+				if g.num != 3 { // label
+					continue
+				}
+				lf, err := protoFields(g.b)
+				if err != nil {
+					return nil, err
+				}
+				var l [2]uint64
+				for _, h := range lf {
+					if h.num == 1 || h.num == 2 {
+						l[h.num-1] = h.v
+					}
+				}
+				labels = append(labels, l)
+			}
+			samples = append(samples, labels)
+		case 6: // string_table
+			strs = append(strs, string(f.b))
+		}
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			return ""
+		}
+		return strs[i]
+	}
+	result := make([]map[string]string, len(samples))
+	for i, labels := range samples {
+		m := make(map[string]string, len(labels))
+		for _, l := range labels {
+			m[str(l[0])] = str(l[1])
+		}
+		result[i] = m
+	}
+	return result, nil
+}
+
+func TestConfigProfilerLabels(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:miB]
+	bc := BufConfig{BlockSize: 48 * kiB}
+
+	p, err := Config{Effort: 3}.NewParser(bc)
+	if err != nil {
+		t.Fatalf("NewParser error %s", err)
+	}
+	if _, ok := p.(*labeledParser); ok {
+		t.Fatalf("parser without ProfilerLabels is labeled")
+	}
+
+	cfg := Config{Effort: 3, ProfilerLabels: true}
+	if p, err = cfg.NewParser(bc); err != nil {
+		t.Fatalf("NewParser error %s", err)
+	}
+	ctx := pprof.WithLabels(context.Background(),
+		p.(*labeledParser).labels)
+	if v, _ := pprof.Label(ctx, "parser"); v != "HP" {
+		t.Fatalf("parser label %q; want %q", v, "HP")
+	}
+
+	var prof bytes.Buffer
+	if err = pprof.StartCPUProfile(&prof); err != nil {
+		t.Skipf("pprof.StartCPUProfile error %s", err)
+	}
+	start := time.Now()
+	for time.Since(start) < 500*time.Millisecond {
+		if err = p.Reset(nil); err != nil {
+			t.Fatalf("p.Reset error %s", err)
+		}
+		blocks := parseAll(t, p, data)
+		if len(blocks) == 0 {
+			t.Fatalf("no blocks returned")
+		}
+	}
+	pprof.StopCPUProfile()
+
+	samples, err := sampleLabels(prof.Bytes())
+	if err != nil {
+		t.Fatalf("sampleLabels error %s", err)
+	}
+	labeled := 0
+	for _, m := range samples {
+		if m["parser"] == "" && m["block_size"] == "" {
+			continue
+		}
+		if m["parser"] != "HP" || m["block_size"] != "49152" {
+			t.Fatalf("sample labels %v; want parser=HP"+
+				" block_size=49152", m)
+		}
+		labeled++
+	}
+	if labeled == 0 {
+		t.Fatalf("none of the %d samples has the parser labels",
+			len(samples))
+	}
+	t.Logf("%d of %d samples labeled", labeled, len(samples))
+}
+
+func TestLabeledParserUnwrap(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	bc := BufConfig{WindowSize: 64 * kiB, BufferSize: 128 * kiB}
+	cfg := Config{Effort: 3, ProfilerLabels: true}
+	src, err := cfg.NewParser(bc)
+	if err != nil {
+		t.Fatalf("NewParser error %s", err)
+	}
+	u, ok := src.(interface{ Unwrap() Parser })
+	if !ok {
+		t.Fatalf("labeled parser %T has no Unwrap method", src)
+	}
+	if _, ok := u.Unwrap().(*hashParser); !ok {
+		t.Fatalf("Unwrap returned %T; want %T", u.Unwrap(),
+			&hashParser{})
+	}
+	var blk Block
+	if _, err = src.Write(data[:32*kiB]); err != nil {
+		t.Fatalf("src.Write error %s", err)
+	}
+	if _, err = Flush(src, &blk); err != nil {
+		t.Fatalf("Flush(src) error %s", err)
+	}
+
+	dst, err := cfg.NewParser(bc)
+	if err != nil {
+		t.Fatalf("NewParser error %s", err)
+	}
+	type stateCopier interface {
+		CopyState(src Parser) error
+	}
+	c, ok := unwrapParser(dst).(stateCopier)
+	if !ok {
+		t.Fatalf("%T doesn't support CopyState", unwrapParser(dst))
+	}
+	if err = c.CopyState(src); err != nil {
+		t.Fatalf("CopyState from labeled parser error %s", err)
+	}
+	var blocks [2]Block
+	for i, p := range []Parser{src, dst} {
+		if _, err = p.Write(data[32*kiB:]); err != nil {
+			t.Fatalf("%T.Write error %s", p, err)
+		}
+		if _, err = Flush(p, &blocks[i]); err != nil {
+			t.Fatalf("Flush error %s", err)
+		}
+	}
+	if !reflect.DeepEqual(blocks[0], blocks[1]) {
+		t.Fatalf("parser with copied state generates other sequences")
+	}
+}
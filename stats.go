@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+// ParserStats describes the blocks generated by a parser. The number of
+// parsed bytes is always the sum of match and literal bytes.
+type ParserStats struct {
+	// ParsedBytes is the number of bytes covered by the blocks.
+	ParsedBytes int
+	// Sequences is the number of sequences in the blocks.
+	Sequences int
+	// MatchBytes is the number of bytes covered by matches.
+	MatchBytes int
+	// LiteralBytes is the number of literal bytes.
+	LiteralBytes int
+}
+
+// statsAccumulator accumulates the statistics of the blocks generated by a
+// parser. It is embedded by all parsers of the package.
+type statsAccumulator struct {
+	stats ParserStats
+	// total accumulates the statistics since the last call of
+	// ResetMetrics. It is not cleared by ReadStats.
+	total ParserStats
+}
+
+// addBlock adds the block covering n bytes to the statistics.
+func (a *statsAccumulator) addBlock(n int, blk *Block) {
+	a.stats.ParsedBytes += n
+	a.stats.Sequences += len(blk.Sequences)
+	a.stats.LiteralBytes += len(blk.Literals)
+	a.stats.MatchBytes += n - len(blk.Literals)
+	a.total.ParsedBytes += n
+	a.total.Sequences += len(blk.Sequences)
+	a.total.LiteralBytes += len(blk.Literals)
+	a.total.MatchBytes += n - len(blk.Literals)
+}
+
+// ReadStats returns the statistics of the blocks generated by Parse and Flush
+// since the last call of ReadStats and clears them. Data skipped by calling
+// Parse without a block is not counted.
+func (a *statsAccumulator) ReadStats() ParserStats {
+	s := a.stats
+	a.stats = ParserStats{}
+	return s
+}
+
+// Metrics returns the performance data of the parser as key-value pairs. All
+// parsers provide the following keys:
+//
+//   - positions_scanned: the number of bytes returned by Parse and Flush
+//   - matches_emitted: the number of sequences generated
+//   - match_bytes: the number of bytes covered by matches
+//   - literal_bytes: the number of literal bytes
+//
+// Parsers may add their own keys. The values accumulate until ResetMetrics
+// is called.
+func (a *statsAccumulator) Metrics() map[string]int64 {
+	m := make(map[string]int64, 8)
+	a.addMetrics(m)
+	return m
+}
+
+// addMetrics puts the common metrics into m.
+func (a *statsAccumulator) addMetrics(m map[string]int64) {
+	m["positions_scanned"] = int64(a.total.ParsedBytes)
+	m["matches_emitted"] = int64(a.total.Sequences)
+	m["match_bytes"] = int64(a.total.MatchBytes)
+	m["literal_bytes"] = int64(a.total.LiteralBytes)
+}
+
+// ResetMetrics clears the counters reported by Metrics. The statistics
+// returned by ReadStats are not affected.
+func (a *statsAccumulator) ResetMetrics() {
+	a.total = ParserStats{}
+}
+
+// hashMetrics counts the lookups of the hash table during parsing. A hit is
+// a table entry storing the same input bytes as the current position; the
+// match may still be rejected because it is outside of the window.
+type hashMetrics struct {
+	hits   int64
+	misses int64
+}
+
+// addMetrics puts the keys hash_hits and hash_misses into m.
+func (h *hashMetrics) addMetrics(m map[string]int64) {
+	m["hash_hits"] = h.hits
+	m["hash_misses"] = h.misses
+}
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParserReadStats(t *testing.T) {
+	type statsParser interface {
+		Parser
+		ReadStats() ParserStats
+	}
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	for _, cfg := range []ParserConfig{
+		&HPConfig{BlockSize: 8 * kiB},
+		&BHPConfig{BlockSize: 8 * kiB},
+		&DHPConfig{BlockSize: 8 * kiB},
+		&BDHPConfig{BlockSize: 8 * kiB},
+		&BUPConfig{BlockSize: 8 * kiB},
+		&GSAPConfig{BlockSize: 8 * kiB},
+		&OSAPConfig{BlockSize: 8 * kiB},
+		&NPConfig{BlockSize: 8 * kiB},
+	} {
+		s, ok := newTestParser(t, cfg).(statsParser)
+		if !ok {
+			t.Fatalf("%T doesn't support ReadStats", cfg)
+		}
+		if err = s.Reset(data); err != nil {
+			t.Fatalf("s.Reset error %s", err)
+		}
+		// Skipped data must not be counted.
+		if _, err = s.Parse(nil, 0); err != nil {
+			t.Fatalf("s.Parse(nil, 0) error %s", err)
+		}
+		if st := s.ReadStats(); st != (ParserStats{}) {
+			t.Fatalf("%T: stats after skip %+v; want zeros", s, st)
+		}
+		var blk Block
+		for {
+			n, err := s.Parse(&blk, 0)
+			if err != nil {
+				if err == ErrEmptyBuffer {
+					break
+				}
+				t.Fatalf("s.Parse error %s", err)
+			}
+			st := s.ReadStats()
+			want := ParserStats{
+				ParsedBytes:  n,
+				Sequences:    len(blk.Sequences),
+				MatchBytes:   n - len(blk.Literals),
+				LiteralBytes: len(blk.Literals),
+			}
+			if st != want {
+				t.Fatalf("%T: ReadStats() = %+v; want %+v",
+					s, st, want)
+			}
+			if st.MatchBytes+st.LiteralBytes != st.ParsedBytes {
+				t.Fatalf("%T: MatchBytes=%d + LiteralBytes=%d"+
+					" != ParsedBytes=%d", s, st.MatchBytes,
+					st.LiteralBytes, st.ParsedBytes)
+			}
+			if st = s.ReadStats(); st != (ParserStats{}) {
+				t.Fatalf("%T: second ReadStats() = %+v;"+
+					" want zeros", s, st)
+			}
+		}
+	}
+}
+
+func TestParserMetrics(t *testing.T) {
+	type metricsParser interface {
+		Parser
+		Metrics() map[string]int64
+		ResetMetrics()
+	}
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:64*kiB]
+	common := []string{"positions_scanned", "matches_emitted",
+		"match_bytes", "literal_bytes"}
+	hash := []string{"hash_hits", "hash_misses"}
+	tests := []struct {
+		cfg  ParserConfig
+		keys []string
+	}{
+		{&HPConfig{BlockSize: 8 * kiB}, hash},
+		{&BHPConfig{BlockSize: 8 * kiB}, hash},
+		{&DHPConfig{BlockSize: 8 * kiB},
+			append(hash, "h1_hits", "h2_hits")},
+		{&BDHPConfig{BlockSize: 8 * kiB}, nil},
+		{&BUPConfig{BlockSize: 8 * kiB}, nil},
+		{&GSAPConfig{BlockSize: 8 * kiB}, nil},
+		{&OSAPConfig{BlockSize: 8 * kiB},
+			[]string{"edges_total", "edges_used", "sa_sorts"}},
+		{&NPConfig{BlockSize: 8 * kiB}, nil},
+	}
+	for _, tc := range tests {
+		s, ok := newTestParser(t, tc.cfg).(metricsParser)
+		if !ok {
+			t.Fatalf("%T doesn't support Metrics", tc.cfg)
+		}
+		if err = s.Reset(data); err != nil {
+			t.Fatalf("s.Reset error %s", err)
+		}
+		var blk Block
+		var total, sequences int64
+		for {
+			n, err := s.Parse(&blk, 0)
+			if err != nil {
+				if err == ErrEmptyBuffer {
+					break
+				}
+				t.Fatalf("s.Parse error %s", err)
+			}
+			total += int64(n)
+			sequences += int64(len(blk.Sequences))
+		}
+		m := s.Metrics()
+		keys := append(common[:len(common):len(common)], tc.keys...)
+		for _, k := range keys {
+			if _, ok := m[k]; !ok {
+				t.Fatalf("%T: Metrics() has no key %q", s, k)
+			}
+		}
+		if len(m) != len(keys) {
+			t.Fatalf("%T: Metrics() has %d keys; want %d",
+				s, len(m), len(keys))
+		}
+		if g := m["positions_scanned"]; g != total {
+			t.Fatalf("%T: positions_scanned=%d; want %d",
+				s, g, total)
+		}
+		if g := m["matches_emitted"]; g != sequences {
+			t.Fatalf("%T: matches_emitted=%d; want %d",
+				s, g, sequences)
+		}
+		if g := m["match_bytes"] + m["literal_bytes"]; g != total {
+			t.Fatalf("%T: match_bytes + literal_bytes = %d; want %d",
+				s, g, total)
+		}
+		if _, ok := m["hash_hits"]; ok && m["hash_hits"] == 0 {
+			t.Fatalf("%T: hash_hits is zero", s)
+		}
+		if _, ok := m["h1_hits"]; ok {
+			if m["h1_hits"]+m["h2_hits"] != m["hash_hits"] {
+				t.Fatalf("%T: h1_hits=%d + h2_hits=%d"+
+					" != hash_hits=%d", s, m["h1_hits"],
+					m["h2_hits"], m["hash_hits"])
+			}
+		}
+		if _, ok := m["sa_sorts"]; ok {
+			if m["sa_sorts"] == 0 || m["edges_total"] == 0 {
+				t.Fatalf("%T: metrics %v; want sa_sorts and"+
+					" edges_total to be positive", s, m)
+			}
+			if m["edges_used"] != sequences {
+				t.Fatalf("%T: edges_used=%d; want %d",
+					s, m["edges_used"], sequences)
+			}
+		}
+		s.ResetMetrics()
+		for k, v := range s.Metrics() {
+			if v != 0 {
+				t.Fatalf("%T: %s=%d after ResetMetrics",
+					s, k, v)
+			}
+		}
+	}
+}
@@ -5,13 +5,15 @@
 // Package suffix provides a suffix sort algorithm.
 //
 // It is based on the DivSufSort algorithm as described in the
-// [Dismantling DivSufSort] paper.
+// [Dismantling DivSufSort] paper. The SA-IS algorithm is provided as an
+// alternative by [SortSAIS].
 //
 // [DivSufSort]: https://arxiv.org/pdf/1710.01896.pdf
 package suffix
 
 import (
 	"fmt"
+	"math/bits"
 	"testing"
 )
 
@@ -28,8 +30,40 @@ func Sort(t []byte, sa []int32) {
 	}.sort(t, sa)
 }
 
-// sort computes the suffix array using the A, B and B* types.
+// Workspace holds the memory required by the suffix sort beyond the suffix
+// array. It can be reused by consecutive calls of [SortWithWorkspace]. A
+// workspace must not be used by multiple goroutines at the same time.
+type Workspace struct {
+	buckets [sigma * sigma]int32
+	stack   stack
+}
+
+// NewWorkspace allocates a workspace for inputs up to maxN bytes. Larger
+// inputs are supported; the workspace will be extended as required.
+func NewWorkspace(maxN int) *Workspace {
+	ws := new(Workspace)
+	if maxN > 1 {
+		ws.stack = make(stack, 0, max(96, 4*bits.Len(uint(maxN))))
+	}
+	return ws
+}
+
+// SortWithWorkspace computes the suffix array like [Sort] but uses the
+// workspace ws instead of allocating the temporary memory for each call. The
+// slice sa must have the same length as t.
+func SortWithWorkspace(t []byte, sa []int32, ws *Workspace) {
+	config{
+		sizeThreshold: 7,
+	}.sortWorkspace(t, sa, ws)
+}
+
+// sort computes the suffix array using a new workspace.
 func (cfg config) sort(t []byte, sa []int32) {
+	cfg.sortWorkspace(t, sa, new(Workspace))
+}
+
+// sortWorkspace computes the suffix array using the A, B and B* types.
+func (cfg config) sortWorkspace(t []byte, sa []int32, ws *Workspace) {
 	// Look for a simple error.
 	if len(t) != len(sa) {
 		panic(fmt.Errorf("len(t)=%d is different from len(sa)=%d",
@@ -54,7 +88,7 @@ func (cfg config) sort(t []byte, sa []int32) {
 
 	// Count the A, B and B* types. We are also computing the B* positions.
 	var aBuckets [256]int32
-	bBuckets, bStarBuckets := newBBucketsPair()
+	bBuckets, bStarBuckets := ws.bBucketsPair()
 
 	var c0, c1 int
 	i := int32(len(t) - 1)
@@ -181,7 +215,7 @@ scanLoop:
 		// fmt.Printf("#1 isa=%d\n", isa)
 
 		// sort B* suffixes using the ranks
-		cfg.trSort(sa[:m], isa)
+		cfg.trSortStack(sa[:m], isa, &ws.stack)
 
 		// fmt.Printf("#2 sa[:m=%d]=%d\n", m, sa[:m])
 		// fmt.Printf("#2 isa=%d\n", isa)
@@ -343,11 +377,11 @@ func (b *bStarBuckets) dec(c0, c1 int) int32 {
 	return b.a[k]
 }
 
-func newBBucketsPair() (b *bBuckets, bStar *bStarBuckets) {
-	var a [sigma * sigma]int32
-	b = &bBuckets{a: &a}
-	bStar = &bStarBuckets{a: &a}
-	return b, bStar
+// bBucketsPair clears the bucket array of the workspace and returns the views
+// for the B and B* buckets.
+func (ws *Workspace) bBucketsPair() (b bBuckets, bStar bStarBuckets) {
+	clear(ws.buckets[:])
+	return bBuckets{a: &ws.buckets}, bStarBuckets{a: &ws.buckets}
 }
 
 // The functions below allow verifications for certain points in the sort
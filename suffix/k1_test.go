@@ -335,3 +335,52 @@ func TestVerifySuffixArray(t *testing.T) {
 		}
 	}
 }
+
+func TestSortWithWorkspace(t *testing.T) {
+	data, err := getData(testFile)
+	if err != nil {
+		t.Fatalf("getData(%q) error %s", testFile, err)
+	}
+	// A small workspace must be extended for the larger inputs.
+	ws := NewWorkspace(16)
+	for _, n := range []int{0, 1, 2, 10, 1000, len(data)} {
+		p := data[:n]
+		want := make([]int32, n)
+		Sort(p, want)
+		for i := 0; i < 2; i++ {
+			sa := make([]int32, n)
+			SortWithWorkspace(p, sa, ws)
+			for j := range sa {
+				if sa[j] != want[j] {
+					t.Fatalf("n=%d: sa[%d]=%d; want %d",
+						n, j, sa[j], want[j])
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSortWithWorkspace(b *testing.B) {
+	data, err := getData(testFile)
+	if err != nil {
+		b.Fatalf("getData(%q) error %s", testFile, err)
+	}
+	sa := make([]int32, len(data))
+	b.Run("Sort", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			Sort(data, sa)
+		}
+	})
+	b.Run("Workspace", func(b *testing.B) {
+		ws := NewWorkspace(len(data))
+		SortWithWorkspace(data, sa, ws)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			SortWithWorkspace(data, sa, ws)
+		}
+	})
+}
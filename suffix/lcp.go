@@ -10,26 +10,6 @@ import (
 	"math/bits"
 )
 
-// _lcp provides actual functionality without the error checks.
-//
-// The algorithm used uses the phi function and the theorem regarding it.
-func _lcp(t []byte, sa []int32, sainv []int32, lcp []int32) {
-	l := int32(0)
-	for i, k := range sainv {
-		if k == 0 {
-			lcp[0] = 0
-			l = 0
-			continue
-		}
-		j := sa[k-1] // j = phi(i)
-		l += int32(matchLen(t[int32(i)+l:], t[j+l:]))
-		lcp[k] = l
-		if l > 0 {
-			l--
-		}
-	}
-}
-
 // InvertSA computes the inverse of the suffix array.
 func InvertSA(sa, sainv []int32) {
 	if len(sa) != len(sainv) {
@@ -41,9 +21,18 @@ func InvertSA(sa, sainv []int32) {
 	}
 }
 
-// LCP computes the LCP table for t. If sa and sainv are nil, they will be
-// temporarily computed.
-func LCP(t []byte, sa, sainv, lcp []int32) {
+// LCP computes the LCP array for t. The value lcp[i] is the length of the
+// common prefix of the suffixes sa[i-1] and sa[i]; lcp[0] is zero. The
+// function computes the permuted LCP array with the Phi algorithm of
+// Kärkkäinen, Manzini and Puglisi, which accesses the text sequentially, and
+// converts it into suffix array order. The computation requires linear time.
+//
+// If sa doesn't have the length of t, the suffix array will be temporarily
+// computed. The slice scratch is used to store the phi array, which is
+// replaced by the PLCP array. If its capacity is smaller than len(t), which
+// is always the case for nil, a temporary slice will be allocated. The slice
+// lcp must have the length of t.
+func LCP(t []byte, sa, scratch, lcp []int32) {
 	if len(t) > math.MaxInt32 {
 		panic(fmt.Errorf("suffix: len(t)=%d > MaxInt32", len(t)))
 	}
@@ -51,16 +40,95 @@ func LCP(t []byte, sa, sainv, lcp []int32) {
 		sa = make([]int32, len(t))
 		Sort(t, sa)
 	}
-	if len(sainv) != len(sa) {
-		sainv = make([]int32, len(sa))
-		InvertSA(sa, sainv)
-	}
 	if len(lcp) != len(t) {
 		panic(fmt.Errorf("suffix: len(lcp)=%d != len(t)=%d",
 			len(lcp), len(t)))
 	}
+	var plcp []int32
+	if cap(scratch) >= len(t) {
+		plcp = scratch[:len(t)]
+	} else {
+		plcp = make([]int32, len(t))
+	}
+	// The PLCP array is computed in place of the phi array.
+	_phi(sa, plcp)
+	_plcp(t, plcp, plcp)
+	toLCP(plcp, sa, lcp)
+}
+
+// Phi computes the phi array for the suffix array. The value phi[sa[i]] is
+// the predecessor sa[i-1] of the suffix sa[i] in suffix array order. The
+// first suffix has no predecessor and phi[sa[0]] is -1.
+func Phi(sa []int32) []int32 {
+	phi := make([]int32, len(sa))
+	_phi(sa, phi)
+	return phi
+}
+
+// _phi computes the phi array into phi, which must have the length of sa.
+func _phi(sa, phi []int32) {
+	prev := int32(-1)
+	for _, i := range sa {
+		phi[i] = prev
+		prev = i
+	}
+}
 
-	_lcp(t, sa, sainv, lcp)
+// _plcp computes the PLCP array without the error checks. The slices phi and
+// plcp may be the same.
+func _plcp(t []byte, phi, plcp []int32) {
+	l := int32(0)
+	for i, j := range phi {
+		if j < 0 {
+			plcp[i] = 0
+			l = 0
+			continue
+		}
+		l += int32(matchLen(t[int32(i)+l:], t[j+l:]))
+		plcp[i] = l
+		if l > 0 {
+			l--
+		}
+	}
+}
+
+// PLCP computes the permuted LCP array for t. The value plcp[sa[i]] is the
+// length of the common prefix of the suffixes sa[i] and sa[i-1]. The array is
+// computed in text order in linear time using the fact that plcp[i+1] >=
+// plcp[i]-1. The phi array will be computed if it is nil.
+func PLCP(t []byte, sa, phi []int32) []int32 {
+	if len(sa) != len(t) {
+		panic(fmt.Errorf("suffix: len(sa)=%d != len(t)=%d",
+			len(sa), len(t)))
+	}
+	if phi == nil {
+		phi = Phi(sa)
+	} else if len(phi) != len(sa) {
+		panic(fmt.Errorf("suffix: len(phi)=%d != len(sa)=%d",
+			len(phi), len(sa)))
+	}
+	plcp := make([]int32, len(phi))
+	_plcp(t, phi, plcp)
+	return plcp
+}
+
+// toLCP converts the PLCP array into lcp without the error checks.
+func toLCP(plcp, sa, lcp []int32) {
+	for k, i := range sa {
+		lcp[k] = plcp[i]
+	}
+}
+
+// ToLCP converts the PLCP array into the LCP array, which is in suffix array
+// order.
+func ToLCP(plcp []int32, sa []int32) []int32 {
+	if len(plcp) != len(sa) {
+		panic(fmt.Errorf("suffix: len(plcp)=%d != len(sa)=%d",
+			len(plcp), len(sa)))
+	}
+	lcp := make([]int32, len(sa))
+	toLCP(plcp, sa, lcp)
+	return lcp
 }
 
 // matchLen computes the length of the common prefix between p and q.
@@ -6,6 +6,8 @@ package suffix
 
 import (
 	"bytes"
+	"math/rand"
+	"sort"
 	"testing"
 )
 
@@ -44,3 +46,139 @@ func FuzzLCP(f *testing.F) {
 		}
 	})
 }
+
+// naiveLCP computes the suffix array and the LCP array for t in O(n²) time
+// by sorting the suffixes with bytes.Compare and comparing neighbors byte by
+// byte.
+func naiveLCP(t []byte) (sa, lcp []int32) {
+	sa = make([]int32, len(t))
+	for i := range sa {
+		sa[i] = int32(i)
+	}
+	sort.Slice(sa, func(i, j int) bool {
+		return bytes.Compare(t[sa[i]:], t[sa[j]:]) < 0
+	})
+	lcp = make([]int32, len(t))
+	for i := 1; i < len(sa); i++ {
+		p, q := t[sa[i-1]:], t[sa[i]:]
+		n := 0
+		for n < len(p) && n < len(q) && p[n] == q[n] {
+			n++
+		}
+		lcp[i] = int32(n)
+	}
+	return sa, lcp
+}
+
+func TestLCP(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tests := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("banana"),
+		[]byte("mississippi"),
+		[]byte("aaaaaaaaaaaaaaaaaaaa"),
+		[]byte("abababababababababab"),
+	}
+	for _, n := range []int{10, 100, 1000} {
+		p := make([]byte, n)
+		for i := range p {
+			p[i] = 'a' + byte(r.Intn(3))
+		}
+		tests = append(tests, p)
+	}
+	for _, p := range tests {
+		wantSA, want := naiveLCP(p)
+		sa := make([]int32, len(p))
+		Sort(p, sa)
+		if !equalInt32s(sa, wantSA) {
+			t.Fatalf("Sort(%q) differs from naive suffix array",
+				shorter(p))
+		}
+		scratches := map[string][]int32{
+			"nil":   nil,
+			"short": make([]int32, len(p)/2),
+			"exact": make([]int32, len(p)),
+			"large": make([]int32, 2*len(p)+1)[:0],
+		}
+		for name, scratch := range scratches {
+			lcp := make([]int32, len(p))
+			LCP(p, sa, scratch, lcp)
+			if !equalInt32s(lcp, want) {
+				t.Fatalf("LCP(%q) with %s scratch differs"+
+					" from naive LCP", shorter(p), name)
+			}
+		}
+		lcp := make([]int32, len(p))
+		LCP(p, nil, nil, lcp)
+		if !equalInt32s(lcp, want) {
+			t.Fatalf("LCP(%q) without sa differs from naive LCP",
+				shorter(p))
+		}
+	}
+}
+
+func TestPhi(t *testing.T) {
+	p := []byte("banana")
+	sa := make([]int32, len(p))
+	Sort(p, sa)
+	// sa = [5 3 1 0 4 2] for a, ana, anana, banana, na, nana
+	phi := Phi(sa)
+	want := []int32{1, 3, 4, 5, 0, -1}
+	if !equalInt32s(phi, want) {
+		t.Fatalf("Phi(%v) = %v; want %v", sa, phi, want)
+	}
+}
+
+// kasaiLCP computes the LCP array with the algorithm of Kasai et al., which
+// uses the inverse suffix array. It provides a reference for LCP, which uses
+// the PLCP array.
+func kasaiLCP(t []byte, sa []int32) []int32 {
+	sainv := make([]int32, len(sa))
+	InvertSA(sa, sainv)
+	lcp := make([]int32, len(sa))
+	l := int32(0)
+	for i, k := range sainv {
+		if k == 0 {
+			l = 0
+			continue
+		}
+		j := sa[k-1]
+		l += int32(matchLen(t[int32(i)+l:], t[j+l:]))
+		lcp[k] = l
+		if l > 0 {
+			l--
+		}
+	}
+	return lcp
+}
+
+func TestPLCP(t *testing.T) {
+	data, err := getData(testFile)
+	if err != nil {
+		t.Fatalf("getData(%q) error %s", testFile, err)
+	}
+	tests := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("banana"),
+		[]byte("aaaaaaaaaaaaaaaaaaaa"),
+		data,
+	}
+	for _, p := range tests {
+		sa := make([]int32, len(p))
+		Sort(p, sa)
+		want := kasaiLCP(p, sa)
+
+		plcp := PLCP(p, sa, Phi(sa))
+		if g := ToLCP(plcp, sa); !equalInt32s(g, want) {
+			t.Fatalf("ToLCP(PLCP(%q)) differs from Kasai LCP",
+				shorter(p))
+		}
+		lcp := make([]int32, len(p))
+		LCP(p, sa, nil, lcp)
+		if !equalInt32s(lcp, want) {
+			t.Fatalf("LCP(%q) differs from Kasai LCP", shorter(p))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+// LCS finds the longest common substring of t1 and t2. It returns the start
+// positions of the substring in both texts and its length. If there is no
+// common substring, all return values are zero.
+//
+// The function computes the suffix array of the generalized text t1 + sep +
+// t2, where sep is a separator outside of the byte alphabet, and looks for
+// the longest common prefix of neighboring suffixes from different texts. It
+// runs in linear time.
+func LCS(t1, t2 []byte) (offset1, offset2, length int) {
+	n1 := len(t1)
+	n := n1 + 1 + len(t2)
+	t := make([]int32, n)
+	for i, c := range t1 {
+		t[i] = int32(c)
+	}
+	t[n1] = sigma
+	for i, c := range t2 {
+		t[n1+1+i] = int32(c)
+	}
+	sa := make([]int32, n)
+	sais(t, sa, sigma+1)
+
+	// Compute the LCP of neighboring suffixes using the algorithm of
+	// Kasai et al.
+	rank := make([]int32, n)
+	for r, i := range sa {
+		rank[i] = int32(r)
+	}
+	h := 0
+	for i := 0; i < n; i++ {
+		r := rank[i]
+		if r == 0 {
+			h = 0
+			continue
+		}
+		j := int(sa[r-1])
+		for i+h < n && j+h < n && t[i+h] == t[j+h] {
+			h++
+		}
+		// The separator is unique, so the common prefix cannot
+		// cross it.
+		if h > length && (i < n1) != (j < n1) {
+			if i < n1 {
+				offset1, offset2 = i, j-n1-1
+			} else {
+				offset1, offset2 = j, i-n1-1
+			}
+			length = h
+		}
+		if h > 0 {
+			h--
+		}
+	}
+	return offset1, offset2, length
+}
@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// naiveLCS computes the length of the longest common substring in O(n²)
+// time.
+func naiveLCS(t1, t2 []byte) int {
+	length := 0
+	prev := make([]int, len(t2)+1)
+	cur := make([]int, len(t2)+1)
+	for i := range t1 {
+		for j := range t2 {
+			if t1[i] == t2[j] {
+				cur[j+1] = prev[j] + 1
+				length = max(length, cur[j+1])
+			} else {
+				cur[j+1] = 0
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return length
+}
+
+func checkLCS(t *testing.T, t1, t2 []byte) {
+	t.Helper()
+	o1, o2, n := LCS(t1, t2)
+	if want := naiveLCS(t1, t2); n != want {
+		t.Fatalf("LCS(%q, %q) length %d; want %d", t1, t2, n, want)
+	}
+	if !bytes.Equal(t1[o1:o1+n], t2[o2:o2+n]) {
+		t.Fatalf("LCS(%q, %q) returned %d, %d, %d; substrings differ",
+			t1, t2, o1, o2, n)
+	}
+}
+
+func TestLCS(t *testing.T) {
+	tests := []struct {
+		t1, t2 string
+		want   string
+	}{
+		{"", "", ""},
+		{"abc", "", ""},
+		{"abc", "xyz", ""},
+		{"xabcdy", "zzabcd", "abcd"},
+		{"banana", "ananas", "anana"},
+		{"mississippi", "sippi", "sippi"},
+		{"\xff\x00\xff", "\x00\xff\xff", "\x00\xff"},
+	}
+	for _, tc := range tests {
+		t1, t2 := []byte(tc.t1), []byte(tc.t2)
+		o1, o2, n := LCS(t1, t2)
+		if n != len(tc.want) {
+			t.Fatalf("LCS(%q, %q) length %d; want %d",
+				tc.t1, tc.t2, n, len(tc.want))
+		}
+		if g := tc.t1[o1 : o1+n]; g != tc.want {
+			t.Fatalf("LCS(%q, %q) found %q; want %q",
+				tc.t1, tc.t2, g, tc.want)
+		}
+		if g := tc.t2[o2 : o2+n]; g != tc.want {
+			t.Fatalf("LCS(%q, %q) found %q in t2; want %q",
+				tc.t1, tc.t2, g, tc.want)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		t1 := make([]byte, r.Intn(200))
+		t2 := make([]byte, r.Intn(200))
+		for j := range t1 {
+			t1[j] = "abc"[r.Intn(3)]
+		}
+		for j := range t2 {
+			t2[j] = "abc"[r.Intn(3)]
+		}
+		checkLCS(t, t1, t2)
+	}
+}
+
+func FuzzLCS(f *testing.F) {
+	f.Add([]byte("banana"), []byte("ananas"))
+	f.Fuzz(func(t *testing.T, t1, t2 []byte) {
+		checkLCS(t, t1, t2)
+	})
+}
+
+func BenchmarkLCS(b *testing.B) {
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", testFile, err)
+	}
+	t1, t2 := data[:1<<20], data[1<<20:2<<20]
+	b.SetBytes(int64(len(t1) + len(t2)))
+	for i := 0; i < b.N; i++ {
+		LCS(t1, t2)
+	}
+}
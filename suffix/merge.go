@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// Merge concatenates the texts t1 and t2 and computes the suffix array of the
+// concatenation from the suffix arrays sa1 and sa2 of the two texts.
+//
+// The suffixes of t2 keep their order, but the order of two suffixes of t1
+// may change if one of them is a prefix of the other. Merge resorts the
+// suffixes of t1 starting from the order of sa1 and merges them with the
+// shifted suffixes of t2 by comparing the suffixes directly. The worst case
+// run time is quadratic; the function is intended for texts without long
+// repetitions.
+func Merge(t1 []byte, sa1 []int32, t2 []byte, sa2 []int32,
+) (t []byte, sa []int32) {
+	if len(t1) != len(sa1) {
+		panic(fmt.Errorf("suffix: len(t1)=%d != len(sa1)=%d",
+			len(t1), len(sa1)))
+	}
+	if len(t2) != len(sa2) {
+		panic(fmt.Errorf("suffix: len(t2)=%d != len(sa2)=%d",
+			len(t2), len(sa2)))
+	}
+	n1 := len(t1)
+	t = make([]byte, 0, n1+len(t2))
+	t = append(t, t1...)
+	t = append(t, t2...)
+
+	cmp := func(i, j int32) int { return bytes.Compare(t[i:], t[j:]) }
+
+	// The order of sa1 is only violated by suffixes that are prefixes of
+	// other suffixes of t1, so sa1 is already nearly sorted.
+	a := slices.Clone(sa1)
+	slices.SortStableFunc(a, cmp)
+
+	sa = make([]int32, 0, len(t))
+	i, j := 0, 0
+	for i < len(a) && j < len(sa2) {
+		k := sa2[j] + int32(n1)
+		if cmp(a[i], k) < 0 {
+			sa = append(sa, a[i])
+			i++
+		} else {
+			sa = append(sa, k)
+			j++
+		}
+	}
+	sa = append(sa, a[i:]...)
+	for _, k := range sa2[j:] {
+		sa = append(sa, k+int32(n1))
+	}
+	return t, sa
+}
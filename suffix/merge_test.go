@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	enwik6, err := getData(testFile)
+	if err != nil {
+		t.Fatalf("getData error %s", err)
+	}
+	tests := []struct{ t1, t2 string }{
+		{"", ""},
+		{"", "banana"},
+		{"banana", ""},
+		{"ab", "abab"},
+		{"aaaa", "aaab"},
+		{"mississippi", "missouri"},
+		{"abba", "abbaab"},
+		{string(enwik6[:4096]), string(enwik6[4096:10000])},
+	}
+	for _, tc := range tests {
+		t1, t2 := []byte(tc.t1), []byte(tc.t2)
+		sa1 := make([]int32, len(t1))
+		Sort(t1, sa1)
+		sa2 := make([]int32, len(t2))
+		Sort(t2, sa2)
+		tm, sa := Merge(t1, sa1, t2, sa2)
+		if !bytes.Equal(tm, append(t1, t2...)) {
+			t.Fatalf("Merge returned text %q; want %q", shorter(tm),
+				shorter(append(t1, t2...)))
+		}
+		if err = verifySuffixArray(tm, sa); err != nil {
+			t.Fatalf("Merge(%q, %q): %s", shorter(t1), shorter(t2),
+				err)
+		}
+
+		// Check that all positions of the input arrays are present.
+		positions := make([]int32, 0, len(sa))
+		positions = append(positions, sa1...)
+		for _, k := range sa2 {
+			positions = append(positions, k+int32(len(t1)))
+		}
+		slices.Sort(positions)
+		s := slices.Clone(sa)
+		slices.Sort(s)
+		if !slices.Equal(s, positions) {
+			t.Fatalf("Merge(%q, %q): positions don't match input",
+				shorter(t1), shorter(t2))
+		}
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	data, err := getData(testFile)
+	if err != nil {
+		b.Fatalf("getData error %s", err)
+	}
+	data = data[:256<<10]
+	t1, t2 := data[:len(data)/2], data[len(data)/2:]
+	b.Run("Merge", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		sa1 := make([]int32, len(t1))
+		sa2 := make([]int32, len(t2))
+		for i := 0; i < b.N; i++ {
+			Sort(t1, sa1)
+			Sort(t2, sa2)
+			Merge(t1, sa1, t2, sa2)
+		}
+	})
+	b.Run("Sort", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		sa := make([]int32, len(data))
+		for i := 0; i < b.N; i++ {
+			Sort(data, sa)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// RMQ supports range minimum queries over an LCP array in constant time. It
+// uses a sparse table requiring O(n log n) space.
+type RMQ struct {
+	// table[k][i] is the minimum of lcp[i:i+2^k].
+	table [][]int32
+}
+
+// BuildRMQ creates the sparse table for the LCP array in O(n log n) time. The
+// slice lcp is used as first level of the table and must not be modified
+// while the RMQ is in use.
+func BuildRMQ(lcp []int32) *RMQ {
+	n := len(lcp)
+	r := &RMQ{table: make([][]int32, 1, bits.Len(uint(n))+1)}
+	r.table[0] = lcp
+	for w := 1; 2*w <= n; w *= 2 {
+		prev := r.table[len(r.table)-1]
+		t := make([]int32, n-2*w+1)
+		for i := range t {
+			a, b := prev[i], prev[i+w]
+			if b < a {
+				a = b
+			}
+			t[i] = a
+		}
+		r.table = append(r.table, t)
+	}
+	return r
+}
+
+// Query returns the minimum of lcp[i:j+1]. The method panics if i > j or the
+// indexes are out of range.
+func (r *RMQ) Query(i, j int) int32 {
+	if !(0 <= i && i <= j && j < len(r.table[0])) {
+		panic(fmt.Errorf("suffix: RMQ query [%d,%d] out of range", i, j))
+	}
+	k := bits.Len(uint(j-i+1)) - 1
+	t := r.table[k]
+	a, b := t[i], t[j+1-1<<k]
+	if b < a {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomLCP(r *rand.Rand, n int) []int32 {
+	p := make([]byte, n)
+	for i := range p {
+		// small alphabet to get long common prefixes
+		p[i] = 'a' + byte(r.Intn(3))
+	}
+	sa := make([]int32, n)
+	Sort(p, sa)
+	lcp := make([]int32, n)
+	LCP(p, sa, nil, lcp)
+	return lcp
+}
+
+func TestRMQ(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 3, 7, 8, 9, 64, 200} {
+		lcp := randomLCP(r, n)
+		rmq := BuildRMQ(lcp)
+		for i := range lcp {
+			m := lcp[i]
+			for j := i; j < len(lcp); j++ {
+				if lcp[j] < m {
+					m = lcp[j]
+				}
+				if g := rmq.Query(i, j); g != m {
+					t.Fatalf("n=%d: Query(%d, %d) = %d; want %d",
+						n, i, j, g, m)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkRMQ(b *testing.B) {
+	lcp := randomLCP(rand.New(rand.NewSource(1)), 1<<16)
+	i, j := 0, len(lcp)-1
+	b.Run("Query", func(b *testing.B) {
+		rmq := BuildRMQ(lcp)
+		b.ResetTimer()
+		for k := 0; k < b.N; k++ {
+			rmq.Query(i, j)
+		}
+	})
+	b.Run("Scan", func(b *testing.B) {
+		for k := 0; k < b.N; k++ {
+			m := lcp[i]
+			for _, l := range lcp[i+1 : j+1] {
+				if l < m {
+					m = l
+				}
+			}
+		}
+	})
+}
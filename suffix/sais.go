@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import "fmt"
+
+// SortSAIS computes the suffix array using the SA-IS algorithm of Nong, Zhang
+// and Chan (2009). The result is the same as the one of [Sort]. The slice sa
+// must have the same length as t.
+//
+// The algorithm runs in linear time. Beside the suffix array it requires a
+// bit vector for the suffix types and the bucket arrays for the alphabet of
+// each recursion level. The reduced problem is solved inside the suffix array.
+func SortSAIS(t []byte, sa []int32) {
+	if len(t) != len(sa) {
+		panic(fmt.Errorf("len(t)=%d is different from len(sa)=%d",
+			len(t), len(sa)))
+	}
+	sais(t, sa, sigma)
+}
+
+// saisTypes stores a bit for each position of the text, which is set for the
+// S-type suffixes.
+type saisTypes []uint64
+
+func newSAISTypes(n int) saisTypes {
+	return make(saisTypes, (n+63)/64)
+}
+
+func (s saisTypes) isS(i int) bool { return s[i>>6]&(1<<(i&63)) != 0 }
+func (s saisTypes) setS(i int)     { s[i>>6] |= 1 << (i & 63) }
+
+// isLMS reports whether suffix i is a leftmost S-type suffix.
+func (s saisTypes) isLMS(i int) bool {
+	return i > 0 && s.isS(i) && !s.isS(i-1)
+}
+
+// saisBuckets computes the start or the end of the buckets from the counts.
+func saisBuckets(bkt, cnt []int32, end bool) {
+	var sum int32
+	for c, n := range cnt {
+		sum += n
+		if end {
+			bkt[c] = sum
+		} else {
+			bkt[c] = sum - n
+		}
+	}
+}
+
+// sais computes the suffix array sa of t for the alphabet of size k. All
+// characters of t must be less than k.
+func sais[T byte | int32](t []T, sa []int32, k int) {
+	n := len(t)
+	switch n {
+	case 0:
+		return
+	case 1:
+		sa[0] = 0
+		return
+	}
+
+	// Classify the suffixes. The virtual sentinel at position n is smaller
+	// than all characters, so suffix n-1 has L-type.
+	types := newSAISTypes(n)
+	for i := n - 2; i >= 0; i-- {
+		if t[i] < t[i+1] || (t[i] == t[i+1] && types.isS(i+1)) {
+			types.setS(i)
+		}
+	}
+
+	cnt := make([]int32, k)
+	for _, c := range t {
+		cnt[c]++
+	}
+	bkt := make([]int32, k)
+
+	// Sort the LMS substrings by placing the LMS suffixes at the end of
+	// their buckets and inducing the other suffixes.
+	for i := range sa {
+		sa[i] = -1
+	}
+	saisBuckets(bkt, cnt, true)
+	for i := 1; i < n; i++ {
+		if types.isLMS(i) {
+			bkt[t[i]]--
+			sa[bkt[t[i]]] = int32(i)
+		}
+	}
+	saisInduce(t, sa, types, cnt, bkt)
+
+	// Move the sorted LMS substrings to the front of sa.
+	m := 0
+	for _, j := range sa {
+		if types.isLMS(int(j)) {
+			sa[m] = j
+			m++
+		}
+	}
+
+	// Name the LMS substrings. Since two LMS positions are at least two
+	// bytes apart, the name of position j can be stored at m + j/2.
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+	names := int32(0)
+	prev := -1
+	for _, j := range sa[:m] {
+		if prev < 0 || !saisEqualLMS(t, types, prev, int(j)) {
+			names++
+		}
+		prev = int(j)
+		sa[m+int(j)/2] = names - 1
+	}
+
+	// Collect the names in text order at the end of sa.
+	s1 := sa[n-m:]
+	for i, j := n-1, m-1; i >= m; i-- {
+		if sa[i] >= 0 {
+			s1[j] = sa[i]
+			j--
+		}
+	}
+
+	// Sort the reduced string. If all names are unique, the suffix array
+	// can be computed directly.
+	sa1 := sa[:m]
+	if int(names) < m {
+		sais(s1, sa1, int(names))
+	} else {
+		for i, c := range s1 {
+			sa1[c] = int32(i)
+		}
+	}
+
+	// Replace the indexes of the reduced string by the LMS positions.
+	for i, j := 1, 0; i < n; i++ {
+		if types.isLMS(i) {
+			s1[j] = int32(i)
+			j++
+		}
+	}
+	for i, j := range sa1 {
+		sa1[i] = s1[j]
+	}
+
+	// Place the sorted LMS suffixes at the end of their buckets and induce
+	// the suffix array.
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+	saisBuckets(bkt, cnt, true)
+	for i := m - 1; i >= 0; i-- {
+		j := sa[i]
+		sa[i] = -1
+		bkt[t[j]]--
+		sa[bkt[t[j]]] = j
+	}
+	saisInduce(t, sa, types, cnt, bkt)
+}
+
+// saisInduce induces the order of the L-type suffixes from the LMS suffixes
+// and then the order of the S-type suffixes from the L-type suffixes.
+func saisInduce[T byte | int32](t []T, sa []int32, types saisTypes,
+	cnt, bkt []int32) {
+	n := len(t)
+	saisBuckets(bkt, cnt, false)
+	// The suffix preceding the virtual sentinel comes first.
+	bkt[t[n-1]]++
+	sa[bkt[t[n-1]]-1] = int32(n - 1)
+	for i := 0; i < n; i++ {
+		j := sa[i] - 1
+		if j >= 0 && !types.isS(int(j)) {
+			sa[bkt[t[j]]] = j
+			bkt[t[j]]++
+		}
+	}
+	saisBuckets(bkt, cnt, true)
+	for i := n - 1; i >= 0; i-- {
+		j := sa[i] - 1
+		if j >= 0 && types.isS(int(j)) {
+			bkt[t[j]]--
+			sa[bkt[t[j]]] = j
+		}
+	}
+}
+
+// saisEqualLMS reports whether the LMS substrings starting at a and b are
+// equal. The substrings include the next LMS position.
+func saisEqualLMS[T byte | int32](t []T, types saisTypes, a, b int) bool {
+	n := len(t)
+	for d := 0; ; d++ {
+		if a+d == n || b+d == n {
+			// The virtual sentinel is unique.
+			return false
+		}
+		if t[a+d] != t[b+d] || types.isS(a+d) != types.isS(b+d) {
+			return false
+		}
+		if d > 0 {
+			la, lb := types.isLMS(a+d), types.isLMS(b+d)
+			if la || lb {
+				return la && lb
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package suffix
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSortSAIS(t *testing.T) {
+	tests := []string{
+		"",
+		"a",
+		"aa",
+		"ab",
+		"ba",
+		"aaaaaaaa",
+		"abbaabbaabbaabba",
+		"ababababababababac",
+		"cdcdcdcdccdd$",
+		"banana",
+		"mississippi",
+		"christmas",
+		"cba",
+		"The brown fox jumps over the lazy dog.",
+		"<mediawiki xmlns=\"http://www.mediawik",
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			text := []byte(tc)
+			sa := make([]int32, len(text))
+			SortSAIS(text, sa)
+			if err := verifySuffixArray(text, sa); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSortSAISEnwik(t *testing.T) {
+	data, err := getData(testFile)
+	if err != nil {
+		t.Fatalf("getData(%q) error %s", testFile, err)
+	}
+	sa := make([]int32, len(data))
+	SortSAIS(data, sa)
+	want := make([]int32, len(data))
+	Sort(data, want)
+	if !slices.Equal(sa, want) {
+		t.Fatalf("SortSAIS and Sort differ for %s", testFile)
+	}
+}
+
+func FuzzSortSAIS(f *testing.F) {
+	f.Add([]byte("mississippi"))
+	f.Add([]byte("abbaabbaabbaabba"))
+	f.Add([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sa := make([]int32, len(data))
+		SortSAIS(data, sa)
+		want := make([]int32, len(data))
+		Sort(data, want)
+		if !slices.Equal(sa, want) {
+			t.Fatalf("SortSAIS(%q) = %v; want %v", data, sa, want)
+		}
+	})
+}
+
+func BenchmarkSortSAIS(b *testing.B) {
+	enwik7, err := os.ReadFile(testFile)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", testFile, err)
+	}
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, len(enwik7))
+	r.Read(random)
+	low := make([]byte, len(enwik7))
+	for i := range low {
+		low[i] = "ab"[r.Intn(8)/7]
+	}
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"text", enwik7},
+		{"random", random},
+		{"lowEntropy", low},
+	}
+	sorts := []struct {
+		name string
+		sort func(t []byte, sa []int32)
+	}{
+		{"DivSufSort", Sort},
+		{"SAIS", SortSAIS},
+	}
+	for _, in := range inputs {
+		for _, n := range []int{1 << 10, 1 << 20, 10 << 20} {
+			data := in.data[:min(n, len(in.data))]
+			sa := make([]int32, len(data))
+			for _, s := range sorts {
+				name := fmt.Sprintf("%s/%s/%dKiB", s.name,
+					in.name, n>>10)
+				b.Run(name, func(b *testing.B) {
+					b.SetBytes(int64(len(data)))
+					for i := 0; i < b.N; i++ {
+						s.sort(data, sa)
+					}
+				})
+			}
+		}
+	}
+}
@@ -28,11 +28,15 @@ scan:
 		} else {
 			n = -1
 		}
+		// start is the start of a new segment. If segments have been
+		// removed from the stack, the new segment starts at the start
+		// of the last segment removed.
+		start := j - 1
 		for {
 			top := stack[len(stack)-1]
 			switch {
 			case n > top.n:
-				stack = append(stack, item{n, j - 1})
+				stack = append(stack, item{n, start})
 				continue scan
 			case n == top.n:
 				continue scan
@@ -40,6 +44,7 @@ scan:
 			if top.n >= minLen {
 				f(int(top.n), sa[top.j:j])
 			}
+			start = top.j
 			stack = stack[:len(stack)-1]
 			if len(stack) == 0 {
 				break scan
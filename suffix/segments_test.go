@@ -50,3 +50,52 @@ func TestSegments(t *testing.T) {
 	}
 
 }
+
+// TestSegmentsComplete checks that every pair of suffixes is reported in a
+// segment with the length of their common prefix. Segments must start at
+// the start of the segments removed from the stack before them; otherwise
+// suffixes like 15 and 16 of "=====foofoobarfoobar bartender====" are
+// missed.
+func TestSegmentsComplete(t *testing.T) {
+	const minLen, maxLen = 1, 4
+	tests := []string{
+		"abbababb",
+		"mississippi",
+		"=====foofoobarfoobar bartender====",
+		"aaaaaaaaaa",
+	}
+	for _, tc := range tests {
+		p := []byte(tc)
+		sa := make([]int32, len(p))
+		Sort(p, sa)
+		lcp := make([]int32, len(p))
+		LCP(p, sa, nil, lcp)
+		type pair struct{ a, b int32 }
+		found := make(map[pair]int)
+		Segments(sa, lcp, minLen, maxLen, func(m int, s []int32) {
+			for _, a := range s {
+				for _, b := range s {
+					if a >= b {
+						continue
+					}
+					if k := found[pair{a, b}]; m > k {
+						found[pair{a, b}] = m
+					}
+				}
+			}
+		})
+		for a := 0; a < len(p); a++ {
+			for b := a + 1; b < len(p); b++ {
+				n := min(matchLen(p[a:], p[b:]), maxLen)
+				if n < minLen {
+					n = 0
+				}
+				if k := found[pair{int32(a), int32(b)}]; k != n {
+					t.Errorf("%q: suffixes %d and %d in"+
+						" segment with length %d; want %d",
+						tc, a, b, k, n)
+				}
+			}
+		}
+	}
+}
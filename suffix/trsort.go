@@ -7,6 +7,12 @@ package suffix
 import "fmt"
 
 func (cfg config) trSort(sa []int32, isa []int32) {
+	var s stack
+	cfg.trSortStack(sa, isa, &s)
+}
+
+// trSortStack sorts like trSort but reuses the memory of the stack stk.
+func (cfg config) trSortStack(sa []int32, isa []int32, stk *stack) {
 	if cfg.trSizeThreshold == 0 {
 		cfg.trSizeThreshold = 8
 	}
@@ -35,7 +41,7 @@ func (cfg config) trSort(sa []int32, isa []int32) {
 				if b-f > 1 {
 					budget.count = 0
 					cfg.trIntroSort(sa, isa, depth, f, b,
-						&budget)
+						&budget, stk)
 					if budget.count != 0 {
 						unsorted += budget.count
 					} else {
@@ -74,8 +80,13 @@ func (s *stack) pop() (a, b, c, d, e int) {
 	return entry.a, entry.b, entry.c, entry.d, entry.e
 }
 
-func (cfg config) trIntroSort(sa, isa []int32, depth, first, last int, budget *budget) {
-	s := make(stack, 0, 96)
+func (cfg config) trIntroSort(sa, isa []int32, depth, first, last int, budget *budget, stk *stack) {
+	s := (*stk)[:0]
+	if cap(s) < 96 {
+		s = make(stack, 0, 96)
+	}
+	// Keep the stack memory for the next call.
+	defer func() { *stk = s[:0] }()
 	var (
 		a, b, c int
 		v       int32
@@ -0,0 +1,362 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"fmt"
+)
+
+// THPConfig provides the configuration parameters for the TripleHashParser.
+// The input lengths of the three hash tables must be increasing. The
+// defaults are 3, 5 and 8.
+type THPConfig struct {
+	ShrinkSize int
+	BufferSize int
+	WindowSize int
+	BlockSize  int
+
+	InputLen1 int
+	HashBits1 int
+	InputLen2 int
+	HashBits2 int
+	InputLen3 int
+	HashBits3 int
+}
+
+// Clone creates a copy of the configuration.
+func (cfg *THPConfig) Clone() ParserConfig {
+	x := *cfg
+	return &x
+}
+
+// UnmarshalJSON parses the JSON value and sets the fields of THPConfig.
+func (cfg *THPConfig) UnmarshalJSON(p []byte) error {
+	*cfg = THPConfig{}
+	return unmarshalJSON(cfg, "THP", p)
+}
+
+// MarshalJSON creates the JSON string for the configuration. Note that it adds
+// a property Type with value "THP" to the structure.
+func (cfg *THPConfig) MarshalJSON() (p []byte, err error) {
+	return marshalJSON(cfg, "THP")
+}
+
+// BufConfig returns the [BufConfig] value containing the buffer parameters.
+func (cfg *THPConfig) BufConfig() BufConfig {
+	bc := bufferConfig(cfg)
+	return bc
+}
+
+// SetBufConfig sets the buffer configuration parameters of the parser
+// configuration.
+func (cfg *THPConfig) SetBufConfig(bc BufConfig) {
+	setBufferConfig(cfg, bc)
+}
+
+// thConfig returns the hash table configuration.
+func (cfg *THPConfig) thConfig() thConfig {
+	return thConfig{
+		H1: hashConfig{InputLen: cfg.InputLen1, HashBits: cfg.HashBits1},
+		H2: hashConfig{InputLen: cfg.InputLen2, HashBits: cfg.HashBits2},
+		H3: hashConfig{InputLen: cfg.InputLen3, HashBits: cfg.HashBits3},
+	}
+}
+
+// Verify checks the configuration for errors.
+func (cfg *THPConfig) Verify() error {
+	var err error
+	bc := bufferConfig(cfg)
+	if err = bc.Verify(); err != nil {
+		return err
+	}
+	t := cfg.thConfig()
+	if err = t.Verify(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetDefaults uses the defaults for the configuration parameters that are set
+// to zero.
+func (cfg *THPConfig) SetDefaults() {
+	bc := bufferConfig(cfg)
+	bc.SetDefaults()
+	setBufferConfig(cfg, bc)
+	t := cfg.thConfig()
+	t.SetDefaults()
+	cfg.InputLen1, cfg.HashBits1 = t.H1.InputLen, t.H1.HashBits
+	cfg.InputLen2, cfg.HashBits2 = t.H2.InputLen, t.H2.HashBits
+	cfg.InputLen3, cfg.HashBits3 = t.H3.InputLen, t.H3.HashBits
+}
+
+// NewParser creates a new TripleHashParser.
+func (cfg THPConfig) NewParser() (s Parser, err error) {
+	ths := new(tripleHashParser)
+	if err = ths.init(cfg); err != nil {
+		return nil, err
+	}
+	return ths, nil
+}
+
+// tripleHashParser generates LZ77 sequences by using three hash tables with
+// different input lengths. All tables are probed at every position and the
+// longest match found is used. The parser is slower than the
+// DoubleHashParser, but achieves a better compression ratio, particularly
+// for binary and structured data.
+type tripleHashParser struct {
+	tripleHashDictionary
+	seqHistograms
+	statsAccumulator
+	hm hashMetrics
+	// h1Hits, h2Hits and h3Hits count the hits of the individual hash
+	// tables.
+	h1Hits int64
+	h2Hits int64
+	h3Hits int64
+
+	// maxMatchLen limits the length of matches if it is positive.
+	maxMatchLen int
+
+	THPConfig
+}
+
+// init initializes the TripleHashParser. The first error found in the
+// configuration will be returned.
+func (s *tripleHashParser) init(cfg THPConfig) error {
+	cfg.SetDefaults()
+	var err error
+	if err = cfg.Verify(); err != nil {
+		return err
+	}
+
+	bc := bufferConfig(&cfg)
+	if err = s.tripleHashDictionary.init(cfg.thConfig(), bc); err != nil {
+		return err
+	}
+	s.THPConfig = cfg
+	return nil
+}
+
+// ParserConfig returns [THPConfig]
+func (s *tripleHashParser) ParserConfig() ParserConfig {
+	return &s.THPConfig
+}
+
+// Reset puts the parser into its initial state with the data slice as buffer
+// content. The histograms are cleared.
+func (s *tripleHashParser) Reset(data []byte) error {
+	var err error
+	if err = s.tripleHashDictionary.Reset(data); err != nil {
+		return err
+	}
+	s.seqHistograms.reset()
+	return nil
+}
+
+// CopyState makes the state of the parser a deep copy of the state of src,
+// which must be a parser of the same type with the same configuration. A
+// parser wrapped for profiler labels is unwrapped. Otherwise an error
+// wrapping [ErrIncompatibleConfig] is returned.
+func (s *tripleHashParser) CopyState(src Parser) error {
+	t, ok := unwrapParser(src).(*tripleHashParser)
+	if !ok {
+		return fmt.Errorf("%w: can't copy state from %T to %T",
+			ErrIncompatibleConfig, src, s)
+	}
+	if s.THPConfig != t.THPConfig {
+		return fmt.Errorf("%w: THPConfig %+v differs from %+v",
+			ErrIncompatibleConfig, t.THPConfig, s.THPConfig)
+	}
+	s.tripleHashDictionary.copyFrom(&t.tripleHashDictionary)
+	s.seqHistograms.copyFrom(&t.seqHistograms)
+	s.statsAccumulator = t.statsAccumulator
+	s.hm = t.hm
+	s.h1Hits, s.h2Hits, s.h3Hits = t.h1Hits, t.h2Hits, t.h3Hits
+	s.maxMatchLen = t.maxMatchLen
+	return nil
+}
+
+// Metrics returns the performance data of the parser. In addition to the
+// keys of all parsers it provides hash_hits and hash_misses as well as
+// h1_hits, h2_hits and h3_hits for the hits of the individual hash tables.
+func (s *tripleHashParser) Metrics() map[string]int64 {
+	m := s.statsAccumulator.Metrics()
+	s.hm.addMetrics(m)
+	m["h1_hits"] = s.h1Hits
+	m["h2_hits"] = s.h2Hits
+	m["h3_hits"] = s.h3Hits
+	return m
+}
+
+// ResetMetrics clears the counters reported by Metrics.
+func (s *tripleHashParser) ResetMetrics() {
+	s.statsAccumulator.ResetMetrics()
+	s.hm = hashMetrics{}
+	s.h1Hits, s.h2Hits, s.h3Hits = 0, 0, 0
+}
+
+// SetMaxMatchLen limits the length of the matches generated by Parse to n
+// bytes. The value zero removes the limit. The limit must not be smaller than
+// InputLen1.
+func (s *tripleHashParser) SetMaxMatchLen(n int) error {
+	if n < 0 {
+		return fmt.Errorf("lz: maxMatchLen %d must not be negative", n)
+	}
+	if n > 0 && n < s.InputLen1 {
+		return fmt.Errorf("lz: maxMatchLen %d < InputLen1 %d",
+			n, s.InputLen1)
+	}
+	s.maxMatchLen = n
+	return nil
+}
+
+// MatchLen returns the minimum length of the matches generated by Parse. It
+// is determined by the shortest hash, InputLen1, and is at most 3.
+func (s *tripleHashParser) MatchLen() int {
+	return min(s.h1.inputLen, 3)
+}
+
+// matchLen returns the length of the match of position i with the candidate
+// position j in p. It returns zero if j is outside of the window.
+func (s *tripleHashParser) matchLen(p []byte, i, j int) int {
+	o := i - j
+	if !(0 < o && o <= s.WindowSize) {
+		return 0
+	}
+	return lcp(p[j:], p[i:])
+}
+
+// Parse generates the LZ77 sequences. It returns the number of bytes covered
+// by the new sequences. The block will be overwritten but the memory for the
+// slices will be reused.
+func (s *tripleHashParser) Parse(blk *Block, flags int) (n int, err error) {
+	n = len(s.Data) - s.W
+	if s.BlockSize < n {
+		n = s.BlockSize
+	}
+	if blk == nil {
+		if n == 0 {
+			return 0, ErrEmptyBuffer
+		}
+		t := s.W + n
+		s.processSegment(s.W-s.h3.inputLen+1, t)
+		s.W = t
+		return n, nil
+	}
+
+	blk.Reset()
+
+	if n == 0 {
+		return 0, ErrEmptyBuffer
+	}
+
+	s.processSegment(s.W-s.h3.inputLen+1, s.W)
+	p := s.Data[:s.W+n]
+
+	e1 := len(p) - s.h1.inputLen + 1
+	e2 := len(p) - s.h2.inputLen + 1
+	e3 := len(p) - s.h3.inputLen + 1
+	i := s.W
+	litIndex := i
+	var h1Hits, h2Hits, h3Hits, misses int64
+
+	minMatchLen := s.MatchLen()
+
+	// Ensure that we can use _getLE64 all the time.
+	_p := s.Data[:e1+7]
+
+	for ; i < e1; i++ {
+		y := _getLE64(_p[i:])
+		pos := uint32(i)
+		// The tables are probed in the order of decreasing input
+		// length. A later table is only used for a longer match.
+		k, j := 0, -1
+		hit := false
+		if i < e3 {
+			x := y & s.h3.mask
+			h := hashValue(x, s.h3.shift)
+			entry := s.h3.table[h]
+			v := uint32(x)
+			s.h3.table[h] = hashEntry{pos: pos, value: v}
+			if v == entry.value {
+				hit = true
+				h3Hits++
+				if m := s.matchLen(p, i, int(entry.pos)); m > k {
+					k, j = m, int(entry.pos)
+				}
+			}
+		}
+		if i < e2 {
+			x := y & s.h2.mask
+			h := s.hashValue2(x)
+			entry := s.h2.table[h]
+			v := uint32(x)
+			s.h2.table[h] = hashEntry{pos: pos, value: v}
+			if v == entry.value {
+				hit = true
+				h2Hits++
+				if int(entry.pos) != j {
+					m := s.matchLen(p, i, int(entry.pos))
+					if m > k {
+						k, j = m, int(entry.pos)
+					}
+				}
+			}
+		}
+		x := y & s.h1.mask
+		h := s.hashValue1(x)
+		entry := s.h1.table[h]
+		v := uint32(x)
+		s.h1.table[h] = hashEntry{pos: pos, value: v}
+		if v == entry.value {
+			hit = true
+			h1Hits++
+			if int(entry.pos) != j {
+				m := s.matchLen(p, i, int(entry.pos))
+				if m > k {
+					k, j = m, int(entry.pos)
+				}
+			}
+		}
+		if !hit {
+			misses++
+			continue
+		}
+		if k < minMatchLen {
+			continue
+		}
+		if s.maxMatchLen > 0 && k > s.maxMatchLen {
+			k = s.maxMatchLen
+		}
+		q := p[litIndex:i]
+		blk.Sequences = append(blk.Sequences,
+			Seq{
+				LitLen:   uint32(len(q)),
+				MatchLen: uint32(k),
+				Offset:   uint32(i - j),
+			})
+		blk.Literals = append(blk.Literals, q...)
+		litIndex = i + k
+		s.processSegment(i+1, litIndex)
+		i = litIndex - 1
+	}
+
+	if flags&NoTrailingLiterals != 0 && len(blk.Sequences) > 0 {
+		i = litIndex
+	} else {
+		blk.Literals = append(blk.Literals, p[litIndex:]...)
+		i = len(p)
+	}
+	n = int(i) - s.W
+	s.W = int(i)
+	s.seqHistograms.add(blk.Sequences)
+	s.h1Hits += h1Hits
+	s.h2Hits += h2Hits
+	s.h3Hits += h3Hits
+	s.hm.hits += h1Hits + h2Hits + h3Hits
+	s.hm.misses += misses
+	s.statsAccumulator.addBlock(n, blk)
+	return n, nil
+}
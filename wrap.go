@@ -21,8 +21,9 @@ func Wrap(r io.Reader, seq Parser) *WrappedParser {
 // WrappedParser is returned by the Wrap function. It provides the Parse
 // method and reads the data required automatically from the stored reader.
 type WrappedParser struct {
-	r io.Reader
-	s Parser
+	r   io.Reader
+	s   Parser
+	eof bool
 }
 
 // Parse creates a block of sequences but reads the required data from the
@@ -35,7 +36,11 @@ func (s *WrappedParser) Parse(blk *Block, flags int) (n int, err error) {
 			return n, err
 		}
 		s.s.Shrink()
-		if k, err := s.s.ReadFrom(s.r); k == 0 {
+		k, err := s.s.ReadFrom(s.r)
+		if err == io.EOF {
+			s.eof = true
+		}
+		if k == 0 {
 			if err == ErrFullBuffer {
 				panic("unexpected ErrFullBuffer")
 			}
@@ -51,4 +56,11 @@ func (s *WrappedParser) Reset(r io.Reader) {
 		panic(err)
 	}
 	s.r = r
+	s.eof = false
+}
+
+// EOF returns whether the wrapped reader has been exhausted. Data read before
+// the end of the reader may still be buffered in the parser.
+func (s *WrappedParser) EOF() bool {
+	return s.eof
 }
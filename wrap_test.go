@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: © 2021 Ulrich Kunitz
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package lz
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWrap(t *testing.T) {
+	const enwik7 = "testdata/enwik7"
+	data, err := os.ReadFile(enwik7)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error %s", enwik7, err)
+	}
+	data = data[:100*kiB]
+
+	const windowSize = 32 * kiB
+	configs := []ParserConfig{
+		&HPConfig{WindowSize: windowSize, InputLen: 3},
+		&BHPConfig{WindowSize: windowSize},
+		&DHPConfig{WindowSize: windowSize},
+		&BDHPConfig{WindowSize: windowSize},
+		&BUPConfig{WindowSize: windowSize},
+		&GSAPConfig{WindowSize: windowSize},
+		&OSAPConfig{WindowSize: windowSize},
+	}
+	for _, cfg := range configs {
+		p := newTestParser(t, cfg)
+		// OneByteReader enforces partial reads.
+		s := Wrap(iotest.OneByteReader(bytes.NewReader(data)), p)
+		for i := 0; i < 2; i++ {
+			if s.EOF() {
+				t.Fatalf("%T: s.EOF() returned true before"+
+					" parsing", p)
+			}
+			var buf bytes.Buffer
+			d, err := NewDecoder(&buf,
+				DecoderConfig{WindowSize: windowSize})
+			if err != nil {
+				t.Fatalf("NewDecoder error %s", err)
+			}
+			var blk Block
+			for {
+				if _, err = s.Parse(&blk, 0); err != nil {
+					if err == io.EOF {
+						break
+					}
+					t.Fatalf("%T: s.Parse error %s", p, err)
+				}
+				if _, _, _, err = d.WriteBlock(blk); err != nil {
+					t.Fatalf("d.WriteBlock error %s", err)
+				}
+			}
+			if err = d.Flush(); err != nil {
+				t.Fatalf("d.Flush error %s", err)
+			}
+			if !s.EOF() {
+				t.Fatalf("%T: s.EOF() returned false after"+
+					" parsing", p)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("%T: decoded data differs from input",
+					p)
+			}
+			s.Reset(bytes.NewReader(data))
+		}
+	}
+}